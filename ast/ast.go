@@ -21,6 +21,10 @@ type Node struct {
 	Ctx         *AstContext
 	Start       lexer.Token // first token that initiates the corresponding ast struct
 	InferedType SymbolType  // type inferenced or contained in Expr
+
+	// first and last tokens of an expression as written, which are unset for
+	// other nodes and for those made up by sema
+	First, Last lexer.Token
 }
 
 func (n *Node) Repr() string {
@@ -35,6 +39,14 @@ func (n *Node) GetType() SymbolType {
 	return n.InferedType
 }
 
+func (n *Node) SetRange(first, last lexer.Token) {
+	n.First, n.Last = first, last
+}
+
+func (n *Node) Range() (lexer.Token, lexer.Token) {
+	return n.First, n.Last
+}
+
 type TranslationUnit struct {
 	Node
 	Filename string
@@ -50,6 +62,19 @@ func (tu *TranslationUnit) Repr() string {
 type Expression interface {
 	Ast
 	GetType() SymbolType
+	SetRange(first, last lexer.Token)
+	Range() (lexer.Token, lexer.Token)
+}
+
+// the source range of e, which is taken from the expression wrapped by an
+// implicit cast of sema
+func ExprRange(e Expression) (lexer.Token, lexer.Token) {
+	var first, last = e.Range()
+	for cast, yes := e.(*ImplicitCastExpr); yes && first.Line == 0; cast, yes = e.(*ImplicitCastExpr) {
+		e = cast.Expr
+		first, last = e.Range()
+	}
+	return first, last
 }
 
 type IntLiteralExpr struct {
@@ -61,6 +86,15 @@ func (self *IntLiteralExpr) Repr() string {
 	return fmt.Sprintf("IntLit(%v)", self.Tok.AsString())
 }
 
+type FloatLiteralExpr struct {
+	Node
+	Tok lexer.Token
+}
+
+func (self *FloatLiteralExpr) Repr() string {
+	return fmt.Sprintf("FloatLit(%v)", self.Tok.AsString())
+}
+
 type CharLiteralExpr struct {
 	Node
 	Tok lexer.Token
@@ -167,6 +201,19 @@ type FunctionCall struct {
 	Args []Expression
 }
 
+// compiler builtins such as __builtin_va_start, Type is the type operand
+// of __builtin_va_arg
+type BuiltinCallExpr struct {
+	Node
+	Name string
+	Args []Expression
+	Type SymbolType
+}
+
+func (self *BuiltinCallExpr) Repr() string {
+	return fmt.Sprintf("BuiltinCall(%s)", self.Name)
+}
+
 // inspired from llvm, to distinguished from BinaryOp
 type CompoundAssignExpr struct {
 	Node
@@ -200,6 +247,10 @@ const (
 	IntegralCast
 	LValueToRValueCast
 	FloatingToIntegralCast
+	IntegralToFloatingCast
+	FloatingCast
+	NullToPointerCast
+	BitCast
 )
 
 func (ck CastKind) String() string {
@@ -214,6 +265,14 @@ func (ck CastKind) String() string {
 		return "LValueToRValueCast"
 	case FloatingToIntegralCast:
 		return "FloatingToIntegralCast"
+	case IntegralToFloatingCast:
+		return "IntegralToFloatingCast"
+	case FloatingCast:
+		return "FloatingCast"
+	case NullToPointerCast:
+		return "NullToPointerCast"
+	case BitCast:
+		return "BitCast"
 	}
 	return ""
 }
@@ -372,6 +431,7 @@ type CaseStmt struct {
 	Node
 	ConstExpr Expression
 	Stmt      Statement
+	Value     int64 // ConstExpr evaluated by sema in the promoted type of switch condition
 }
 
 func (self *CaseStmt) Repr() string {
@@ -507,15 +567,16 @@ func WalkAst(top Ast, wk AstWalker, cont ...interface{}) interface{} {
 		ctx = cont[0].(*WalkContext)
 	}
 
+	// scopes saves the enclosing scope of each pushed one
 	var Pop = func() *SymbolScope {
-		sc := scopes[len(scopes)-1]
+		sc := ctx.Scope
+		ctx.Scope = scopes[len(scopes)-1]
 		scopes = scopes[:len(scopes)-1]
-		ctx.Scope = sc
 		return sc
 	}
 
 	var Push = func(sc *SymbolScope) {
-		scopes = append(scopes, sc)
+		scopes = append(scopes, ctx.Scope)
 		ctx.Scope = sc
 	}
 
@@ -559,6 +620,14 @@ func WalkAst(top Ast, wk AstWalker, cont ...interface{}) interface{} {
 				return
 			}
 
+		case *FloatLiteralExpr:
+			if !tryCall(WalkerPropagate, ast) {
+				return
+			}
+			if !tryCall(WalkerBubbleUp, ast) {
+				return
+			}
+
 		case *CharLiteralExpr:
 			if !tryCall(WalkerPropagate, ast) {
 				return
@@ -663,6 +732,18 @@ func WalkAst(top Ast, wk AstWalker, cont ...interface{}) interface{} {
 				return
 			}
 
+		case *BuiltinCallExpr:
+			e := ast.(*BuiltinCallExpr)
+			if !tryCall(WalkerPropagate, ast) {
+				return
+			}
+			for _, arg := range e.Args {
+				visit(arg)
+			}
+			if !tryCall(WalkerBubbleUp, ast) {
+				return
+			}
+
 		case *CompoundAssignExpr:
 			var e = ast.(*CompoundAssignExpr)
 			if !tryCall(WalkerPropagate, ast) {
@@ -1002,14 +1083,60 @@ const (
 	Info ReportKind = iota
 	Warning
 	Error
+	Note // attached to another report
 )
 
 type Report struct {
 	Kind ReportKind
 	lexer.Token
 	Desc string
+	ID   string // stable name of the diagnostic, e.g. sema.undeclared-identifier
+
+	File   string    // of Token, set when the report leaves its unit
+	Notes  []*Report // such as where a symbol is previously declared
+	FixIts []FixIt
+
+	// source range of the expression reported, which ends right before End,
+	// unset if only Token is reported
+	Begin, End lexer.Location
+}
+
+// a suggested edit of the source, which replaces Len bytes at Location by
+// Text, or inserts Text if Len is 0
+type FixIt struct {
+	lexer.Location
+	Len  int
+	Text string
 }
 
 func MakeReport(kd ReportKind, tk lexer.Token, desc string) *Report {
-	return &Report{kd, tk, desc}
+	return &Report{Kind: kd, Token: tk, Desc: desc}
+}
+
+// attach a note at tk to r, which is returned
+func (r *Report) AddNote(tk lexer.Token, desc string) *Report {
+	r.Notes = append(r.Notes, MakeReport(Note, tk, desc))
+	return r
+}
+
+// report the source range of e besides the token of r, which is returned
+func (r *Report) AddRange(e Expression) *Report {
+	var first, last = ExprRange(e)
+	if first.Line > 0 && last.Offset >= first.Offset {
+		r.Begin, r.End = first.Location, last.Location
+		r.End.Offset += int64(TokenWidth(last))
+		r.End.Column += TokenWidth(last)
+	}
+	return r
+}
+
+// suggest replacing tk by text, or inserting text right after tk if insert
+func (r *Report) AddFixIt(tk lexer.Token, text string, insert bool) *Report {
+	var fix = FixIt{Location: tk.Location, Len: TokenWidth(tk), Text: text}
+	if insert {
+		fix.Column += fix.Len
+		fix.Len = 0
+	}
+	r.FixIts = append(r.FixIts, fix)
+	return r
 }
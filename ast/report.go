@@ -0,0 +1,230 @@
+package ast
+
+// reports of all stages are rendered alike, as
+//
+//	file:line:col: error: desc
+//	<line of source>
+//	      ~~~^~~~~
+//	         fix-it
+//
+// where ^ marks the token reported, within the source range around it, and
+// followed by their notes
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yanhao/sc/lexer"
+)
+
+// text of sources by file name, from which lines of reports are quoted
+var sources = make(map[string][]byte)
+
+// whether reports are rendered with colors, by default if stderr is a
+// terminal
+var ColorReports = isTerminal(os.Stderr)
+
+func isTerminal(f *os.File) bool {
+	var fi, err = f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func AddSource(file string, text []byte) {
+	sources[file] = text
+}
+
+// the name of a unit read from stdin is empty
+func DisplayName(file string) string {
+	if file == "" {
+		return "<stdin>"
+	}
+	return file
+}
+
+func (kd ReportKind) String() string {
+	switch kd {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	case Note:
+		return "note"
+	}
+	return ""
+}
+
+// number of bytes tk takes in the source
+func TokenWidth(tk lexer.Token) int {
+	var n = len(tk.AsString())
+	if tk.Kind == lexer.STR_LITERAL || tk.Kind == lexer.CHAR_LITERAL {
+		n += 2
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// reports of a unit are made without file names, which are set to file here
+func SetReportsFile(reports []*Report, file string) {
+	for _, r := range reports {
+		if r.File == "" {
+			r.File = file
+		}
+		SetReportsFile(r.Notes, r.File)
+	}
+}
+
+const (
+	colorBold   = "\033[1m"
+	colorRed    = "\033[1;31m"
+	colorPurple = "\033[1;35m"
+	colorCyan   = "\033[1;36m"
+	colorGreen  = "\033[1;32m"
+	colorReset  = "\033[0m"
+)
+
+func (kd ReportKind) color() string {
+	switch kd {
+	case Warning:
+		return colorPurple
+	case Error:
+		return colorRed
+	}
+	return colorCyan
+}
+
+// the line of source at lineno, counted from 1
+func sourceLine(file string, lineno int) (string, bool) {
+	var text, ok = sources[file]
+	if !ok || lineno < 1 {
+		return "", false
+	}
+	for ; lineno > 1; lineno-- {
+		var i = bytes.IndexByte(text, '\n')
+		if i < 0 {
+			return "", false
+		}
+		text = text[i+1:]
+	}
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimSuffix(string(text), "\r"), true
+}
+
+// the column in code points of col in bytes, both counted from 0, in line
+// lineno of file, which is col if the source is unknown
+func CodePointColumn(file string, lineno, col int) int {
+	var line, ok = sourceLine(file, lineno)
+	switch {
+	case !ok:
+		return col
+	case col > len(line):
+		return utf8.RuneCountInString(line) + col - len(line)
+	}
+	return utf8.RuneCountInString(line[:col])
+}
+
+// blanks up to col of line, which keep its tabs to line up with it
+func indent(line string, col int) string {
+	var sb strings.Builder
+	for i := 0; i < col; i++ {
+		if i < len(line) && line[i] == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
+
+// columns of the line of r underlined, from begin up to end, which cover
+// both the token and the range of r. a range over many lines is cut at the
+// line
+func underline(r *Report, length int) (int, int) {
+	var begin, end = r.Column, r.Column + TokenWidth(r.Token)
+	if r.End.Line > 0 {
+		switch {
+		case r.Begin.Line < r.Line:
+			begin = 0
+		case r.Begin.Line == r.Line && r.Begin.Column < begin:
+			begin = r.Begin.Column
+		}
+		switch {
+		case r.End.Line > r.Line:
+			end = length
+		case r.End.Line == r.Line && r.End.Column > end:
+			end = r.End.Column
+		}
+	}
+	if end > length {
+		end = length
+	}
+	if end <= r.Column {
+		end = r.Column + 1
+	}
+	return begin, end
+}
+
+func RenderReport(w io.Writer, r *Report) {
+	var paint = func(color, s string) string {
+		if ColorReports {
+			return color + s + colorReset
+		}
+		return s
+	}
+
+	var loc = DisplayName(r.File)
+	if r.Line > 0 {
+		loc = fmt.Sprintf("%s:%d:%d", loc, r.Line, r.Column+1)
+	}
+	var desc = r.Desc
+	if opt := optionOf(r); opt != "" {
+		desc += " [" + opt + "]"
+	}
+	fmt.Fprintf(w, "%s %s %s\n", paint(colorBold, loc+":"), paint(r.Kind.color(), r.Kind.String()+":"),
+		paint(colorBold, desc))
+
+	if line, ok := sourceLine(r.File, r.Line); ok && r.Column <= len(line) {
+		var begin, end = underline(r, len(line))
+		var marker = strings.Repeat("~", r.Column-begin) + "^" + strings.Repeat("~", end-r.Column-1)
+		fmt.Fprintf(w, "%s\n", line)
+		fmt.Fprintf(w, "%s%s\n", indent(line, begin), paint(colorGreen, marker))
+
+		// fix-its in other lines are left out
+		for _, fix := range r.FixIts {
+			if fix.Line == r.Line {
+				fmt.Fprintf(w, "%s%s\n", indent(line, fix.Column), paint(colorGreen, fix.Text))
+			}
+		}
+	}
+
+	for _, n := range r.Notes {
+		RenderReport(w, n)
+	}
+}
+
+// reports are collected instead if CollectReports, to be serialized at once
+// by the driver
+var (
+	CollectReports bool
+	Collected      []*Report
+)
+
+// render reports to stderr
+func DumpReports(reports []*Report) {
+	if CollectReports {
+		Collected = append(Collected, reports...)
+		return
+	}
+	for _, r := range reports {
+		RenderReport(os.Stderr, r)
+	}
+}
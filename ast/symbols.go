@@ -78,6 +78,23 @@ func IsIntegralType(ty SymbolType) bool {
 	}
 }
 
+func IsFloatingType(ty SymbolType) bool {
+	switch ty.(type) {
+	case *FloatType, *DoubleType:
+		return true
+	default:
+		return false
+	}
+}
+
+// plain char is signed (x86-64)
+func IsUnsigned(ty SymbolType) bool {
+	if it, ok := ty.(*IntegerType); ok {
+		return it.Unsigned
+	}
+	return false
+}
+
 func TypeAssertEq(real, expected SymbolType, msg string) {
 	if !IsTypeEq(real, expected) {
 		panic(msg)
@@ -120,6 +137,62 @@ func IsTypeCompat(real, expected SymbolType) bool {
 	return t1.Name() == t2.Name()
 }
 
+// composite type of two declarations of the same object or function, which
+// must be compatible (C99 6.2.7). an array of unknown size takes the size of
+// the other one, and a function without parameter list takes the parameters
+func CompositeType(t1, t2 SymbolType) (SymbolType, bool) {
+	switch ty1 := t1.(type) {
+	case *Array:
+		ty2, yes := t2.(*Array)
+		if !yes || ty1.Level != ty2.Level || ty1.ElemType.String() != ty2.ElemType.String() {
+			return nil, false
+		}
+		for i := 1; i < ty1.Level; i++ {
+			var l1, l2 = ty1.LenExprs[i].(*IntLiteralExpr), ty2.LenExprs[i].(*IntLiteralExpr)
+			if l1.Tok.AsInt() != l2.Tok.AsInt() {
+				return nil, false
+			}
+		}
+		switch {
+		case ty1.Len() < 0:
+			return ty2, true
+		case ty2.Len() < 0 || ty1.Len() == ty2.Len():
+			return ty1, true
+		}
+		return nil, false
+
+	case *Function:
+		ty2, yes := t2.(*Function)
+		if !yes || ty1.Return.String() != ty2.Return.String() {
+			return nil, false
+		}
+		switch {
+		case len(ty1.Args) == 0 && !ty1.IsVariadic:
+			return ty2, true
+		case len(ty2.Args) == 0 && !ty2.IsVariadic:
+			return ty1, true
+		case len(ty1.Args) != len(ty2.Args) || ty1.IsVariadic != ty2.IsVariadic:
+			return nil, false
+		}
+
+		// parameters of array type are pointers indeed
+		var param = func(ty SymbolType) string {
+			if aty, yes := ty.(*Array); yes {
+				return (&Pointer{aty.Elem()}).String()
+			}
+			return ty.String()
+		}
+		for i := range ty1.Args {
+			if param(ty1.Args[i]) != param(ty2.Args[i]) {
+				return nil, false
+			}
+		}
+		return ty2, true
+	}
+
+	return t1, t1.String() == t2.String()
+}
+
 // decorate base type with qualifier
 type QualifiedType struct {
 	Base SymbolType
@@ -134,6 +207,42 @@ func (q *QualifiedType) String() string {
 	}
 }
 
+// split qualifiers off ty, the qualifiers of nested QualifiedType are merged.
+// qualifiers of derived types, e.g. the pointee, are kept
+func Unqualify(ty SymbolType) (SymbolType, Qualifier) {
+	var quals Qualifier
+	for {
+		if qty, yes := ty.(*QualifiedType); yes {
+			quals |= qty.Qualifier
+			ty = qty.Base
+		} else {
+			return ty, quals
+		}
+	}
+}
+
+// element of __builtin_va_list, which is `struct __va_list_tag[1]` on x86-64
+type VaListTag struct {
+}
+
+func (v *VaListTag) String() string {
+	return "__va_list_tag"
+}
+
+func MakeVaListType() *Array {
+	var one = &IntLiteralExpr{Tok: lexer.MakeToken(lexer.INT_LITERAL, "1")}
+	return &Array{&VaListTag{}, 1, []Expression{one}}
+}
+
+// va_list decays to pointer to its tag when passed around
+func IsVaList(ty SymbolType) bool {
+	if pty, ok := ty.(*Pointer); ok {
+		_, ok = pty.Source.(*VaListTag)
+		return ok
+	}
+	return false
+}
+
 type VoidType struct {
 }
 
@@ -156,6 +265,63 @@ func (i *IntegerType) String() string {
 	return s + i.Kind
 }
 
+// data model of the target, widths are in bits
+type TargetInfo struct {
+	Triple       string
+	PointerWidth int
+	widths       map[string]int // of integer kinds
+}
+
+var (
+	lp64  = map[string]int{"char": 8, "short": 16, "int": 32, "long": 64, "long long": 64}
+	llp64 = map[string]int{"char": 8, "short": 16, "int": 32, "long": 32, "long long": 64}
+	ilp32 = map[string]int{"char": 8, "short": 16, "int": 32, "long": 32, "long long": 64}
+
+	// the target compiled for, x86-64 unless set by driver
+	Target = &TargetInfo{"x86_64-pc-linux-gnu", 64, lp64}
+)
+
+// describe the target of triple whose pointers are pointerWidth bits as in
+// its data layout, which is guessed from the triple if 0. targets of 32-bit
+// pointers are ILP32, 64-bit Windows is LLP64 and the others LP64
+func TargetFor(triple string, pointerWidth int) *TargetInfo {
+	var parts = strings.Split(triple, "-")
+	var arch, env = parts[0], parts[len(parts)-1]
+	if pointerWidth == 0 {
+		switch {
+		case arch == "i386", arch == "i486", arch == "i586", arch == "i686",
+			arch == "riscv32", arch == "mips", arch == "mipsel", arch == "powerpc", arch == "wasm32",
+			strings.HasPrefix(arch, "armv"), arch == "arm", arch == "armeb", strings.HasPrefix(arch, "thumb"),
+			strings.HasSuffix(env, "x32"), strings.HasSuffix(env, "ilp32"):
+			pointerWidth = 32
+		default:
+			pointerWidth = 64
+		}
+	}
+
+	switch {
+	case pointerWidth == 32:
+		return &TargetInfo{triple, 32, ilp32}
+	case strings.Contains(triple, "-windows"):
+		return &TargetInfo{triple, pointerWidth, llp64}
+	default:
+		return &TargetInfo{triple, pointerWidth, lp64}
+	}
+}
+
+// width of integer type of kind
+func (t *TargetInfo) Width(kind string) int {
+	return t.widths[kind]
+}
+
+// kind of the signed integer as wide as a pointer, i.e. ptrdiff_t
+func (t *TargetInfo) IntPtr() string {
+	if t.widths["long"] < t.PointerWidth {
+		return "long long"
+	}
+	return "long"
+}
+
 type FloatType struct {
 }
 
@@ -243,6 +409,23 @@ func (a *Array) String() string {
 	return s
 }
 
+// type of a[i]: for a multi-dimensional array it's the array of the
+// remaining dimensions
+func (a *Array) Elem() SymbolType {
+	if a.Level <= 1 {
+		return a.ElemType
+	}
+	return &Array{a.ElemType, a.Level - 1, a.LenExprs[1:]}
+}
+
+// length of the outermost dimension, -1 if unknown
+func (a *Array) Len() int {
+	if ile, ok := a.LenExprs[0].(*IntLiteralExpr); ok {
+		return ile.Tok.AsInt()
+	}
+	return -1
+}
+
 var anonymousRecordSeq int = 0
 var anonymousFieldSeq int = 0
 
@@ -308,7 +491,8 @@ func NextAnonyRecordName() string {
 var anonymousEnumSeq = 0
 
 type EnumeratorType struct {
-	Name string
+	Name  string
+	Value int64 // evaluated by sema
 }
 
 func (e *EnumeratorType) String() string {
@@ -388,11 +572,27 @@ type SymbolScope struct {
 	Owner Ast
 }
 
+// what a scope has declared so far, declarations after it are dropped by
+// Restore, e.g. those of a snippet failed in the repl
+type ScopeMark struct {
+	symbols, types, children int
+}
+
+func (scope *SymbolScope) Mark() ScopeMark {
+	return ScopeMark{len(scope.Symbols), len(scope.types), len(scope.Children)}
+}
+
+func (scope *SymbolScope) Restore(m ScopeMark) {
+	scope.Symbols = scope.Symbols[:m.symbols]
+	scope.types = scope.types[:m.types]
+	scope.Children = scope.Children[:m.children]
+}
+
 func (scope *SymbolScope) AddSymbol(sym *Symbol) {
 	for _, sym2 := range scope.Symbols {
 		if sym2.NS == sym.NS && sym2.Name.AsString() == sym.Name.AsString() {
-			panic(fmt.Sprintf("redeclaration of %s, previous is at %d:%d", sym.Name.AsString(),
-				sym2.Name.Line, sym2.Name.Column))
+			panic(MakeReport(Error, sym.Name, fmt.Sprintf("redeclaration of '%s'", sym.Name.AsString())).
+				AddNote(sym2.Name, "previous declaration is here"))
 		}
 	}
 	scope.Symbols = append(scope.Symbols, sym)
@@ -433,9 +633,9 @@ done:
 // helper
 func (scope *SymbolScope) LookupRecordVar(name string) (ret *Symbol) {
 	var isARecordWithName = func(sym *Symbol) bool {
-		var ty = sym.Type
+		var ty, _ = Unqualify(sym.Type)
 		if _, ok := ty.(*Pointer); ok {
-			ty = ty.(*Pointer).Source
+			ty, _ = Unqualify(ty.(*Pointer).Source)
 		}
 		if _, ok := ty.(*RecordType); ok && sym.Name.AsString() == name {
 			return true
@@ -446,6 +646,22 @@ func (scope *SymbolScope) LookupRecordVar(name string) (ret *Symbol) {
 	return scope.LookupSymbolBy(isARecordWithName)
 }
 
+// field name of record rdty and its index, or -1 if not found. a record
+// referred before its definition is completed by the tag visible in scope
+func (scope *SymbolScope) LookupField(rdty *RecordType, name string) (int, *FieldType) {
+	if len(rdty.Fields) == 0 {
+		if ty, ok := scope.LookupNamedTypeRecursive(rdty.Name, TagNS).(*RecordType); ok {
+			rdty = ty
+		}
+	}
+	for i, fld := range rdty.Fields {
+		if fld.Name == name {
+			return i, fld
+		}
+	}
+	return -1, nil
+}
+
 //FIXME: record and typedef are two distinct name spaces
 func (scope *SymbolScope) RegisterNamedType(st SymbolType) {
 	var (
@@ -572,7 +788,7 @@ func init() {
 
 	TypeSpecifier = make(map[string]bool)
 	var ts = [...]string{"void", "char", "short", "int", "long", "float",
-		"double", "signed", "unsigned", "struct", "union", "enum"}
+		"double", "signed", "unsigned", "struct", "union", "enum", "__builtin_va_list"}
 	for _, v := range ts {
 		TypeSpecifier[v] = true
 	}
@@ -0,0 +1,59 @@
+package ast
+
+import (
+	"testing"
+)
+
+func TestTargetFor(t *testing.T) {
+	var cases = []struct {
+		triple        string
+		pointerWidth  int // of data layout, 0 to guess from triple
+		expectPointer int
+		long          int
+		intptr        string
+	}{
+		{"x86_64-pc-linux-gnu", 0, 64, 64, "long"},
+		{"x86_64-pc-linux-gnu", 64, 64, 64, "long"},
+		{"x86_64-apple-darwin", 0, 64, 64, "long"},
+		{"aarch64-unknown-linux-gnu", 0, 64, 64, "long"},
+		{"riscv64-unknown-linux-gnu", 0, 64, 64, "long"},
+		{"i686-pc-linux-gnu", 0, 32, 32, "long"},
+		{"i686-pc-linux-gnu", 32, 32, 32, "long"},
+		{"armv7-unknown-linux-gnueabihf", 0, 32, 32, "long"},
+		{"riscv32-unknown-elf", 0, 32, 32, "long"},
+
+		// LLP64
+		{"x86_64-pc-windows-msvc", 0, 64, 32, "long long"},
+		{"x86_64-pc-windows-msvc", 64, 64, 32, "long long"},
+		{"x86_64-w64-windows-gnu", 0, 64, 32, "long long"},
+		{"aarch64-pc-windows-msvc", 0, 64, 32, "long long"},
+		{"i686-pc-windows-msvc", 0, 32, 32, "long"},
+
+		// x32 and ilp32 ABIs of 64-bit architectures
+		{"x86_64-linux-gnux32", 0, 32, 32, "long"},
+		{"x86_64-linux-gnux32", 32, 32, 32, "long"},
+		{"aarch64-linux-gnu_ilp32", 0, 32, 32, "long"},
+
+		// the data layout wins over the guess
+		{"x86_64-unknown-linux-gnu", 32, 32, 32, "long"},
+	}
+
+	for _, c := range cases {
+		var target = TargetFor(c.triple, c.pointerWidth)
+		if target.Triple != c.triple {
+			t.Errorf("%s: wrong triple %s", c.triple, target.Triple)
+		}
+		if target.PointerWidth != c.expectPointer {
+			t.Errorf("%s: pointer should be %d bits, got %d", c.triple, c.expectPointer, target.PointerWidth)
+		}
+		if w := target.Width("long"); w != c.long {
+			t.Errorf("%s: long should be %d bits, got %d", c.triple, c.long, w)
+		}
+		if target.Width("int") != 32 || target.Width("long long") != 64 {
+			t.Errorf("%s: int and long long should be 32 and 64 bits", c.triple)
+		}
+		if k := target.IntPtr(); k != c.intptr {
+			t.Errorf("%s: ptrdiff_t should be %s, got %s", c.triple, c.intptr, k)
+		}
+	}
+}
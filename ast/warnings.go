@@ -0,0 +1,271 @@
+package ast
+
+// every diagnostic belongs to the group named by its id without the stage,
+// e.g. unused-variable of sema.unused-variable. warnings of a group are
+// enabled or disabled by -W<group>, -Wno-<group>, -Wall and -Wextra, and
+// by #pragma sc diagnostic in the source, while errors always are reported
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yanhao/sc/lexer"
+)
+
+type GroupLevel int
+
+const (
+	GroupDefault GroupLevel = iota // warnings enabled unless -Wno-<group>
+	GroupAll                       // warnings enabled by -Wall
+	GroupExtra                     // warnings enabled by -Wextra
+	GroupOff                       // warnings enabled only by -W<group>
+	GroupError                     // errors, which can not be disabled
+)
+
+var groups = map[string]GroupLevel{
+	// lexer and parser
+	"invalid-token":                 GroupError,
+	"expected-token":                GroupError,
+	"expected-identifier":           GroupError,
+	"expected-expression":           GroupError,
+	"expected-operator":             GroupError,
+	"expected-while":                GroupError,
+	"syntax-error":                  GroupError,
+	"invalid-type-specifier":        GroupError,
+	"unknown-type-specifier":        GroupError,
+	"invalid-declaration-specifier": GroupError,
+	"multiple-storage-classes":      GroupError,
+	"misplaced-ellipsis":            GroupError,
+	"typedef-in-parameter":          GroupError,
+	"typedef-in-record":             GroupError,
+	"invalid-parameter":             GroupError,
+	"invalid-initializer":           GroupError,
+	"invalid-enumerator":            GroupError,
+	"invalid-field-declarator":      GroupError,
+	"invalid-keyword-in-expression": GroupError,
+	"invalid-type-name":             GroupError,
+	"redeclaration":                 GroupError,
+	"conflicting-types":             GroupError,
+	"non-static-after-static":       GroupError,
+	"static-after-non-static":       GroupError,
+	"unknown-pragmas":               GroupAll,
+	"unknown-warning-option":        GroupDefault,
+
+	// sema and linkage
+	"implicit-function-declaration": GroupError,
+	"undeclared-identifier":         GroupError,
+	"no-member":                     GroupError,
+	"invalid-member-reference":      GroupError,
+	"incomplete-field":              GroupError,
+	"void-parameter":                GroupError,
+	"initializer-string-too-long":   GroupError,
+	"non-constant-initializer":      GroupError,
+	"redefinition":                  GroupError,
+	"duplicate-definition":          GroupError,
+	"switch-condition-type":         GroupError,
+	"case-not-in-switch":            GroupError,
+	"default-not-in-switch":         GroupError,
+	"multiple-default":              GroupError,
+	"duplicate-case":                GroupError,
+	"not-integer-constant":          GroupError,
+	"integer-literal-too-large":     GroupError,
+	"implicitly-unsigned-literal":   GroupDefault,
+	"unused-variable":               GroupAll,
+	"unused-parameter":              GroupExtra,
+	"shadow":                        GroupOff,
+
+	// codegen
+	"return-type":        GroupDefault,
+	"unsupported-va-arg": GroupError,
+}
+
+// the group of diagnostic id
+func GroupOf(id string) string {
+	return id[strings.LastIndexByte(id, '.')+1:]
+}
+
+// warning options of the command line
+var (
+	warnAll, warnExtra, warningsAsErrors bool
+
+	enabledGroups = make(map[string]bool) // by -W<group> and -Wno-<group>
+	errorGroups   = make(map[string]bool) // by -Werror=<group>
+)
+
+// apply -W<opt>, e.g. -Wall, -Wno-shadow, -Werror=shadow and -Wno-error=shadow
+func SetWarningOption(opt string) error {
+	var group = opt
+	switch {
+	case opt == "no-error":
+		warningsAsErrors = false
+		return nil
+	case opt == "all":
+		warnAll = true
+		return nil
+	case opt == "extra":
+		warnExtra = true
+		return nil
+	case opt == "error":
+		warningsAsErrors = true
+		return nil
+	case strings.HasPrefix(opt, "error="), strings.HasPrefix(opt, "no-error="):
+		group = opt[strings.IndexByte(opt, '=')+1:]
+	case strings.HasPrefix(opt, "no-"):
+		group = opt[len("no-"):]
+	}
+
+	var level, ok = groups[group]
+	if !ok {
+		return fmt.Errorf("unknown warning option '-W%s'", opt)
+	}
+	if level == GroupError {
+		return fmt.Errorf("diagnostics of '%s' are errors, -W%s takes no effect", group, opt)
+	}
+	if strings.HasPrefix(opt, "error=") {
+		errorGroups[group] = true
+	} else if strings.HasPrefix(opt, "no-error=") {
+		delete(errorGroups, group)
+	} else {
+		enabledGroups[group] = !strings.HasPrefix(opt, "no-")
+	}
+	return nil
+}
+
+func warningEnabled(group string) bool {
+	if errorGroups[group] {
+		return true
+	}
+	if on, ok := enabledGroups[group]; ok {
+		return on
+	}
+	switch groups[group] {
+	case GroupDefault:
+		return true
+	case GroupAll:
+		return warnAll
+	case GroupExtra:
+		return warnExtra
+	}
+	return false
+}
+
+// #pragma sc diagnostic of a file, in the order of the source
+type diagnosticPragma struct {
+	lexer.Location
+	action string // push, pop or ignored
+	group  string
+}
+
+var pragmas = make(map[string][]diagnosticPragma)
+
+// take #pragma sc diagnostic of file from all pragmas, where malformed ones
+// are reported, and other pragmas are ignored
+func SetDiagnosticPragmas(file string, prs []lexer.Pragma) []*Report {
+	var reports []*Report
+	var warn = func(id string, p lexer.Pragma, desc string) {
+		var tok = lexer.MakeToken(lexer.ERROR, "#")
+		tok.Location = p.Location
+		var r = MakeReport(Warning, tok, desc)
+		r.ID, r.File = id, file
+		reports = append(reports, r)
+	}
+
+	var list []diagnosticPragma
+	for _, p := range prs {
+		var fields = strings.Fields(p.Text)
+		if len(fields) == 0 || fields[0] != "sc" {
+			continue
+		}
+
+		var dp = diagnosticPragma{Location: p.Location}
+		switch {
+		case len(fields) == 3 && fields[1] == "diagnostic" && (fields[2] == "push" || fields[2] == "pop"):
+			dp.action = fields[2]
+		case len(fields) == 4 && fields[1] == "diagnostic" && fields[2] == "ignored":
+			var group, err = strconv.Unquote(fields[3])
+			if err != nil {
+				warn("parser.unknown-pragmas", p, "pragma sc diagnostic expects a quoted group name")
+				continue
+			}
+			dp.action, dp.group = "ignored", strings.TrimPrefix(group, "-W")
+			if _, ok := groups[dp.group]; !ok {
+				warn("parser.unknown-warning-option", p, fmt.Sprintf("unknown warning group '%s', ignored", group))
+				continue
+			}
+		default:
+			warn("parser.unknown-pragmas", p, "pragma sc diagnostic expects push, pop or ignored \"<group>\"")
+			continue
+		}
+		list = append(list, dp)
+	}
+	pragmas[file] = list
+	return reports
+}
+
+// whether group is ignored by pragmas of file before loc
+func ignoredAt(file, group string, loc lexer.Location) bool {
+	var ignored = make(map[string]bool)
+	var stack []map[string]bool
+	for _, p := range pragmas[file] {
+		if p.Line > loc.Line || (p.Line == loc.Line && p.Column > loc.Column) {
+			break
+		}
+		switch p.action {
+		case "push":
+			var saved = make(map[string]bool)
+			for g := range ignored {
+				saved[g] = true
+			}
+			stack = append(stack, saved)
+		case "pop":
+			if n := len(stack); n > 0 {
+				ignored, stack = stack[n-1], stack[:n-1]
+			}
+		case "ignored":
+			ignored[p.group] = true
+		}
+	}
+	return ignored[group]
+}
+
+// drop warnings disabled by options or pragmas, and promote those of
+// -Werror to errors
+func FilterReports(reports []*Report) []*Report {
+	var ret []*Report
+	for _, r := range reports {
+		if r.Kind == Warning {
+			var group = GroupOf(r.ID)
+			if !warningEnabled(group) || ignoredAt(r.File, group, r.Location) {
+				continue
+			}
+			if warningsAsErrors || errorGroups[group] {
+				r.Kind = Error
+			}
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// the option of a report rendered after it, empty for errors which are
+// not promoted from warnings
+func optionOf(r *Report) string {
+	var group = GroupOf(r.ID)
+	if level, ok := groups[group]; !ok || level == GroupError {
+		return ""
+	}
+	if r.Kind == Error {
+		return "-Werror,-W" + group
+	}
+	return "-W" + group
+}
+
+func HasErrors(reports []*Report) bool {
+	for _, r := range reports {
+		if r.Kind == Error {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package codegen
+
+// enumeration types are missing from the DIBuilder binding of go-llvm, they
+// are created here by a builder of our own on the same module
+
+/*
+#include <stdlib.h>
+#include <llvm-c/DebugInfo.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	llvm "tinygo.org/x/go-llvm"
+)
+
+// languages of the C API are numbered in their own way
+const diLangC99 = llvm.DwarfLang(C.LLVMDWARFSourceLanguageC99)
+
+// metadata refs of go-llvm and ours are the same pointer of different types
+func toMetadataRef(md llvm.Metadata) C.LLVMMetadataRef {
+	return C.LLVMMetadataRef(unsafe.Pointer(md.C))
+}
+
+func fromMetadataRef(ref C.LLVMMetadataRef) (md llvm.Metadata) {
+	*(*C.LLVMMetadataRef)(unsafe.Pointer(&md.C)) = ref
+	return
+}
+
+type diEnumerator struct {
+	Name     string
+	Value    int64
+	Unsigned bool
+}
+
+type diEnumerationType struct {
+	Name        string
+	File        llvm.Metadata
+	Line        int
+	SizeInBits  uint64
+	AlignInBits uint32
+	Elements    []diEnumerator
+	ClassType   llvm.Metadata // the underlying integer type
+}
+
+func createEnumerationType(mod llvm.Module, scope llvm.Metadata, t diEnumerationType) llvm.Metadata {
+	var builder = C.LLVMCreateDIBuilder(C.LLVMModuleRef(unsafe.Pointer(mod.C)))
+	defer C.LLVMDisposeDIBuilder(builder)
+
+	var elems = make([]C.LLVMMetadataRef, len(t.Elements))
+	for i, e := range t.Elements {
+		var name = C.CString(e.Name)
+		var unsigned C.LLVMBool
+		if e.Unsigned {
+			unsigned = 1
+		}
+		elems[i] = C.LLVMDIBuilderCreateEnumerator(builder, name, C.size_t(len(e.Name)), C.int64_t(e.Value), unsigned)
+		C.free(unsafe.Pointer(name))
+	}
+
+	var elemsPtr *C.LLVMMetadataRef
+	if len(elems) > 0 {
+		elemsPtr = &elems[0]
+	}
+
+	var name = C.CString(t.Name)
+	defer C.free(unsafe.Pointer(name))
+	var ref = C.LLVMDIBuilderCreateEnumerationType(builder, toMetadataRef(scope), name, C.size_t(len(t.Name)),
+		toMetadataRef(t.File), C.unsigned(t.Line), C.uint64_t(t.SizeInBits), C.uint32_t(t.AlignInBits),
+		elemsPtr, C.unsigned(len(elems)), toMetadataRef(t.ClassType))
+	return fromMetadataRef(ref)
+}
@@ -1,8 +1,11 @@
 package codegen
 
 import (
+	"debug/dwarf"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/yanhao/sc/ast"
 	"github.com/yanhao/sc/lexer"
@@ -11,22 +14,118 @@ import (
 	llvm "tinygo.org/x/go-llvm"
 )
 
+var (
+	DataLayout string        // of the target machine, set by driver before generation
+	DebugInfo  bool          // generate DWARF, set by driver
+	Reports    []*ast.Report // warnings found during generation
+	addReport  = func(kd ast.ReportKind, id string, tk lexer.Token, desc string) {
+		var r = ast.MakeReport(kd, tk, desc)
+		r.ID = id
+		Reports = append(Reports, r)
+	}
+)
+
+// va_list is lowered by hand only for x86-64
+func isX86_64() bool {
+	return strings.HasPrefix(ast.Target.Triple, "x86_64")
+}
+
+func DumpReports() {
+	ast.DumpReports(Reports)
+}
+
+// run the IR pass pipeline of optimization level on mod, sizeLevel > 0 for -Os
+func Optimize(mod llvm.Module, level, sizeLevel int) {
+	if level == 0 {
+		return
+	}
+
+	var pm = llvm.NewPassManager()
+	defer pm.Dispose()
+
+	if level >= 2 {
+		// interprocedural passes first, so callees are simplified inline
+		pm.AddIPSCCPPass()
+		pm.AddGlobalOptimizerPass()
+		pm.AddDeadArgEliminationPass()
+		pm.AddFunctionInliningPass()
+		pm.AddFunctionAttrsPass()
+	}
+	if level >= 3 {
+		pm.AddArgumentPromotionPass()
+	}
+
+	pm.AddPromoteMemoryToRegisterPass()
+	pm.AddInstructionCombiningPass()
+	pm.AddCFGSimplificationPass()
+	pm.AddReassociatePass()
+	pm.AddGVNPass()
+
+	if level >= 2 {
+		pm.AddSCCPPass()
+		pm.AddJumpThreadingPass()
+		pm.AddCFGSimplificationPass()
+
+		// loop passes
+		pm.AddLoopRotatePass()
+		pm.AddLICMPass()
+		if level >= 3 {
+			pm.AddLoopUnswitchPass()
+		}
+		pm.AddIndVarSimplifyPass()
+		pm.AddLoopDeletionPass()
+		if sizeLevel == 0 {
+			pm.AddLoopUnrollPass()
+		}
+
+		pm.AddInstructionCombiningPass()
+		pm.AddGVNPass()
+		pm.AddMemCpyOptPass()
+		pm.AddDeadStoreEliminationPass()
+		pm.AddTailCallEliminationPass()
+		pm.AddSCCPPass()
+	}
+
+	pm.AddInstructionCombiningPass()
+	pm.AddAggressiveDCEPass()
+	pm.AddCFGSimplificationPass()
+	if level >= 2 {
+		pm.AddGlobalDCEPass()
+		pm.AddConstantMergePass()
+	}
+	pm.Run(mod)
+}
+
+// code generation level of the target machine matching optimization level
+func CodeGenLevel(level int) llvm.CodeGenOptLevel {
+	switch level {
+	case 0:
+		return llvm.CodeGenLevelNone
+	case 1:
+		return llvm.CodeGenLevelLess
+	case 2:
+		return llvm.CodeGenLevelDefault
+	default:
+		return llvm.CodeGenLevelAggressive
+	}
+}
+
 func MakeLLVMCodeGen() ast.AstWalker {
 	type SwitchState struct {
-		switch_val  llvm.Value // if non-nil, inside switch statement
-		default_bb  llvm.BasicBlock
-		cases       []llvm.BasicBlock
-		count_cases bool // if true, count cases recursively
-		num_cases   int
-		has_default bool
-		Next        *SwitchState
+		inst   llvm.Value // the switch instruction, default is retargeted by DefaultStmt
+		end_bb llvm.BasicBlock
+	}
+
+	// target of break or continue, locals of scopes deeper than those it is
+	// in end their lifetime by jumping to it
+	type JumpTarget struct {
+		bb     llvm.BasicBlock
+		scopes int
 	}
 
 	const (
 		CNormal int = iota
 		CIsFuncCall
-		CSearchRecord
-		CSearchRecordMember
 	)
 
 	type Info struct {
@@ -35,12 +134,26 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		builder llvm.Builder
 		top     *ast.TranslationUnit
 		symbols []llvm.Value               // in order
-		breaks  []llvm.BasicBlock          // stack of targets for `break` statement
+		names   []string                   // C names of symbols, static locals are renamed in llvm
+		breaks  []JumpTarget               // stack of targets for `break` statement
+		conts   []JumpTarget               // stack of targets for `continue` statement
 		labels  map[string]llvm.BasicBlock // list of targets for `goto` statement
-		sw      *SwitchState               // the innermost of switch states
+		sw      []SwitchState              // stack of enclosing switch statements
+		ret_bb  llvm.BasicBlock            // the single exit of current function
+		retval  llvm.Value                 // slot of return value, nil if function returns void
+		scopes  [][]llvm.Value             // locals whose lifetime ends with each compound statement
+		goto_   bool                       // if current function has labels to jump to
 		types   map[string]llvm.Type       // named types (records now)
 		state   int
-		rdName  string
+
+		// debug info, di is nil unless DebugInfo is set
+		di       *llvm.DIBuilder
+		td       llvm.TargetData
+		diCU     llvm.Metadata
+		diFile   llvm.Metadata
+		diScopes []llvm.Metadata          // subprogram and lexical blocks of current function
+		diTypes  map[string]llvm.Metadata // records and enums
+		diVars   map[string]bool          // globals described
 	}
 
 	var walker struct {
@@ -48,6 +161,7 @@ func MakeLLVMCodeGen() ast.AstWalker {
 
 		WalkTranslationUnit      func(ast.WalkStage, *ast.TranslationUnit, *ast.WalkContext)
 		WalkIntLiteralExpr       func(ws ast.WalkStage, e *ast.IntLiteralExpr, ctx *ast.WalkContext) bool
+		WalkFloatLiteralExpr     func(ws ast.WalkStage, e *ast.FloatLiteralExpr, ctx *ast.WalkContext) bool
 		WalkCharLiteralExpr      func(ws ast.WalkStage, e *ast.CharLiteralExpr, ctx *ast.WalkContext) bool
 		WalkStringLiteralExpr    func(ws ast.WalkStage, e *ast.StringLiteralExpr, ctx *ast.WalkContext) bool
 		WalkBinaryOperation      func(ws ast.WalkStage, e *ast.BinaryOperation, ctx *ast.WalkContext) bool
@@ -58,14 +172,16 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		WalkArraySubscriptExpr   func(ws ast.WalkStage, e *ast.ArraySubscriptExpr, ctx *ast.WalkContext) bool
 		WalkMemberExpr           func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool
 		WalkFunctionCall         func(ws ast.WalkStage, e *ast.FunctionCall, ctx *ast.WalkContext) bool
+		WalkBuiltinCallExpr      func(ws ast.WalkStage, e *ast.BuiltinCallExpr, ctx *ast.WalkContext) bool
 		WalkCompoundAssignExpr   func(ws ast.WalkStage, e *ast.CompoundAssignExpr, ctx *ast.WalkContext) bool
 		WalkCastExpr             func(ws ast.WalkStage, e *ast.CastExpr, ctx *ast.WalkContext) bool
+		WalkImplicitCastExpr     func(ws ast.WalkStage, e *ast.ImplicitCastExpr, ctx *ast.WalkContext) bool
 		WalkCompoundLiteralExpr  func(ws ast.WalkStage, e *ast.CompoundLiteralExpr, ctx *ast.WalkContext) bool
 		WalkInitListExpr         func(ws ast.WalkStage, e *ast.InitListExpr, ctx *ast.WalkContext) bool
 		WalkFieldDecl            func(ws ast.WalkStage, e *ast.FieldDecl, ctx *ast.WalkContext)
 		WalkRecordDecl           func(ws ast.WalkStage, e *ast.RecordDecl, ctx *ast.WalkContext) bool
 		WalkEnumeratorDecl       func(ws ast.WalkStage, e *ast.EnumeratorDecl, ctx *ast.WalkContext)
-		WalkEnumDecl             func(ws ast.WalkStage, e *ast.EnumDecl, ctx *ast.WalkContext)
+		WalkEnumDecl             func(ws ast.WalkStage, e *ast.EnumDecl, ctx *ast.WalkContext) bool
 		WalkVariableDecl         func(ws ast.WalkStage, e *ast.VariableDecl, ctx *ast.WalkContext) bool
 		WalkTypedefDecl          func(ws ast.WalkStage, e *ast.TypedefDecl, ctx *ast.WalkContext)
 		WalkParamDecl            func(ws ast.WalkStage, e *ast.ParamDecl, ctx *ast.WalkContext)
@@ -88,6 +204,10 @@ func MakeLLVMCodeGen() ast.AstWalker {
 	}
 
 	var addIntrinsic = func(name string) llvm.Value {
+		if fn := walker.Info.Mod.NamedFunction(name); !fn.IsNil() {
+			return fn
+		}
+
 		switch name {
 		case "llvm.memcpy.p0i8.p0i8.i64":
 			var ll_rty = llvm.VoidType()
@@ -95,8 +215,41 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				llvm.PointerType(llvm.Int8Type(), 0),
 				llvm.PointerType(llvm.Int8Type(), 0),
 				llvm.Int64Type(),
-				llvm.Int32Type(),
-				llvm.Int1Type(),
+				llvm.Int1Type(), // isvolatile, alignment goes to param attributes
+			}
+
+			var fty = llvm.FunctionType(ll_rty, ptys, false)
+			var fn = llvm.AddFunction(walker.Info.Mod, name, fty)
+			return fn
+
+		case "llvm.va_start", "llvm.va_end":
+			var ptys = []llvm.Type{llvm.PointerType(llvm.Int8Type(), 0)}
+			var fty = llvm.FunctionType(llvm.VoidType(), ptys, false)
+			return llvm.AddFunction(walker.Info.Mod, name, fty)
+
+		case "llvm.va_copy":
+			var ptys = []llvm.Type{
+				llvm.PointerType(llvm.Int8Type(), 0), // dest
+				llvm.PointerType(llvm.Int8Type(), 0),
+			}
+			var fty = llvm.FunctionType(llvm.VoidType(), ptys, false)
+			return llvm.AddFunction(walker.Info.Mod, name, fty)
+
+		case "llvm.lifetime.start.p0i8", "llvm.lifetime.end.p0i8":
+			var ptys = []llvm.Type{
+				llvm.Int64Type(), // size
+				llvm.PointerType(llvm.Int8Type(), 0),
+			}
+			var fty = llvm.FunctionType(llvm.VoidType(), ptys, false)
+			return llvm.AddFunction(walker.Info.Mod, name, fty)
+
+		case "llvm.memset.p0i8.i64":
+			var ll_rty = llvm.VoidType()
+			var ptys = []llvm.Type{
+				llvm.PointerType(llvm.Int8Type(), 0),
+				llvm.Int8Type(),
+				llvm.Int64Type(),
+				llvm.Int1Type(), // isvolatile
 			}
 
 			var fty = llvm.FunctionType(ll_rty, ptys, false)
@@ -112,23 +265,15 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		switch st.(type) {
 		case *ast.IntegerType:
 			ity := st.(*ast.IntegerType)
-			//FIXME: consider x86-64 only now
-			switch ity.Kind {
-			case "char":
-				ret = llvm.Int8Type()
-			case "short":
-				ret = llvm.Int16Type()
-			case "int":
-				ret = llvm.Int32Type()
-			case "long":
-				ret = llvm.Int64Type()
-			case "long long":
-				ret = llvm.Int64Type()
-			}
+			ret = llvm.IntType(ast.Target.Width(ity.Kind))
 
 		case *ast.VoidType:
 			ret = llvm.VoidType()
 
+		case *ast.EnumType:
+			// enumerations are compatible with int
+			ret = llvm.Int32Type()
+
 		case *ast.FloatType:
 			ret = llvm.FloatType()
 
@@ -137,20 +282,29 @@ func MakeLLVMCodeGen() ast.AstWalker {
 
 		case *ast.Pointer:
 			pty := st.(*ast.Pointer)
-			switch pty.Source.(type) {
+			var source, _ = ast.Unqualify(pty.Source)
+			switch source.(type) {
 			case *ast.RecordType:
-				var rdty = pty.Source.(*ast.RecordType)
+				var rdty = source.(*ast.RecordType)
 				ret = llvm.PointerType(walker.Info.types[rdty.Name], 0)
+			case *ast.VoidType:
+				// there is no void* in llvm
+				ret = llvm.PointerType(llvm.Int8Type(), 0)
 			default:
-				ret = llvm.PointerType(symbolTy2llvmType(pty.Source, ctx), 0)
+				ret = llvm.PointerType(symbolTy2llvmType(source, ctx), 0)
 			}
 
+		case *ast.QualifiedType:
+			// qualifiers go to instructions and globals instead
+			ret = symbolTy2llvmType(st.(*ast.QualifiedType).Base, ctx)
+
 		case *ast.Array:
 			aty := st.(*ast.Array)
 			ret = symbolTy2llvmType(aty.ElemType, ctx)
 
-			for _, e := range aty.LenExprs {
-				ile := e.(*ast.IntLiteralExpr) // might fail
+			// the innermost dimension comes last
+			for i := len(aty.LenExprs) - 1; i >= 0; i-- {
+				ile := aty.LenExprs[i].(*ast.IntLiteralExpr) // might fail
 				//FIXME: -1 should be take care during AST transformation
 				if ile.Tok.AsInt() == -1 {
 					ret = llvm.PointerType(ret, 0)
@@ -168,13 +322,34 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				ptys = append(ptys, symbolTy2llvmType(arg, ctx))
 			}
 
-			ret = llvm.FunctionType(ll_rty, ptys, false)
+			ret = llvm.FunctionType(ll_rty, ptys, fty.IsVariadic)
+
+		case *ast.VaListTag:
+			if ty, ok := walker.Info.types["__va_list_tag"]; ok {
+				ret = ty
+			} else {
+				var i8p = llvm.PointerType(llvm.Int8Type(), 0)
+				ret = ctx.StructCreateNamed("struct.__va_list_tag")
+				if isX86_64() {
+					// { gp_offset, fp_offset, overflow_arg_area, reg_save_area }
+					ret.StructSetBody([]llvm.Type{llvm.Int32Type(), llvm.Int32Type(), i8p, i8p}, false)
+				} else {
+					// opaque storage large enough for the va_list of the other
+					// targets, the largest one is of aarch64 with 32 bytes
+					ret.StructSetBody([]llvm.Type{llvm.ArrayType(llvm.Int64Type(), 4)}, false)
+				}
+				walker.Info.types["__va_list_tag"] = ret
+			}
 
 		case *ast.RecordType:
 			//FIXME: take care of union
 			var rdty = st.(*ast.RecordType)
 			var elemtys []llvm.Type
 
+			// a record is the same llvm type wherever it is referred
+			if ty, ok := walker.Info.types[rdty.Name]; ok {
+				return ty
+			}
 			ret = ctx.StructCreateNamed(rdty.Name)
 			walker.Info.types[rdty.Name] = ret
 
@@ -196,42 +371,388 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		return
 	}
 
-	var doConversion = func(val llvm.Value, rty llvm.Type) llvm.Value {
-		//FIXME: get rvalue, this is not always correct
-		if val.Type().TypeKind() == llvm.PointerTypeKind {
-			val = walker.Info.builder.CreateLoad(val, "")
+	// convert val from type `from` to type `to`, integers are extended by the
+	// signedness of source type
+	var doConversion func(val llvm.Value, from, to ast.SymbolType) llvm.Value
+	doConversion = func(val llvm.Value, from, to ast.SymbolType) llvm.Value {
+		var rty = symbolTy2llvmType(to, walker.Info.llvmCtx)
+		if rty == val.Type() {
+			return val
 		}
 
-		if rty != val.Type() {
-			if val.Type().TypeKind() == llvm.IntegerTypeKind && rty.TypeKind() == llvm.IntegerTypeKind {
-				var w1, w2 = val.Type().IntTypeWidth(), rty.IntTypeWidth()
-				switch {
-				case w1 < w2:
-					//FIXME: need signedness to determine if zext or sext performed
+		var from_fp, to_fp = ast.IsFloatingType(from), ast.IsFloatingType(to)
+		switch {
+		case from_fp && to_fp:
+			if rty.TypeKind() == llvm.DoubleTypeKind {
+				val = walker.Info.builder.CreateFPExt(val, rty, "")
+			} else {
+				val = walker.Info.builder.CreateFPTrunc(val, rty, "")
+			}
+
+		case from_fp:
+			if ast.IsUnsigned(to) {
+				val = walker.Info.builder.CreateFPToUI(val, rty, "")
+			} else {
+				val = walker.Info.builder.CreateFPToSI(val, rty, "")
+			}
+
+		case to_fp:
+			if ast.IsUnsigned(from) {
+				val = walker.Info.builder.CreateUIToFP(val, rty, "")
+			} else {
+				val = walker.Info.builder.CreateSIToFP(val, rty, "")
+			}
+
+		case val.Type().TypeKind() == llvm.PointerTypeKind && rty.TypeKind() == llvm.PointerTypeKind:
+			val = walker.Info.builder.CreateBitCast(val, rty, "")
+
+		case val.Type().TypeKind() == llvm.PointerTypeKind:
+			val = walker.Info.builder.CreatePtrToInt(val, rty, "")
+
+		case rty.TypeKind() == llvm.PointerTypeKind:
+			val = doConversion(val, from, &ast.IntegerType{true, ast.Target.IntPtr()})
+			val = walker.Info.builder.CreateIntToPtr(val, rty, "")
+
+		case val.Type().TypeKind() == llvm.IntegerTypeKind && rty.TypeKind() == llvm.IntegerTypeKind:
+			var w1, w2 = val.Type().IntTypeWidth(), rty.IntTypeWidth()
+			switch {
+			case w1 < w2:
+				if ast.IsUnsigned(from) {
 					val = walker.Info.builder.CreateZExt(val, rty, "")
-				case w1 > w2:
-					val = walker.Info.builder.CreateTrunc(val, rty, "")
+				} else {
+					val = walker.Info.builder.CreateSExt(val, rty, "")
 				}
+			case w1 > w2:
+				val = walker.Info.builder.CreateTrunc(val, rty, "")
 			}
-
 		}
 
 		return val
 	}
 
+	// lvalues are evaluated into their addresses
+	var isLValue = func(e ast.Expression) bool {
+		switch e.(type) {
+		case *ast.DeclRefExpr:
+			_, isFunc := e.GetType().(*ast.Function)
+			return !isFunc
+		case *ast.ArraySubscriptExpr, *ast.MemberExpr:
+		case *ast.UnaryOperation:
+			if e.(*ast.UnaryOperation).Op != lexer.MUL {
+				return false
+			}
+		default:
+			return false
+		}
+
+		// an array is never loaded as a whole, nor is a function
+		switch e.GetType().(type) {
+		case *ast.Array, *ast.Function:
+			return false
+		}
+		return true
+	}
+
+	// sema gives values unqualified types, qualifiers of lvalue e are found
+	// from the declaration of object or the type it is accessed through
+	var qualifiers func(e ast.Expression, ctx *ast.WalkContext) ast.Qualifier
+	qualifiers = func(e ast.Expression, ctx *ast.WalkContext) ast.Qualifier {
+		var ty ast.SymbolType
+		switch e := e.(type) {
+		case *ast.DeclRefExpr:
+			if sym := ctx.Scope.LookupSymbol(e.Name, ast.OrdinaryNS); sym != nil {
+				ty = sym.Type
+			}
+
+		case *ast.UnaryOperation:
+			if pty, yes := e.Expr.GetType().(*ast.Pointer); yes && e.Op == lexer.MUL {
+				ty = pty.Source
+			}
+
+		case *ast.ArraySubscriptExpr:
+			switch tty := e.Target.GetType().(type) {
+			case *ast.Array:
+				ty = tty.Elem()
+			case *ast.Pointer:
+				ty = tty.Source
+			}
+
+		case *ast.MemberExpr:
+			// members of a qualified record are qualified too, which is the
+			// target of . or what -> points to
+			var quals ast.Qualifier
+			var rty ast.SymbolType
+			if pty, yes := e.Target.GetType().(*ast.Pointer); yes && e.PointerDeref {
+				rty, quals = ast.Unqualify(pty.Source)
+			} else {
+				rty, quals = e.Target.GetType(), qualifiers(e.Target, ctx)
+			}
+
+			if rdty, yes := rty.(*ast.RecordType); yes {
+				if _, fld := ctx.Scope.LookupField(rdty, e.Member.(*ast.DeclRefExpr).Name); fld != nil {
+					var _, fquals = ast.Unqualify(fld.Base)
+					quals |= fquals
+				}
+			}
+			return quals
+		}
+
+		var _, quals = ast.Unqualify(ty)
+		return quals
+	}
+
+	var isVolatile = func(e ast.Expression, ctx *ast.WalkContext) bool {
+		return qualifiers(e, ctx)&ast.Volatile != 0
+	}
+
+	// get rvalue of e from its evaluated value v
+	var loadRValue = func(e ast.Expression, v llvm.Value, ctx *ast.WalkContext) llvm.Value {
+		// an enumerator is referred as constant already
+		if isLValue(e) && v.IsAConstantInt().IsNil() {
+			var load = walker.Info.builder.CreateLoad(v, "")
+			load.SetVolatile(isVolatile(e, ctx))
+			return load
+		}
+		return v
+	}
+
+	// pointer arithmetic, GEP scales idx by the size of pointee
+	var pointerAdd = func(ptr, idx llvm.Value, idxType ast.SymbolType, sub bool) llvm.Value {
+		idx = doConversion(idx, idxType, &ast.IntegerType{false, ast.Target.IntPtr()})
+		if sub {
+			idx = walker.Info.builder.CreateNeg(idx, "")
+		}
+		return walker.Info.builder.CreateInBoundsGEP(ptr, []llvm.Value{idx}, "")
+	}
+
+	var rvalue = func(e ast.Expression, ctx *ast.WalkContext) llvm.Value {
+		return loadRValue(e, ast.WalkAst(e, walker, ctx).(llvm.Value), ctx)
+	}
+
+	// constant of char array type ty for a string literal, which is
+	// truncated or padded with null characters to the length of array
+	var constString = func(ty llvm.Type, str string) llvm.Value {
+		var n = ty.ArrayLength()
+		if len(str) >= n {
+			return llvm.ConstString(str[:n], false)
+		}
+		return llvm.ConstString(str+strings.Repeat("\x00", n-len(str)), false)
+	}
+
+	// private constant global with initializer cv, e.g. a string literal
+	var addConstGlobal = func(cv llvm.Value, name string) llvm.Value {
+		var v = llvm.AddGlobal(walker.Info.Mod, cv.Type(), name)
+		v.SetInitializer(cv)
+		v.SetLinkage(llvm.PrivateLinkage)
+		v.SetGlobalConstant(true)
+		v.SetUnnamedAddr(true)
+		return v
+	}
+
+	var boolConst = func(b bool) llvm.Value {
+		if b {
+			return llvm.ConstInt(llvm.Int1Type(), 1, false)
+		}
+		return llvm.ConstInt(llvm.Int1Type(), 0, false)
+	}
+
+	// an object of const type, or an array of such, is never modified
+	var isConstObject func(ty ast.SymbolType) bool
+	isConstObject = func(ty ast.SymbolType) bool {
+		var uty, quals = ast.Unqualify(ty)
+		if aty, yes := uty.(*ast.Array); yes {
+			return isConstObject(aty.ElemType)
+		}
+		return quals&ast.Const != 0
+	}
+
+	var isAggregate = func(ty llvm.Type) bool {
+		var kind = ty.TypeKind()
+		return kind == llvm.ArrayTypeKind || kind == llvm.StructTypeKind
+	}
+
+	// store initializer init into the object at ptr. lists are fully braced
+	// by sema, and the caller zeroes the elements that have no initializer
+	var storeInit func(ptr llvm.Value, init ast.Expression, volatile bool, ctx *ast.WalkContext)
+	storeInit = func(ptr llvm.Value, init ast.Expression, volatile bool, ctx *ast.WalkContext) {
+		var ty = ptr.Type().ElementType()
+		var list, isList = init.(*ast.InitListExpr)
+		if isList && !isAggregate(ty) {
+			// braced scalar
+			init, isList = list.Inits[0], false
+		}
+
+		if isList {
+			var zero = llvm.ConstInt(llvm.Int32Type(), 0, false)
+			for i, sub := range list.Inits {
+				var idx = llvm.ConstInt(llvm.Int32Type(), uint64(i), false)
+				var elem = walker.Info.builder.CreateInBoundsGEP(ptr, []llvm.Value{zero, idx}, "")
+				storeInit(elem, sub, volatile, ctx)
+			}
+			return
+		}
+
+		var val = rvalue(init, ctx)
+		if val.IsConstant() && isAggregate(ty) {
+			// aggregate constants are copied from a private global
+			var src = addConstGlobal(val, "")
+			var i8p = llvm.PointerType(llvm.Int8Type(), 0)
+			var args = []llvm.Value{
+				walker.Info.builder.CreateBitCast(ptr, i8p, ""),
+				walker.Info.builder.CreateBitCast(src, i8p, ""),
+				llvm.SizeOf(ty),
+				boolConst(volatile),
+			}
+			walker.Info.builder.CreateCall(addIntrinsic("llvm.memcpy.p0i8.p0i8.i64"), args, "")
+			return
+		}
+		walker.Info.builder.CreateStore(val, ptr).SetVolatile(volatile)
+	}
+
+	// constant of type ty for the initializer of an object with static
+	// storage, which sema has checked to be constant. missing elements of
+	// aggregates are zero
+	var constInit func(ty llvm.Type, init ast.Expression, ctx *ast.WalkContext) llvm.Value
+	constInit = func(ty llvm.Type, init ast.Expression, ctx *ast.WalkContext) llvm.Value {
+		var list, isList = init.(*ast.InitListExpr)
+		if !isList {
+			return rvalue(init, ctx)
+		}
+		if !isAggregate(ty) {
+			// braced scalar
+			return rvalue(list.Inits[0], ctx)
+		}
+
+		var elemTys []llvm.Type
+		if ty.TypeKind() == llvm.ArrayTypeKind {
+			elemTys = make([]llvm.Type, ty.ArrayLength())
+			for i := range elemTys {
+				elemTys[i] = ty.ElementType()
+			}
+		} else {
+			elemTys = ty.StructElementTypes()
+		}
+
+		var vals = make([]llvm.Value, len(elemTys))
+		for i, elemTy := range elemTys {
+			if i < len(list.Inits) {
+				vals[i] = constInit(elemTy, list.Inits[i], ctx)
+			} else {
+				vals[i] = llvm.ConstNull(elemTy)
+			}
+		}
+
+		if ty.TypeKind() == llvm.ArrayTypeKind {
+			return llvm.ConstArray(ty.ElementType(), vals)
+		}
+		return llvm.ConstNamedStruct(ty, vals)
+	}
+
+	// va_arg for x86-64: the arg is fetched from the register save area while
+	// there are registers left (6 GPRs of 8 bytes, then 8 XMMs of 16 bytes),
+	// otherwise from the overflow area on stack, in 8 bytes slots. the other
+	// targets are left to the va_arg instruction of llvm
+	var vaArg = func(ap llvm.Value, ty ast.SymbolType, tk lexer.Token) llvm.Value {
+		var b = walker.Info.builder
+		var result = ty
+		ty, _ = ast.Unqualify(ty)
+		if _, yes := ty.(*ast.EnumType); yes {
+			ty = &ast.IntegerType{Kind: "int"}
+		}
+
+		var field, step, limit = 0, 8, 48
+		switch ty.(type) {
+		case *ast.IntegerType, *ast.Pointer:
+		case *ast.FloatType, *ast.DoubleType:
+			field, step, limit = 1, 16, 176
+		default:
+			addReport(ast.Error, "codegen.unsupported-va-arg", tk,
+				fmt.Sprintf("va_arg of type '%s' is not supported", result))
+			return llvm.Undef(symbolTy2llvmType(result, walker.Info.llvmCtx))
+		}
+
+		// float is passed as double
+		var passed = ty
+		if _, yes := ty.(*ast.FloatType); yes {
+			passed = &ast.DoubleType{}
+		}
+		var lty = symbolTy2llvmType(passed, walker.Info.llvmCtx)
+
+		if !isX86_64() {
+			var val = b.CreateVAArg(b.CreateBitCast(ap, llvm.PointerType(llvm.Int8Type(), 0), ""), lty, "")
+			return doConversion(val, passed, ty)
+		}
+
+		var idx = func(i int) llvm.Value {
+			return llvm.ConstInt(llvm.Int32Type(), uint64(i), false)
+		}
+
+		var fn = b.GetInsertBlock().Parent()
+		var reg_bb = llvm.AddBasicBlock(fn, "")
+		var mem_bb = llvm.AddBasicBlock(fn, "")
+		var end_bb = llvm.AddBasicBlock(fn, "")
+
+		var offset_p = b.CreateInBoundsGEP(ap, []llvm.Value{idx(0), idx(field)}, "")
+		var offset = b.CreateLoad(offset_p, "")
+		var fits = b.CreateICmp(llvm.IntULE, offset, idx(limit-step), "")
+		b.CreateCondBr(fits, reg_bb, mem_bb)
+
+		b.SetInsertPointAtEnd(reg_bb)
+		var save = b.CreateLoad(b.CreateInBoundsGEP(ap, []llvm.Value{idx(0), idx(3)}, ""), "")
+		var reg_addr = b.CreateInBoundsGEP(save, []llvm.Value{offset}, "")
+		b.CreateStore(b.CreateAdd(offset, idx(step), ""), offset_p)
+		b.CreateBr(end_bb)
+
+		b.SetInsertPointAtEnd(mem_bb)
+		var area_p = b.CreateInBoundsGEP(ap, []llvm.Value{idx(0), idx(2)}, "")
+		var area = b.CreateLoad(area_p, "")
+		b.CreateStore(b.CreateInBoundsGEP(area, []llvm.Value{idx(8)}, ""), area_p)
+		b.CreateBr(end_bb)
+
+		b.SetInsertPointAtEnd(end_bb)
+		var addr = b.CreatePHI(llvm.PointerType(llvm.Int8Type(), 0), "")
+		addr.AddIncoming([]llvm.Value{reg_addr, area}, []llvm.BasicBlock{reg_bb, mem_bb})
+
+		var val = b.CreateLoad(b.CreateBitCast(addr, llvm.PointerType(lty, 0), ""), "")
+		return doConversion(val, passed, ty)
+	}
+
+	var toBool = func(v llvm.Value) llvm.Value {
+		switch v.Type().TypeKind() {
+		case llvm.PointerTypeKind:
+			return walker.Info.builder.CreateIsNotNull(v, "")
+		case llvm.FloatTypeKind, llvm.DoubleTypeKind:
+			return walker.Info.builder.CreateFCmp(llvm.FloatUNE, v, llvm.ConstFloat(v.Type(), 0), "")
+		case llvm.IntegerTypeKind:
+			if v.Type().IntTypeWidth() == 1 {
+				return v
+			}
+		}
+		return walker.Info.builder.CreateICmp(llvm.IntNE, v, llvm.ConstInt(v.Type(), 0, false), "")
+	}
+
 	var log = func(f string, v ...interface{}) {
 		if len(os.Getenv("DEBUG")) != 0 {
 			fmt.Fprintf(os.Stderr, f, v...)
 		}
 	}
 
-	var Append = func(v llvm.Value) {
+	var AppendAs = func(nm string, v llvm.Value) {
 		if v.IsNil() {
 			log("Append nil\n")
 		} else {
-			log("Append %v\n", v.Name())
+			log("Append %v as %s\n", v.Name(), nm)
 		}
 		walker.Info.symbols = append(walker.Info.symbols, v)
+		walker.Info.names = append(walker.Info.names, nm)
+	}
+
+	var Append = func(v llvm.Value) {
+		var nm string
+		if !v.IsNil() {
+			nm = v.Name()
+		}
+		AppendAs(nm, v)
 	}
 
 	var Drop = func() {
@@ -244,13 +765,14 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		}
 		log("Drop  %d -> %d\n", len(st), n)
 		walker.Info.symbols = st[:n]
+		walker.Info.names = walker.Info.names[:n]
 	}
 
 	var Find = func(nm string) llvm.Value {
 		log("Find(%s)\n", nm)
 		var st = walker.Info.symbols
 		for n := len(st) - 1; n >= 0; n-- {
-			if !st[n].IsNil() && st[n].Name() == nm {
+			if !st[n].IsNil() && walker.Info.names[n] == nm {
 				return st[n]
 			}
 		}
@@ -288,7 +810,7 @@ func MakeLLVMCodeGen() ast.AstWalker {
 	}
 
 	var PushBreak = func(bb llvm.BasicBlock) {
-		walker.Info.breaks = append(walker.Info.breaks, bb)
+		walker.Info.breaks = append(walker.Info.breaks, JumpTarget{bb, len(walker.Info.scopes)})
 	}
 
 	var PopBreak = func() {
@@ -296,80 +818,478 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		walker.Info.breaks = brs[:len(brs)-1]
 	}
 
-	var GetBreak = func() llvm.BasicBlock {
+	var GetBreak = func() JumpTarget {
 		return walker.Info.breaks[len(walker.Info.breaks)-1]
 	}
 
-	var PushSwitchState = func() *SwitchState {
-		var ss = &SwitchState{}
+	var PushContinue = func(bb llvm.BasicBlock) {
+		walker.Info.conts = append(walker.Info.conts, JumpTarget{bb, len(walker.Info.scopes)})
+	}
 
-		ss.Next = walker.Info.sw
-		walker.Info.sw = ss
-		return ss
+	var PopContinue = func() {
+		var conts = walker.Info.conts
+		walker.Info.conts = conts[:len(conts)-1]
+	}
+
+	var GetContinue = func() JumpTarget {
+		return walker.Info.conts[len(walker.Info.conts)-1]
+	}
+
+	var PushSwitchState = func(ss SwitchState) {
+		walker.Info.sw = append(walker.Info.sw, ss)
 	}
 
 	var PopSwitchState = func() {
-		if walker.Info.sw != nil {
-			walker.Info.sw = walker.Info.sw.Next
+		walker.Info.sw = walker.Info.sw[:len(walker.Info.sw)-1]
+	}
+
+	var GetSwitchState = func() SwitchState {
+		return walker.Info.sw[len(walker.Info.sw)-1]
+	}
+
+	// all locals are allocated in the entry block, so a declaration in a loop
+	// does not grow the stack and mem2reg can promote it. the order of
+	// declarations is kept.
+	var entryAlloca = func(ty llvm.Type, name string) llvm.Value {
+		var entry = walker.Info.builder.GetInsertBlock().Parent().EntryBasicBlock()
+		var b = llvm.NewBuilder()
+		defer b.Dispose()
+
+		var inst = entry.FirstInstruction()
+		for !inst.IsNil() && !inst.IsAAllocaInst().IsNil() {
+			inst = llvm.NextInstruction(inst)
+		}
+		if inst.IsNil() {
+			b.SetInsertPointAtEnd(entry)
+		} else {
+			b.SetInsertPointBefore(inst)
 		}
+		return b.CreateAlloca(ty, name)
 	}
 
-	var InSwitchCaseCounting = func() bool {
-		if walker.Info.sw != nil && walker.Info.sw.count_cases {
-			return true
+	// size of object is unknown until a target is chosen, -1 covers the
+	// whole object
+	var markLifetime = func(intrinsic string, v llvm.Value) {
+		var args = []llvm.Value{
+			llvm.ConstInt(llvm.Int64Type(), ^uint64(0), true),
+			walker.Info.builder.CreateBitCast(v, llvm.PointerType(llvm.Int8Type(), 0), ""),
+		}
+		walker.Info.builder.CreateCall(addIntrinsic(intrinsic), args, "")
+	}
+
+	// lifetime of a local starts at its declaration and ends at the exit of
+	// compound statement. jumps to labels of switch or goto may bypass the
+	// declaration, then the lifetime is left unmarked.
+	var startLifetime = func(v llvm.Value) {
+		if walker.Info.goto_ || len(walker.Info.sw) > 0 || len(walker.Info.scopes) == 0 {
+			return
+		}
+		markLifetime("llvm.lifetime.start.p0i8", v)
+		var n = len(walker.Info.scopes) - 1
+		walker.Info.scopes[n] = append(walker.Info.scopes[n], v)
+	}
+
+	// end lifetime of locals in scopes deeper than depth, which are left by
+	// the fall-through, break, continue or return at the insertion point.
+	// goto is not an exit, no lifetime is marked in a function with labels
+	var endLifetime = func(depth int) {
+		for n := len(walker.Info.scopes) - 1; n >= depth; n-- {
+			var locals = walker.Info.scopes[n]
+			for i := len(locals) - 1; i >= 0; i-- {
+				markLifetime("llvm.lifetime.end.p0i8", locals[i])
+			}
+		}
+	}
+
+	// code after a jump goes to a new block of no predecessors, which is
+	// dead unless a label starts it
+	var startDeadBlock = func() {
+		var fn = walker.Info.builder.GetInsertBlock().Parent()
+		walker.Info.builder.SetInsertPointAtEnd(llvm.AddBasicBlock(fn, ""))
+	}
+	var isDeadBlock = func(bb llvm.BasicBlock) bool {
+		return bb != bb.Parent().EntryBasicBlock() && bb.AsValue().FirstUse().IsNil()
+	}
+
+	// whether bb is reached from the entry of its function, where a branch on
+	// a constant, such as of while (1), goes one way only
+	var isReachable = func(bb llvm.BasicBlock) bool {
+		var entry = bb.Parent().EntryBasicBlock()
+		var seen = map[llvm.BasicBlock]bool{entry: true}
+		for work := []llvm.BasicBlock{entry}; len(work) > 0; {
+			var cur = work[len(work)-1]
+			work = work[:len(work)-1]
+			if cur == bb {
+				return true
+			}
+			var term = cur.LastInstruction()
+			if term.IsNil() || term.IsATerminatorInst().IsNil() {
+				continue
+			}
+
+			// operands of a conditional br are the condition, the false and
+			// the true destinations
+			var from, to = 0, term.OperandsCount()
+			if !term.IsABranchInst().IsNil() && to == 3 && !term.Operand(0).IsAConstantInt().IsNil() {
+				from = 1
+				if term.Operand(0).ZExtValue() != 0 {
+					from = 2
+				}
+				to = from + 1
+			}
+			for i := from; i < to; i++ {
+				if op := term.Operand(i); op.IsBasicBlock() && !seen[op.AsBasicBlock()] {
+					seen[op.AsBasicBlock()] = true
+					work = append(work, op.AsBasicBlock())
+				}
+			}
 		}
 		return false
 	}
 
+	var hasLabels = func(body ast.Ast) bool {
+		var finder struct {
+			WalkLabelStmt func(ws ast.WalkStage, e *ast.LabelStmt, ctx *ast.WalkContext)
+		}
+		var found = false
+		finder.WalkLabelStmt = func(ws ast.WalkStage, e *ast.LabelStmt, ctx *ast.WalkContext) {
+			found = true
+		}
+		ast.WalkAst(body, finder)
+		return found
+	}
+
+	// DWARF descriptor of type ty
+	var diType func(ty ast.SymbolType) llvm.Metadata
+	diType = func(ty ast.SymbolType) llvm.Metadata {
+		var di = walker.Info.di
+		var bits = func(ty ast.SymbolType) (uint64, uint32) {
+			var llty = symbolTy2llvmType(ty, walker.Info.llvmCtx)
+			return walker.Info.td.TypeAllocSize(llty) * 8, uint32(walker.Info.td.ABITypeAlignment(llty) * 8)
+		}
+
+		switch ty := ty.(type) {
+		case *ast.IntegerType:
+			var encoding = llvm.DW_ATE_signed
+			switch {
+			case ty.Kind == "char" && ty.Unsigned:
+				encoding = llvm.DW_ATE_unsigned_char
+			case ty.Kind == "char":
+				encoding = llvm.DW_ATE_signed_char
+			case ty.Unsigned:
+				encoding = llvm.DW_ATE_unsigned
+			}
+			return di.CreateBasicType(llvm.DIBasicType{
+				Name:       ty.String(),
+				SizeInBits: uint64(ast.Target.Width(ty.Kind)),
+				Encoding:   encoding,
+			})
+
+		case *ast.FloatType:
+			return di.CreateBasicType(llvm.DIBasicType{Name: "float", SizeInBits: 32, Encoding: llvm.DW_ATE_float})
+
+		case *ast.DoubleType:
+			return di.CreateBasicType(llvm.DIBasicType{Name: "double", SizeInBits: 64, Encoding: llvm.DW_ATE_float})
+
+		case *ast.VoidType:
+			// void is described by absence of type
+			return llvm.Metadata{}
+
+		case *ast.QualifiedType:
+			return diType(ty.Base)
+
+		case *ast.FieldType:
+			return diType(ty.Base)
+
+		case *ast.Pointer:
+			return di.CreatePointerType(llvm.DIPointerType{
+				Pointee:    diType(ty.Source),
+				SizeInBits: uint64(ast.Target.PointerWidth),
+			})
+
+		case *ast.Array:
+			var subscripts []llvm.DISubrange
+			for _, le := range ty.LenExprs {
+				// -1 for unknown length
+				subscripts = append(subscripts, llvm.DISubrange{Count: int64(le.(*ast.IntLiteralExpr).Tok.AsInt())})
+			}
+			var size, align = bits(ty)
+			return di.CreateArrayType(llvm.DIArrayType{
+				SizeInBits:  size,
+				AlignInBits: align,
+				ElementType: diType(ty.ElemType),
+				Subscripts:  subscripts,
+			})
+
+		case *ast.Function:
+			// the return type comes first
+			var params = []llvm.Metadata{diType(ty.Return)}
+			for _, arg := range ty.Args {
+				params = append(params, diType(arg))
+			}
+			return di.CreateSubroutineType(llvm.DISubroutineType{File: walker.Info.diFile, Parameters: params})
+
+		case *ast.EnumType:
+			if md, ok := walker.Info.diTypes["enum "+ty.Name]; ok {
+				return md
+			}
+			var elems []diEnumerator
+			for _, et := range ty.List {
+				elems = append(elems, diEnumerator{Name: et.Name, Value: et.Value})
+			}
+			var name = ty.Name
+			if strings.HasPrefix(name, "!") {
+				name = ""
+			}
+			var md = createEnumerationType(walker.Info.Mod, walker.Info.diFile, diEnumerationType{
+				Name:        name,
+				File:        walker.Info.diFile,
+				SizeInBits:  32,
+				AlignInBits: 32,
+				Elements:    elems,
+				ClassType:   diType(&ast.IntegerType{false, "int"}),
+			})
+			walker.Info.diTypes["enum "+ty.Name] = md
+			return md
+
+		case *ast.RecordType:
+			if md, ok := walker.Info.diTypes[ty.Name]; ok {
+				return md
+			}
+			var name = ty.Name
+			if strings.HasPrefix(name, "!") {
+				name = ""
+			}
+
+			// members may refer to the record itself
+			var fwd = di.CreateReplaceableCompositeType(walker.Info.diFile, llvm.DIReplaceableCompositeType{
+				Tag:  dwarf.TagStructType,
+				Name: name,
+				File: walker.Info.diFile,
+			})
+			walker.Info.diTypes[ty.Name] = fwd
+
+			var llty = symbolTy2llvmType(ty, walker.Info.llvmCtx)
+			var members []llvm.Metadata
+			for i, fld := range ty.Fields {
+				var size, align = bits(fld.Base)
+				members = append(members, di.CreateMemberType(fwd, llvm.DIMemberType{
+					Name:         fld.Name,
+					File:         walker.Info.diFile,
+					SizeInBits:   size,
+					AlignInBits:  align,
+					OffsetInBits: walker.Info.td.ElementOffset(llty, i) * 8,
+					Type:         diType(fld.Base),
+				}))
+			}
+
+			var size, align = bits(ty)
+			var md = di.CreateStructType(walker.Info.diFile, llvm.DIStructType{
+				Name:        name,
+				File:        walker.Info.diFile,
+				SizeInBits:  size,
+				AlignInBits: align,
+				Elements:    members,
+			})
+			fwd.ReplaceAllUsesWith(md)
+			walker.Info.diTypes[ty.Name] = md
+			return md
+
+		case *ast.VaListTag:
+			var size, align = bits(ty)
+			return di.CreateStructType(walker.Info.diFile, llvm.DIStructType{
+				Name:        "__va_list_tag",
+				File:        walker.Info.diFile,
+				SizeInBits:  size,
+				AlignInBits: align,
+			})
+
+		default:
+			panic(fmt.Sprintf("no debug info for type '%s'", ty))
+		}
+	}
+
+	var diScope = func() llvm.Metadata {
+		return walker.Info.diScopes[len(walker.Info.diScopes)-1]
+	}
+
+	// attach location of tok to instructions generated after, columns of
+	// tokens are 0-based while DWARF ones are 1-based
+	var setLocation = func(tok lexer.Token) {
+		if walker.Info.di == nil || len(walker.Info.diScopes) == 0 || tok.Line == 0 {
+			return
+		}
+		walker.Info.builder.SetCurrentDebugLocation(uint(tok.Line), uint(tok.Column+1), diScope(), llvm.Metadata{})
+	}
+
+	// describe local v of C type ty, argNo is 1-based for params and 0 for others
+	var declareLocal = func(v llvm.Value, name lexer.Token, ty ast.SymbolType, argNo int) {
+		if walker.Info.di == nil {
+			return
+		}
+
+		var di = walker.Info.di
+		var info llvm.Metadata
+		if argNo > 0 {
+			info = di.CreateParameterVariable(diScope(), llvm.DIParameterVariable{
+				Name:           name.AsString(),
+				File:           walker.Info.diFile,
+				Line:           name.Line,
+				Type:           diType(ty),
+				AlwaysPreserve: true,
+				ArgNo:          argNo,
+			})
+		} else {
+			info = di.CreateAutoVariable(diScope(), llvm.DIAutoVariable{
+				Name:           name.AsString(),
+				File:           walker.Info.diFile,
+				Line:           name.Line,
+				Type:           diType(ty),
+				AlwaysPreserve: true,
+			})
+		}
+		var loc = llvm.DebugLoc{Line: uint(name.Line), Col: uint(name.Column + 1), Scope: diScope()}
+		di.InsertDeclareAtEnd(v, info, di.CreateExpression(nil), loc, walker.Info.builder.GetInsertBlock())
+	}
+
+	// describe global or static local val of symbol sym
+	var declareGlobal = func(val llvm.Value, sym *ast.Symbol) {
+		if walker.Info.di == nil || walker.Info.diVars[val.Name()] {
+			return
+		}
+		walker.Info.diVars[val.Name()] = true
+
+		var scope = walker.Info.diCU
+		if len(walker.Info.diScopes) > 0 {
+			scope = diScope()
+		}
+		var gve = walker.Info.di.CreateGlobalVariableExpression(scope, llvm.DIGlobalVariableExpression{
+			Name:        sym.Name.AsString(),
+			LinkageName: val.Name(),
+			File:        walker.Info.diFile,
+			Line:        sym.Name.Line,
+			Type:        diType(sym.Type),
+			LocalToUnit: sym.Storage == ast.Static,
+			Expr:        walker.Info.di.CreateExpression(nil),
+		})
+		val.AddMetadata(walker.Info.llvmCtx.MDKindID("dbg"), gve)
+	}
+
 	walker.WalkTranslationUnit = func(ws ast.WalkStage, tu *ast.TranslationUnit, ctx *ast.WalkContext) {
 		if ws == ast.WalkerPropagate {
 			util.Printf("generated code\n")
 			walker.Info.Mod = llvm.NewModule(tu.Filename)
+			if DataLayout != "" {
+				// alignments of instructions come from the layout
+				walker.Info.Mod.SetDataLayout(DataLayout)
+				walker.Info.Mod.SetTarget(ast.Target.Triple)
+			}
 			walker.Info.llvmCtx = walker.Info.Mod.Context()
 			walker.Info.top = tu
 			walker.Info.builder = llvm.NewBuilder()
 			walker.Info.types = make(map[string]llvm.Type)
 			walker.Info.state = CNormal
+			Reports = nil
+
+			walker.Info.di = nil
+			if DebugInfo {
+				var mod = walker.Info.Mod
+				var filename, _ = filepath.Abs(tu.Filename)
+				if tu.Filename == "" {
+					filename = "<stdin>"
+				}
+				var dir, file = filepath.Split(filename)
+
+				walker.Info.di = llvm.NewDIBuilder(mod)
+				walker.Info.td = llvm.NewTargetData(mod.DataLayout())
+				walker.Info.diTypes = make(map[string]llvm.Metadata)
+				walker.Info.diVars = make(map[string]bool)
+				walker.Info.diScopes = nil
+				walker.Info.diFile = walker.Info.di.CreateFile(file, dir)
+				walker.Info.diCU = walker.Info.di.CreateCompileUnit(llvm.DICompileUnit{
+					Language: diLangC99,
+					File:     file,
+					Dir:      dir,
+					Producer: "sc",
+				})
+
+				var addFlag = func(name string, val uint64) {
+					var i32 = walker.Info.llvmCtx.Int32Type()
+					mod.AddNamedMetadataOperand("llvm.module.flags", walker.Info.llvmCtx.MDNode([]llvm.Metadata{
+						llvm.ConstInt(i32, 2, false).ConstantAsMetadata(), // warn if modules disagree
+						walker.Info.llvmCtx.MDString(name),
+						llvm.ConstInt(i32, val, false).ConstantAsMetadata(),
+					}))
+				}
+				addFlag("Dwarf Version", 4)
+				addFlag("Debug Info Version", 3)
+			}
 
 		} else {
+			ast.SetReportsFile(Reports, tu.Filename)
+			Reports = ast.FilterReports(Reports)
+			if walker.Info.di != nil {
+				walker.Info.di.Finalize()
+				walker.Info.di.Destroy()
+				walker.Info.td.Dispose()
+				walker.Info.di = nil
+			}
 			ctx.Value = walker.Info.Mod
 		}
 	}
 
 	walker.WalkIntLiteralExpr = func(ws ast.WalkStage, e *ast.IntLiteralExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			ctx.Value = llvm.ConstInt(walker.Info.llvmCtx.Int32Type(), uint64(e.Tok.AsInt()), false)
+			// the type of a literal is chosen by its value and suffix
+			var ty = walker.Info.llvmCtx.Int32Type()
+			if e.InferedType != nil {
+				ty = symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx)
+			}
+			var v, _ = e.Tok.AsUint64()
+			ctx.Value = llvm.ConstInt(ty, v, false)
+		}
+		return true
+	}
+
+	walker.WalkFloatLiteralExpr = func(ws ast.WalkStage, e *ast.FloatLiteralExpr, ctx *ast.WalkContext) bool {
+		if ws == ast.WalkerPropagate {
+			var ty = symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx)
+			ctx.Value = llvm.ConstFloat(ty, e.Tok.AsFloat())
 		}
 		return true
 	}
 
 	walker.WalkCharLiteralExpr = func(ws ast.WalkStage, e *ast.CharLiteralExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
+			ctx.Value = llvm.ConstInt(walker.Info.llvmCtx.Int8Type(), uint64(e.Tok.AsChar()), false)
 		}
 		return true
 	}
 	walker.WalkStringLiteralExpr = func(ws ast.WalkStage, e *ast.StringLiteralExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
 			var str = e.Tok.AsString()
-			var cv = llvm.ConstString(str, true)
-			var v = llvm.AddGlobal(walker.Info.Mod, cv.Type(), ".str")
-			v.SetInitializer(cv)
+			if _, yes := e.InferedType.(*ast.Array); yes {
+				// a string literal initializing a char array is the array constant
+				ctx.Value = constString(symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx), str)
+				return false
+			}
+			var v = addConstGlobal(llvm.ConstString(str, true), ".str")
 			log("StringLiteralExpr %s\n", v.Type())
 			ctx.Value = v
-			Append(v)
 		}
 		return true
 	}
 
 	walker.WalkBinaryOperation = func(ws ast.WalkStage, e *ast.BinaryOperation, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return false
-		}
 		if ws == ast.WalkerPropagate {
 			var lhs, rhs, op llvm.Value
 
 			//log("WalkBinaryOperation: lhs %v, rhs %v\n", lhs.Type(), rhs.Type())
 
+			// operands have been converted to the same type by sema
+			var unsigned = ast.IsUnsigned(e.LHS.GetType())
+			var floating = ast.IsFloatingType(e.LHS.GetType())
+
 			var ariths = map[lexer.Kind]func(lhs, rhs llvm.Value, name string) llvm.Value{
 				lexer.PLUS:  walker.Info.builder.CreateAdd,
 				lexer.MINUS: walker.Info.builder.CreateSub,
@@ -384,52 +1304,89 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				lexer.LSHIFT: walker.Info.builder.CreateShl,
 				lexer.RSHIFT: walker.Info.builder.CreateAShr,
 			}
+			if unsigned {
+				ariths[lexer.DIV] = walker.Info.builder.CreateUDiv
+				ariths[lexer.MOD] = walker.Info.builder.CreateURem
+				bitops[lexer.RSHIFT] = walker.Info.builder.CreateLShr
+			}
+			if floating {
+				ariths = map[lexer.Kind]func(lhs, rhs llvm.Value, name string) llvm.Value{
+					lexer.PLUS:  walker.Info.builder.CreateFAdd,
+					lexer.MINUS: walker.Info.builder.CreateFSub,
+					lexer.MUL:   walker.Info.builder.CreateFMul,
+					lexer.DIV:   walker.Info.builder.CreateFDiv,
+					lexer.MOD:   walker.Info.builder.CreateFRem,
+				}
+			}
 
 			var cmps = map[lexer.Kind]struct {
-				pred   llvm.IntPredicate
-				signed bool
+				signed   llvm.IntPredicate
+				unsigned llvm.IntPredicate
 			}{
-				lexer.GREAT: {llvm.IntSGT, true},
-				lexer.GE:    {llvm.IntSGE, true},
-				lexer.LESS:  {llvm.IntSLT, true},
-				lexer.LE:    {llvm.IntSLE, true},
-				lexer.NE:    {llvm.IntNE, true},
-				lexer.EQUAL: {llvm.IntEQ, true},
+				lexer.GREAT: {llvm.IntSGT, llvm.IntUGT},
+				lexer.GE:    {llvm.IntSGE, llvm.IntUGE},
+				lexer.LESS:  {llvm.IntSLT, llvm.IntULT},
+				lexer.LE:    {llvm.IntSLE, llvm.IntULE},
+				lexer.NE:    {llvm.IntNE, llvm.IntNE},
+				lexer.EQUAL: {llvm.IntEQ, llvm.IntEQ},
 			}
+			// ordered except for `!=`, which is true if any operand is NaN
+			var fcmps = map[lexer.Kind]llvm.FloatPredicate{
+				lexer.GREAT: llvm.FloatOGT,
+				lexer.GE:    llvm.FloatOGE,
+				lexer.LESS:  llvm.FloatOLT,
+				lexer.LE:    llvm.FloatOLE,
+				lexer.NE:    llvm.FloatUNE,
+				lexer.EQUAL: llvm.FloatOEQ,
+			}
+
+			var _, lptr = e.LHS.GetType().(*ast.Pointer)
+			var _, rptr = e.RHS.GetType().(*ast.Pointer)
 
 			switch e.Op {
 			case lexer.PLUS, lexer.MINUS, lexer.MUL, lexer.DIV, lexer.MOD:
-				lhs = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
-				rhs = ast.WalkAst(e.RHS, walker, ctx).(llvm.Value)
+				lhs = rvalue(e.LHS, ctx)
+				rhs = rvalue(e.RHS, ctx)
 
-				var l, r llvm.Value = lhs, rhs
-				if l.Type().TypeKind() == llvm.PointerTypeKind {
-					l = walker.Info.builder.CreateLoad(l, "")
-				}
-
-				if r.Type().TypeKind() == llvm.PointerTypeKind {
-					r = walker.Info.builder.CreateLoad(r, "")
+				switch {
+				case lptr && rptr:
+					// ptrdiff_t, in number of elements
+					var iptr = llvm.IntType(ast.Target.PointerWidth)
+					var l = walker.Info.builder.CreatePtrToInt(lhs, iptr, "")
+					var r = walker.Info.builder.CreatePtrToInt(rhs, iptr, "")
+					var diff = walker.Info.builder.CreateSub(l, r, "")
+					var size = llvm.ConstIntCast(llvm.SizeOf(lhs.Type().ElementType()), iptr, false)
+					op = walker.Info.builder.CreateExactSDiv(diff, size, "")
+					op = doConversion(op, &ast.IntegerType{false, ast.Target.IntPtr()}, e.InferedType)
+
+				case lptr:
+					op = pointerAdd(lhs, rhs, e.RHS.GetType(), e.Op == lexer.MINUS)
+
+				case rptr:
+					op = pointerAdd(rhs, lhs, e.LHS.GetType(), false)
+
+				default:
+					op = ariths[e.Op](lhs, rhs, "tmp")
 				}
 
-				//TODO: do `usual arithmetic conversion` in Sema
-				op = ariths[e.Op](l, r, "tmp")
-
 			case lexer.GREAT, lexer.GE, lexer.LESS, lexer.LE, lexer.NE, lexer.EQUAL:
-				lhs = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
-				rhs = ast.WalkAst(e.RHS, walker, ctx).(llvm.Value)
-
-				var l, r llvm.Value = lhs, rhs
-				if l.Type().TypeKind() == llvm.PointerTypeKind {
-					l = walker.Info.builder.CreateLoad(l, "")
-				}
+				lhs = rvalue(e.LHS, ctx)
+				rhs = rvalue(e.RHS, ctx)
 
-				if r.Type().TypeKind() == llvm.PointerTypeKind {
-					r = walker.Info.builder.CreateLoad(r, "")
+				if floating {
+					op = walker.Info.builder.CreateFCmp(fcmps[e.Op], lhs, rhs, "")
+				} else {
+					// addresses are compared as unsigned
+					var pred = cmps[e.Op].signed
+					if unsigned || lptr {
+						pred = cmps[e.Op].unsigned
+					}
+					op = walker.Info.builder.CreateICmp(pred, lhs, rhs, "")
 				}
-				op = walker.Info.builder.CreateICmp(cmps[e.Op].pred, l, r, "")
+				op = walker.Info.builder.CreateZExt(op, symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx), "")
 
 			case lexer.ASSIGN:
-				var r = ast.WalkAst(e.RHS, walker, ctx).(llvm.Value)
+				var r = rvalue(e.RHS, ctx)
 
 				//l must be a lvalue
 				var l = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
@@ -443,53 +1400,60 @@ func MakeLLVMCodeGen() ast.AstWalker {
 					}
 				}
 				log("ASSIGN: l %s, r %s\n", l.Type(), r.Type())
-				walker.Info.builder.CreateStore(r, l)
+				walker.Info.builder.CreateStore(r, l).SetVolatile(isVolatile(e.LHS, ctx))
 				op = r
 
 			case lexer.COMMA:
 				lhs = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
-				rhs = ast.WalkAst(e.RHS, walker, ctx).(llvm.Value)
+				rhs = rvalue(e.RHS, ctx)
 				op = rhs
 
 			case lexer.LOG_OR, lexer.LOG_AND:
+				if walker.Info.builder.GetInsertBlock().IsNil() {
+					// both operands are constants in an initializer of global
+					lhs = toBool(rvalue(e.LHS, ctx))
+					rhs = toBool(rvalue(e.RHS, ctx))
+					if e.Op == lexer.LOG_OR {
+						op = walker.Info.builder.CreateOr(lhs, rhs, "")
+					} else {
+						op = walker.Info.builder.CreateAnd(lhs, rhs, "")
+					}
+					op = walker.Info.builder.CreateZExt(op, symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx), "")
+					break
+				}
+
+				// rhs is evaluated only if lhs does not decide the result
+				lhs = toBool(rvalue(e.LHS, ctx))
 				var lhs_bb = walker.Info.builder.GetInsertBlock()
-				lhs = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
 
-				var val, notval llvm.Value
+				var fn = lhs_bb.Parent()
+				var rhs_bb = llvm.AddBasicBlock(fn, "")
+				var end_bb = llvm.AddBasicBlock(fn, "")
+
+				var short llvm.Value
 				if e.Op == lexer.LOG_OR {
-					val, notval = llvm.ConstInt(llvm.Int1Type(), 0, false), llvm.ConstInt(llvm.Int1Type(), 1, false)
+					short = llvm.ConstInt(llvm.Int1Type(), 1, false)
+					walker.Info.builder.CreateCondBr(lhs, end_bb, rhs_bb)
 				} else {
-					val, notval = llvm.ConstInt(llvm.Int1Type(), 1, false), llvm.ConstInt(llvm.Int1Type(), 0, false)
+					short = llvm.ConstInt(llvm.Int1Type(), 0, false)
+					walker.Info.builder.CreateCondBr(lhs, rhs_bb, end_bb)
 				}
-				var cmp = walker.Info.builder.CreateICmp(llvm.IntEQ, lhs, val, "")
-				var fn = walker.Info.builder.GetInsertBlock().Parent()
-				var rhs_bb = llvm.AddBasicBlock(fn, "")
-				var end_bb = llvm.AddBasicBlock(fn, "")
 
-				walker.Info.builder.CreateCondBr(cmp, rhs_bb, end_bb)
 				walker.Info.builder.SetInsertPoint(rhs_bb, rhs_bb.FirstInstruction())
-				rhs = ast.WalkAst(e.RHS, walker, ctx).(llvm.Value)
+				rhs = toBool(rvalue(e.RHS, ctx))
+				// rhs may end in another block
+				rhs_bb = walker.Info.builder.GetInsertBlock()
 				walker.Info.builder.CreateBr(end_bb)
 
 				walker.Info.builder.SetInsertPoint(end_bb, end_bb.FirstInstruction())
-				var phi = walker.Info.builder.CreatePHI(val.Type(), "")
-				//use const `!val` here is more optimal
-				phi.AddIncoming([]llvm.Value{notval, rhs}, []llvm.BasicBlock{lhs_bb, rhs_bb})
-				op = phi
+				var phi = walker.Info.builder.CreatePHI(llvm.Int1Type(), "")
+				phi.AddIncoming([]llvm.Value{short, rhs}, []llvm.BasicBlock{lhs_bb, rhs_bb})
+				op = walker.Info.builder.CreateZExt(phi, symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx), "")
 
 			case lexer.OR, lexer.XOR, lexer.AND, lexer.LSHIFT, lexer.RSHIFT:
-				lhs = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
-				rhs = ast.WalkAst(e.RHS, walker, ctx).(llvm.Value)
-
-				var l, r llvm.Value = lhs, rhs
-				if l.Type().TypeKind() == llvm.PointerTypeKind {
-					l = walker.Info.builder.CreateLoad(l, "")
-				}
-
-				if r.Type().TypeKind() == llvm.PointerTypeKind {
-					r = walker.Info.builder.CreateLoad(r, "")
-				}
-				op = bitops[e.Op](l, r, "tmp")
+				lhs = rvalue(e.LHS, ctx)
+				rhs = rvalue(e.RHS, ctx)
+				op = bitops[e.Op](lhs, rhs, "tmp")
 
 			default:
 				panic("not implemented")
@@ -503,7 +1467,14 @@ func MakeLLVMCodeGen() ast.AstWalker {
 
 	walker.WalkSizeofExpr = func(ws ast.WalkStage, e *ast.SizeofExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-		} else {
+			// the operand is not evaluated, only its type matters
+			var ty = e.Type
+			if ty == nil {
+				ty = e.Expr.GetType()
+			}
+			var size = llvm.SizeOf(symbolTy2llvmType(ty, walker.Info.llvmCtx))
+			ctx.Value = walker.Info.builder.CreateTrunc(size, symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx), "")
+			return false
 		}
 		return true
 	}
@@ -516,36 +1487,37 @@ func MakeLLVMCodeGen() ast.AstWalker {
 					panic("impossible")
 				}
 				//CreateNot is bitwise not
-				var val2 = walker.Info.builder.CreateLoad(val, val.Name())
-				ctx.Value = walker.Info.builder.CreateNot(val2, val.Name())
+				ctx.Value = walker.Info.builder.CreateNot(loadRValue(e.Expr, val, ctx), "")
 
 			case lexer.NOT:
 				if e.Postfix {
 					panic("impossible")
 				}
-				var val2 = walker.Info.builder.CreateLoad(val, val.Name())
-				var cmp = walker.Info.builder.CreateICmp(llvm.IntNE, val2, llvm.ConstInt(val2.Type(), 0, false), val.Name())
-				var val3 = walker.Info.builder.CreateXor(cmp, llvm.ConstInt(llvm.Int1Type(), 1, false), "")
-				ctx.Value = walker.Info.builder.CreateZExt(val3, val2.Type(), "")
+				var cmp = walker.Info.builder.CreateNot(toBool(loadRValue(e.Expr, val, ctx)), "")
+				ctx.Value = walker.Info.builder.CreateZExt(cmp, symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx), "")
 
 			case lexer.PLUS:
 				if e.Postfix {
 					panic("impossible")
 				}
-				// do nothing
+				ctx.Value = loadRValue(e.Expr, val, ctx)
 
 			case lexer.MINUS:
 				if e.Postfix {
 					panic("impossible")
 				}
-				ctx.Value = walker.Info.builder.CreateNeg(val, val.Name())
+				if ast.IsFloatingType(e.InferedType) {
+					ctx.Value = walker.Info.builder.CreateFNeg(loadRValue(e.Expr, val, ctx), "")
+				} else {
+					ctx.Value = walker.Info.builder.CreateNeg(loadRValue(e.Expr, val, ctx), "")
+				}
 
 			case lexer.MUL:
 				if e.Postfix {
 					panic("impossible")
 				}
-				//pointer deref
-				ctx.Value = walker.Info.builder.CreateLoad(val, val.Name())
+				//pointer deref, the pointer value is the address of lvalue
+				ctx.Value = loadRValue(e.Expr, val, ctx)
 
 			case lexer.AND:
 				if e.Postfix {
@@ -555,28 +1527,36 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				// do nothing, llvm've handled it
 
 			case lexer.INC, lexer.DEC:
-				if e.Postfix {
-					//TODO: postpone side effect to sequence point?
-					var val2 = walker.Info.builder.CreateLoad(val, val.Name())
-					var val3 llvm.Value
+				var volatile = isVolatile(e.Expr, ctx)
+				var val2 = walker.Info.builder.CreateLoad(val, "")
+				val2.SetVolatile(volatile)
+				var val3 llvm.Value
+				if _, yes := e.InferedType.(*ast.Pointer); yes {
+					var one = llvm.ConstInt(llvm.Int64Type(), 1, false)
+					val3 = pointerAdd(val2, one, &ast.IntegerType{false, ast.Target.IntPtr()}, e.Op == lexer.DEC)
+				} else if ast.IsFloatingType(e.InferedType) {
+					var one = llvm.ConstFloat(val2.Type(), 1)
 					if e.Op == lexer.INC {
-						val3 = walker.Info.builder.CreateAdd(val2, llvm.ConstInt(val2.Type(), 1, false), "")
+						val3 = walker.Info.builder.CreateFAdd(val2, one, "")
 					} else {
-						val3 = walker.Info.builder.CreateSub(val2, llvm.ConstInt(val2.Type(), 1, false), "")
+						val3 = walker.Info.builder.CreateFSub(val2, one, "")
 					}
-					walker.Info.builder.CreateStore(val3, val)
-					ctx.Value = val2
-
 				} else {
-					var val2 = walker.Info.builder.CreateLoad(val, val.Name())
-					//side effect
+					var one = llvm.ConstInt(val2.Type(), 1, false)
 					if e.Op == lexer.INC {
-						val2 = walker.Info.builder.CreateAdd(val2, llvm.ConstInt(val2.Type(), 1, false), "")
+						val3 = walker.Info.builder.CreateAdd(val2, one, "")
 					} else {
-						val2 = walker.Info.builder.CreateSub(val2, llvm.ConstInt(val2.Type(), 1, false), "")
+						val3 = walker.Info.builder.CreateSub(val2, one, "")
 					}
-					walker.Info.builder.CreateStore(val2, val)
+				}
+				//side effect
+				//TODO: postpone side effect to sequence point?
+				walker.Info.builder.CreateStore(val3, val).SetVolatile(volatile)
+
+				if e.Postfix {
 					ctx.Value = val2
+				} else {
+					ctx.Value = val3
 				}
 			default:
 				panic("not implemented")
@@ -585,91 +1565,115 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		return true
 	}
 	walker.WalkConditionalOperation = func(ws ast.WalkStage, e *ast.ConditionalOperation, ctx *ast.WalkContext) bool {
-		if ws == ast.WalkerBubbleUp {
+		if ws == ast.WalkerPropagate {
+			var cond = toBool(rvalue(e.Cond, ctx))
+			var ty = symbolTy2llvmType(e.InferedType, walker.Info.llvmCtx)
+
+			if walker.Info.builder.GetInsertBlock().IsNil() {
+				// all operands are constants in an initializer of global
+				var tv, fv = rvalue(e.True, ctx), rvalue(e.False, ctx)
+				if ty.TypeKind() == llvm.PointerTypeKind {
+					tv = walker.Info.builder.CreateBitCast(tv, ty, "")
+					fv = walker.Info.builder.CreateBitCast(fv, ty, "")
+				}
+				ctx.Value = walker.Info.builder.CreateSelect(cond, tv, fv, "")
+				return false
+			}
+
+			var fn = walker.Info.builder.GetInsertBlock().Parent()
+			var true_bb = llvm.AddBasicBlock(fn, "")
+			var false_bb = llvm.AddBasicBlock(fn, "")
+			var end_bb = llvm.AddBasicBlock(fn, "")
+			walker.Info.builder.CreateCondBr(cond, true_bb, false_bb)
+
+			// sema has converted both operands into the result type, except
+			// for pointers which may differ in pointee type
+			var branch = func(bb llvm.BasicBlock, ex ast.Expression) (llvm.Value, llvm.BasicBlock) {
+				walker.Info.builder.SetInsertPoint(bb, bb.FirstInstruction())
+				var v = rvalue(ex, ctx)
+				if ty.TypeKind() == llvm.PointerTypeKind && v.Type() != ty {
+					v = walker.Info.builder.CreateBitCast(v, ty, "")
+				}
+				bb = walker.Info.builder.GetInsertBlock()
+				walker.Info.builder.CreateBr(end_bb)
+				return v, bb
+			}
+
+			var tv, tbb = branch(true_bb, e.True)
+			var fv, fbb = branch(false_bb, e.False)
+
+			walker.Info.builder.SetInsertPoint(end_bb, end_bb.FirstInstruction())
+			if ty.TypeKind() == llvm.VoidTypeKind {
+				ctx.Value = llvm.Value{}
+			} else {
+				var phi = walker.Info.builder.CreatePHI(ty, "")
+				phi.AddIncoming([]llvm.Value{tv, fv}, []llvm.BasicBlock{tbb, fbb})
+				ctx.Value = phi
+			}
+			return false
 		}
 		return true
 	}
 
 	walker.WalkArraySubscriptExpr = func(ws ast.WalkStage, e *ast.ArraySubscriptExpr, ctx *ast.WalkContext) bool {
-		if ws == ast.WalkerBubbleUp {
-			var sub = ast.WalkAst(e.Sub, walker, ctx).(llvm.Value)
-			if sub.Type().TypeKind() == llvm.PointerTypeKind {
-				sub = walker.Info.builder.CreateLoad(sub, sub.Name())
-			}
+		if ws == ast.WalkerPropagate {
+			var sub = rvalue(e.Sub, ctx)
 			var arr = llvm.ConstInt(llvm.Int32Type(), 0, false)
-			var pobj = ast.WalkAst(e.Target, walker, ctx).(llvm.Value)
 
-			log("ArraySubscriptExpr: sub %s, pobj %s\n", sub.Type(), pobj.Type())
 			var val llvm.Value
-			if pobj.Type().ElementType().TypeKind() == llvm.PointerTypeKind {
-				pobj = walker.Info.builder.CreateLoad(pobj, sub.Name())
-				val = walker.Info.builder.CreateInBoundsGEP(pobj, []llvm.Value{sub}, "")
+			if _, yes := e.Target.GetType().(*ast.Pointer); yes {
+				var pobj = rvalue(e.Target, ctx)
+				val = pointerAdd(pobj, sub, e.Sub.GetType(), false)
 			} else {
+				var pobj = ast.WalkAst(e.Target, walker, ctx).(llvm.Value)
+				log("ArraySubscriptExpr: sub %s, pobj %s\n", sub.Type(), pobj.Type())
+				sub = doConversion(sub, e.Sub.GetType(), &ast.IntegerType{false, ast.Target.IntPtr()})
 				val = walker.Info.builder.CreateInBoundsGEP(pobj, []llvm.Value{arr, sub}, "")
 			}
 
 			ctx.Value = val
-
+			return false
 		}
 		return true
 	}
 
 	walker.WalkMemberExpr = func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			walker.Info.state = CSearchRecord
-			var pobj = ast.WalkAst(e.Target, walker, ctx).(llvm.Value)
-			if e.PointerDeref {
-				pobj = walker.Info.builder.CreateLoad(pobj, pobj.Name())
+			// the address of record is the target itself for ., or the
+			// pointer it evaluates to for ->
+			walker.Info.state = CNormal
+			var pobj llvm.Value
+			var ty = e.Target.GetType()
+			if pty, yes := ty.(*ast.Pointer); yes && e.PointerDeref {
+				pobj = rvalue(e.Target, ctx)
+				ty = pty.Source
+			} else {
+				pobj = ast.WalkAst(e.Target, walker, ctx).(llvm.Value)
 			}
 
-			walker.Info.state = CSearchRecordMember
-			var sub = ast.WalkAst(e.Member, walker, ctx).(llvm.Value)
-			walker.Info.state = CNormal
+			var rdty, _ = ast.Unqualify(ty)
+			var name = e.Member.(*ast.DeclRefExpr).Name
+			var offset, _ = ctx.Scope.LookupField(rdty.(*ast.RecordType), name)
+			if offset < 0 {
+				panic("impossible")
+			}
 
-			if sub.Type().TypeKind() == llvm.PointerTypeKind {
-				sub = walker.Info.builder.CreateLoad(sub, sub.Name())
+			var idx = []llvm.Value{
+				llvm.ConstInt(llvm.Int32Type(), 0, false),
+				llvm.ConstInt(llvm.Int32Type(), uint64(offset), false),
 			}
-			var arr = llvm.ConstInt(llvm.Int32Type(), 0, false)
-			ctx.Value = walker.Info.builder.CreateInBoundsGEP(pobj, []llvm.Value{arr, sub}, "")
+			ctx.Value = walker.Info.builder.CreateInBoundsGEP(pobj, idx, "")
 			log("MemberExpr %s\n", ctx.Value.(llvm.Value).Type())
-
-			walker.Info.rdName = ""
 			return false
 		}
 		return true
 	}
 	walker.WalkDeclRefExpr = func(ws ast.WalkStage, e *ast.DeclRefExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			if walker.Info.state == CSearchRecord {
-				var sym = ctx.Scope.LookupRecordVar(e.Name)
-				if sym == nil {
-					panic("impossible")
-				} else {
-					var ty = sym.Type
-					if _, ok := ty.(*ast.Pointer); ok {
-						ty = ty.(*ast.Pointer).Source
-					}
-					var rdty = ty.(*ast.RecordType)
-					walker.Info.rdName = rdty.Name
-				}
-
-				ctx.Value = Find(e.Name)
-
-			} else if walker.Info.state == CSearchRecordMember {
-				var rdty = ctx.Scope.LookupNamedTypeRecursive(walker.Info.rdName, ast.TagNS).(*ast.RecordType)
-				var offset = -1
-				for i, fld := range rdty.Fields {
-					if fld.Name == e.Name {
-						offset = i
-						break
-					}
-				}
-
-				if offset < 0 {
-					panic("impossible")
-				}
-				ctx.Value = llvm.ConstInt(llvm.Int32Type(), uint64(offset), false)
-
+			if _, isFunc := e.GetType().(*ast.Function); isFunc {
+				ctx.Value = walker.Info.Mod.NamedFunction(e.Name)
+			} else if et, yes := ctx.Scope.LookupSymbol(e.Name, ast.OrdinaryNS).Type.(*ast.EnumeratorType); yes {
+				ctx.Value = llvm.ConstInt(llvm.Int32Type(), uint64(et.Value), true)
 			} else {
 				ctx.Value = Find(e.Name)
 			}
@@ -684,29 +1688,30 @@ func MakeLLVMCodeGen() ast.AstWalker {
 	walker.WalkFunctionCall = func(ws ast.WalkStage, e *ast.FunctionCall, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
 			var params []llvm.Value
-			var fn llvm.Value
 
-			fn = walker.Info.Mod.NamedFunction(e.Func.(*ast.DeclRefExpr).Name) //FIXME: not always true
-			// so globals are pointers in llvm ir always
-			log("WalkFunctionCall %v\n", fn.Type().ElementType())
+			setLocation(e.Start)
 
-			if fn.ParamsCount() != len(e.Args) {
+			// sema has decayed the callee into a pointer to function, which is
+			// either a function itself or a loaded function pointer
+			var fn = rvalue(e.Func, ctx)
+			var fty = fn.Type().ElementType()
+			var ptys = fty.ParamTypes()
+			log("WalkFunctionCall %v\n", fty)
+
+			if len(ptys) != len(e.Args) && !(fty.IsFunctionVarArg() && len(e.Args) > len(ptys)) {
 				panic("param count mismatch")
 			}
 
 			for i, arg := range e.Args {
-				var varg = ast.WalkAst(arg, walker, ctx).(llvm.Value)
-				if varg.Type().TypeKind() == llvm.PointerTypeKind {
-					varg = walker.Info.builder.CreateLoad(varg, "")
-				}
-				if varg.Type() != fn.Param(i).Type() {
-					panic(fmt.Sprintf("type mismatch for #%d arg: %s vs %s", i, varg.Type(), fn.Param(i).Type()))
+				var varg = rvalue(arg, ctx)
+				if i < len(ptys) && varg.Type() != ptys[i] {
+					panic(fmt.Sprintf("type mismatch for #%d arg: %s vs %s", i, varg.Type(), ptys[i]))
 				}
 				log("WalkFunctionCall arg %s\n", varg)
 				params = append(params, varg)
 			}
 
-			if fn.Type().ElementType().ReturnType().TypeKind() == llvm.VoidTypeKind {
+			if fty.ReturnType().TypeKind() == llvm.VoidTypeKind {
 				// void return should not be named
 				ctx.Value = walker.Info.builder.CreateCall(fn, params, "")
 			} else {
@@ -716,35 +1721,88 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		}
 		return true
 	}
-	walker.WalkCompoundAssignExpr = func(ws ast.WalkStage, e *ast.CompoundAssignExpr, ctx *ast.WalkContext) bool {
+	walker.WalkBuiltinCallExpr = func(ws ast.WalkStage, e *ast.BuiltinCallExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			var lhs, rhs, op llvm.Value
+			var i8p = llvm.PointerType(llvm.Int8Type(), 0)
+			// sema has decayed va_list into pointer to __va_list_tag
+			var ap = rvalue(e.Args[0], ctx)
+
+			switch e.Name {
+			case "__builtin_va_start", "__builtin_va_end":
+				var fn = addIntrinsic("llvm." + e.Name[len("__builtin_"):])
+				var args = []llvm.Value{walker.Info.builder.CreateBitCast(ap, i8p, "")}
+				ctx.Value = walker.Info.builder.CreateCall(fn, args, "")
+
+			case "__builtin_va_copy":
+				var src = rvalue(e.Args[1], ctx)
+				var args = []llvm.Value{
+					walker.Info.builder.CreateBitCast(ap, i8p, ""),
+					walker.Info.builder.CreateBitCast(src, i8p, ""),
+				}
+				ctx.Value = walker.Info.builder.CreateCall(addIntrinsic("llvm.va_copy"), args, "")
 
-			lhs = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
-			rhs = ast.WalkAst(e.RHS, walker, ctx).(llvm.Value)
-			log("WalkCompoundAssignExpr: lhs %v, rhs %v\n", lhs.Type(), rhs.Type())
+			case "__builtin_va_arg":
+				ctx.Value = vaArg(ap, e.Type, e.Start)
+			}
+			return false
+		}
+		return true
+	}
+	walker.WalkCompoundAssignExpr = func(ws ast.WalkStage, e *ast.CompoundAssignExpr, ctx *ast.WalkContext) bool {
+		if ws == ast.WalkerPropagate {
+			var ops = map[lexer.Kind]func(lhs, rhs llvm.Value, name string) llvm.Value{
+				lexer.PLUS_ASSIGN:   walker.Info.builder.CreateAdd,
+				lexer.MINUS_ASSIGN:  walker.Info.builder.CreateSub,
+				lexer.MUL_ASSIGN:    walker.Info.builder.CreateMul,
+				lexer.DIV_ASSIGN:    walker.Info.builder.CreateSDiv,
+				lexer.MOD_ASSIGN:    walker.Info.builder.CreateSRem,
+				lexer.AND_ASSIGN:    walker.Info.builder.CreateAnd,
+				lexer.OR_ASSIGN:     walker.Info.builder.CreateOr,
+				lexer.XOR_ASSIGN:    walker.Info.builder.CreateXor,
+				lexer.LSHIFT_ASSIGN: walker.Info.builder.CreateShl,
+				lexer.RSHIFT_ASSIGN: walker.Info.builder.CreateAShr,
+			}
 
-			switch e.Op {
-			case lexer.PLUS_ASSIGN:
-				var l, r llvm.Value = lhs, rhs
-				if l.Type().TypeKind() == llvm.PointerTypeKind {
-					l = walker.Info.builder.CreateLoad(l, lhs.Name())
+			// sema has converted rhs into the computation type
+			var lty, cty = e.LHS.GetType(), e.RHS.GetType()
+			if ast.IsUnsigned(cty) {
+				ops[lexer.DIV_ASSIGN] = walker.Info.builder.CreateUDiv
+				ops[lexer.MOD_ASSIGN] = walker.Info.builder.CreateURem
+				ops[lexer.RSHIFT_ASSIGN] = walker.Info.builder.CreateLShr
+			}
+			if ast.IsFloatingType(cty) {
+				ops = map[lexer.Kind]func(lhs, rhs llvm.Value, name string) llvm.Value{
+					lexer.PLUS_ASSIGN:  walker.Info.builder.CreateFAdd,
+					lexer.MINUS_ASSIGN: walker.Info.builder.CreateFSub,
+					lexer.MUL_ASSIGN:   walker.Info.builder.CreateFMul,
+					lexer.DIV_ASSIGN:   walker.Info.builder.CreateFDiv,
 				}
+			}
 
-				if r.Type().TypeKind() == llvm.PointerTypeKind {
-					r = walker.Info.builder.CreateLoad(r, rhs.Name())
-				}
+			var rhs = rvalue(e.RHS, ctx)
+			var lhs = ast.WalkAst(e.LHS, walker, ctx).(llvm.Value)
+			log("WalkCompoundAssignExpr: lhs %v, rhs %v\n", lhs.Type(), rhs.Type())
 
-				var val = walker.Info.builder.CreateAdd(l, r, "")
-				walker.Info.builder.CreateStore(val, lhs)
-				op = val
+			var volatile = isVolatile(e.LHS, ctx)
+			var old = walker.Info.builder.CreateLoad(lhs, "")
+			old.SetVolatile(volatile)
 
-			default:
+			if _, yes := lty.(*ast.Pointer); yes {
+				var val = pointerAdd(old, rhs, cty, e.Op == lexer.MINUS_ASSIGN)
+				walker.Info.builder.CreateStore(val, lhs).SetVolatile(volatile)
+				ctx.Value = val
+				return false
+			}
+
+			if _, ok := ops[e.Op]; !ok {
 				panic("not implemented")
 			}
 
-			ctx.Value = op
+			var l = doConversion(old, lty, cty)
+			var val = doConversion(ops[e.Op](l, rhs, ""), cty, lty)
+			walker.Info.builder.CreateStore(val, lhs).SetVolatile(volatile)
 
+			ctx.Value = val
 			return false
 		}
 		return true
@@ -752,7 +1810,36 @@ func MakeLLVMCodeGen() ast.AstWalker {
 
 	walker.WalkCastExpr = func(ws ast.WalkStage, e *ast.CastExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-		} else {
+			var val = rvalue(e.Expr, ctx)
+			ctx.Value = doConversion(val, e.Expr.GetType(), e.Type)
+			return false
+		}
+		return true
+	}
+
+	walker.WalkImplicitCastExpr = func(ws ast.WalkStage, e *ast.ImplicitCastExpr, ctx *ast.WalkContext) bool {
+		if ws == ast.WalkerPropagate {
+			switch e.CastKind {
+			case ast.IntegralCast, ast.IntegralToFloatingCast, ast.FloatingToIntegralCast, ast.FloatingCast, ast.BitCast:
+				var val = rvalue(e.Expr, ctx)
+				ctx.Value = doConversion(val, e.Expr.GetType(), e.DestType)
+
+			case ast.LValueToRValueCast:
+				ctx.Value = rvalue(e.Expr, ctx)
+
+			case ast.NullToPointerCast:
+				ctx.Value = llvm.ConstPointerNull(symbolTy2llvmType(e.DestType, walker.Info.llvmCtx))
+
+			case ast.ArrayToPointerDecay:
+				var val = ast.WalkAst(e.Expr, walker, ctx).(llvm.Value)
+				var idx = llvm.ConstInt(llvm.Int32Type(), 0, false)
+				ctx.Value = walker.Info.builder.CreateInBoundsGEP(val, []llvm.Value{idx, idx}, "")
+
+			default:
+				// functions are pointers already
+				ctx.Value = ast.WalkAst(e.Expr, walker, ctx)
+			}
+			return false
 		}
 		return true
 	}
@@ -764,36 +1851,38 @@ func MakeLLVMCodeGen() ast.AstWalker {
 	}
 
 	walker.WalkBreakStmt = func(ws ast.WalkStage, e *ast.BreakStmt, ctx *ast.WalkContext) {
-		if InSwitchCaseCounting() {
-			return
-		}
 		if ws == ast.WalkerPropagate {
 			var orig = walker.Info.builder.GetInsertBlock()
 			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
-				walker.Info.builder.CreateBr(GetBreak())
+				var target = GetBreak()
+				endLifetime(target.scopes)
+				walker.Info.builder.CreateBr(target.bb)
+				startDeadBlock()
 			}
 		}
 	}
 
 	walker.WalkContinueStmt = func(ws ast.WalkStage, e *ast.ContinueStmt, ctx *ast.WalkContext) {
 		if ws == ast.WalkerPropagate {
+			var orig = walker.Info.builder.GetInsertBlock()
+			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
+				var target = GetContinue()
+				endLifetime(target.scopes)
+				walker.Info.builder.CreateBr(target.bb)
+				startDeadBlock()
+			}
 		}
 	}
 
 	walker.WalkInitListExpr = func(ws ast.WalkStage, e *ast.InitListExpr, ctx *ast.WalkContext) bool {
-		if ws == ast.WalkerPropagate {
-		}
-		return true
+		// elements are emitted by the declaration that owns the list
+		return false
 	}
 	walker.WalkFieldDecl = func(ws ast.WalkStage, e *ast.FieldDecl, ctx *ast.WalkContext) {
 		if ws == ast.WalkerPropagate {
 		}
 	}
 	walker.WalkRecordDecl = func(ws ast.WalkStage, e *ast.RecordDecl, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return false
-		}
-
 		//TODO: only take care struct, not union
 		sym := ctx.Scope.LookupSymbol(e.Sym, ast.TagNS)
 		if ws == ast.WalkerPropagate {
@@ -809,11 +1898,9 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		} else {
 		}
 	}
-	walker.WalkEnumDecl = func(ws ast.WalkStage, e *ast.EnumDecl, ctx *ast.WalkContext) {
-		if ws == ast.WalkerPropagate {
-
-		} else {
-		}
+	walker.WalkEnumDecl = func(ws ast.WalkStage, e *ast.EnumDecl, ctx *ast.WalkContext) bool {
+		// values of enumerators have been evaluated by sema
+		return false
 	}
 	walker.WalkTypedefDecl = func(ws ast.WalkStage, e *ast.TypedefDecl, ctx *ast.WalkContext) {
 		if ws == ast.WalkerPropagate {
@@ -823,67 +1910,80 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		}
 	}
 	walker.WalkVariableDecl = func(ws ast.WalkStage, e *ast.VariableDecl, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return false
-		}
-		if ws == ast.WalkerBubbleUp {
+		// the initializer is evaluated by the declaration itself
+		if ws == ast.WalkerPropagate {
 			sym := ctx.Scope.LookupSymbol(e.Sym, ast.OrdinaryNS)
 			var vty = symbolTy2llvmType(sym.Type, walker.Info.llvmCtx)
 
-			if e.Ctx.Top == ctx.Scope {
+			if e.Ctx.Top == ctx.Scope || sym.Storage == ast.External {
 				log("decl global %s\n", sym.Name.AsString())
-				var val = llvm.AddGlobal(walker.Info.Mod, vty, sym.Name.AsString())
+				// all declarations of an object refer to the same global
+				var val = walker.Info.Mod.NamedGlobal(sym.Name.AsString())
+				if val.IsNil() {
+					val = llvm.AddGlobal(walker.Info.Mod, vty, sym.Name.AsString())
+				}
+				if sym.Storage == ast.Static {
+					val.SetLinkage(llvm.InternalLinkage)
+				}
+
 				if e.Init != nil {
-					val.SetInitializer(ctx.Value.(llvm.Value))
+					// initializers of globals are folded into constants
+					walker.Info.builder.ClearInsertionPoint()
+					val.SetInitializer(constInit(vty, e.Init, ctx))
+				} else if sym.Storage != ast.External && val.Initializer().IsNil() {
+					// a tentative definition, unless defined by another declaration
+					val.SetInitializer(llvm.ConstNull(vty))
+				}
+				// const objects go to read-only sections
+				val.SetGlobalConstant(isConstObject(sym.Type))
+				if !val.Initializer().IsNil() {
+					declareGlobal(val, sym)
 				}
 				ctx.Value = val
-				Append(val)
-			} else {
-				log("decl local %s(%s)\n", sym.Name.AsString(), vty)
-				var v = walker.Info.builder.CreateAlloca(vty, sym.Name.AsString())
+				AppendAs(sym.Name.AsString(), val)
+			} else if sym.Storage == ast.Static {
+				// a static local is a global initialized once, named after its function
+				var fn = walker.Info.builder.GetInsertBlock()
+				var name = fn.Parent().Name() + "." + sym.Name.AsString()
+				var val = llvm.AddGlobal(walker.Info.Mod, vty, name)
+				val.SetLinkage(llvm.InternalLinkage)
+
+				walker.Info.builder.ClearInsertionPoint()
 				if e.Init != nil {
-					var initval = ctx.Value.(llvm.Value)
-					log("initval %s\n", initval.Type())
-					switch vty.TypeKind() {
-					case llvm.PointerTypeKind:
-						//FIXME&TODO: initval should be pointer type too (this should be made clear in SemaAnalysis)
-						switch initval.Type().ElementType().TypeKind() {
-						case llvm.ArrayTypeKind:
-							var idx = llvm.ConstInt(llvm.Int32Type(), 0, false)
-							initval = walker.Info.builder.CreateInBoundsGEP(initval, []llvm.Value{idx, idx}, "")
-							walker.Info.builder.CreateStore(initval, v)
-						default:
-							walker.Info.builder.CreateStore(initval, v)
-						}
-
-					case llvm.ArrayTypeKind:
-						switch initval.Type().ElementType().TypeKind() {
-						case llvm.ArrayTypeKind:
-							var idx = llvm.ConstInt(llvm.Int32Type(), 0, false)
-							initval = walker.Info.builder.CreateInBoundsGEP(initval, []llvm.Value{idx, idx}, "")
-
-							var cast = walker.Info.builder.CreateBitCast(v, llvm.PointerType(vty.ElementType(), 0), "")
-							var memcpy_fn = addIntrinsic("llvm.memcpy.p0i8.p0i8.i64")
-							var args = []llvm.Value{
-								cast,
-								initval,
-								llvm.ConstInt(llvm.Int64Type(), uint64(vty.ArrayLength()), false),
-								llvm.ConstInt(llvm.Int32Type(), 1, false),
-								llvm.ConstInt(llvm.Int1Type(), 0, false),
-							}
-
-							log("memcpy %s\n", memcpy_fn)
-							walker.Info.builder.CreateCall(memcpy_fn, args, "")
-						default:
-							panic("not impossible")
-						}
+					val.SetInitializer(constInit(vty, e.Init, ctx))
+				} else {
+					val.SetInitializer(llvm.ConstNull(vty))
+				}
+				walker.Info.builder.SetInsertPointAtEnd(fn)
+				val.SetGlobalConstant(isConstObject(sym.Type))
+				declareGlobal(val, sym)
 
-					default:
-						walker.Info.builder.CreateStore(ctx.Value.(llvm.Value), v)
+				ctx.Value = val
+				AppendAs(sym.Name.AsString(), val)
+			} else {
+				log("decl local %s(%s)\n", sym.Name.AsString(), vty)
+				var v = entryAlloca(vty, sym.Name.AsString())
+				var _, quals = ast.Unqualify(sym.Type)
+				var volatile = quals&ast.Volatile != 0
+				setLocation(sym.Name)
+				declareLocal(v, sym.Name, sym.Type, 0)
+				startLifetime(v)
+				if _, yes := e.Init.(*ast.InitListExpr); yes && isAggregate(vty) {
+					var cast = walker.Info.builder.CreateBitCast(v, llvm.PointerType(llvm.Int8Type(), 0), "")
+					var memset_fn = addIntrinsic("llvm.memset.p0i8.i64")
+					var args = []llvm.Value{
+						cast,
+						llvm.ConstInt(llvm.Int8Type(), 0, false),
+						llvm.SizeOf(vty),
+						boolConst(volatile),
 					}
+					walker.Info.builder.CreateCall(memset_fn, args, "")
+				}
+				if e.Init != nil {
+					storeInit(v, e.Init, volatile, ctx)
 				}
 				ctx.Value = v
-				Append(v)
+				AppendAs(sym.Name.AsString(), v)
 			}
 
 			return false
@@ -900,47 +2000,116 @@ func MakeLLVMCodeGen() ast.AstWalker {
 
 		sym := ctx.Scope.LookupSymbol(e.Name, ast.OrdinaryNS)
 		if ws == ast.WalkerPropagate {
-			var ll_fty = symbolTy2llvmType(sym.Type, walker.Info.llvmCtx)
-			var ll_func = llvm.AddFunction(walker.Info.Mod, sym.Name.AsString(), ll_fty)
+			// all declarations of a function refer to the same one
+			var ll_func = walker.Info.Mod.NamedFunction(sym.Name.AsString())
+			if ll_func.IsNil() {
+				var ll_fty = symbolTy2llvmType(sym.Type, walker.Info.llvmCtx)
+				ll_func = llvm.AddFunction(walker.Info.Mod, sym.Name.AsString(), ll_fty)
+			}
+			if sym.Storage == ast.Static {
+				ll_func.SetLinkage(llvm.InternalLinkage)
+			}
+			// restrict pointer params do not alias others, attribute index 0 is the return value
+			for i, aty := range sym.Type.(*ast.Function).Args {
+				if _, quals := ast.Unqualify(aty); quals&ast.Restrict != 0 {
+					var kind = llvm.AttributeKindID("noalias")
+					ll_func.AddAttributeAtIndex(i+1, walker.Info.llvmCtx.CreateEnumAttribute(kind, 0))
+				}
+			}
+			if e.Body == nil {
+				// a prototype only
+				return
+			}
 
 			Append(llvm.Value{}) // nil value as delim
 
+			var bb = llvm.AddBasicBlock(ll_func, "entry")
+			walker.Info.builder.SetInsertPoint(bb, bb.FirstInstruction())
+
+			if walker.Info.di != nil {
+				var sp = walker.Info.di.CreateFunction(walker.Info.diFile, llvm.DIFunction{
+					Name:         sym.Name.AsString(),
+					LinkageName:  sym.Name.AsString(),
+					File:         walker.Info.diFile,
+					Line:         sym.Name.Line,
+					Type:         diType(sym.Type),
+					LocalToUnit:  sym.Storage == ast.Static,
+					IsDefinition: true,
+					ScopeLine:    e.Body.Start.Line,
+					Flags:        llvm.FlagPrototyped,
+				})
+				ll_func.SetSubprogram(sp)
+				walker.Info.diScopes = []llvm.Metadata{sp}
+				setLocation(sym.Name)
+			}
+
+			// all return statements store to the slot and jump to the exit
+			var rty = ll_func.Type().ElementType().ReturnType()
+			walker.Info.ret_bb = llvm.AddBasicBlock(ll_func, "return")
+			walker.Info.retval = llvm.Value{}
+			walker.Info.goto_ = hasLabels(e.Body)
+			if rty.TypeKind() != llvm.VoidTypeKind {
+				walker.Info.retval = entryAlloca(rty, "retval")
+			}
+
+			// params are spilled into allocas, so they are lvalues like other locals.
+			// symbols are found by name, the '.' keeps params away from C identifiers
 			for i, arg := range e.Args {
 				//util.Printf("WalkFunctionDecl: arg(%d) %s\n", i, arg.Sym)
-				ll_func.Param(i).SetName(arg.Sym)
-				Append(ll_func.Param(i))
+				ll_func.Param(i).SetName(arg.Sym + ".arg")
+				var v = entryAlloca(ll_func.Param(i).Type(), arg.Sym)
+				walker.Info.builder.CreateStore(ll_func.Param(i), v)
+				if walker.Info.di != nil {
+					var psym = e.Scope.LookupSymbol(arg.Sym, ast.OrdinaryNS)
+					declareLocal(v, psym.Name, psym.Type, i+1)
+				}
+				Append(v)
 			}
 
-			var bb = llvm.AddBasicBlock(ll_func, "entry")
-			walker.Info.builder.SetInsertPoint(bb, bb.FirstInstruction())
-			if len(e.Body.Stmts) == 0 {
-				walker.Info.builder.CreateRetVoid()
-			}
+		} else if e.Body != nil {
+			var orig = walker.Info.builder.GetInsertBlock()
+			var ret_bb = walker.Info.ret_bb
 
-		} else {
-			var fn = walker.Info.Mod.NamedFunction(sym.Name.AsString())
-			if fn.LastBasicBlock().LastInstruction().IsNil() {
-				// bb has no instrs
-				if fn.LastBasicBlock().AsValue().FirstUse().IsNil() {
-					fn.LastBasicBlock().EraseFromParent()
+			// control falls off the end of function
+			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
+				var reachable = isReachable(orig)
+				switch {
+				case walker.Info.retval.IsNil():
+					walker.Info.builder.CreateBr(ret_bb)
+
+				case sym.Name.AsString() == "main":
+					// reaching the } that terminates main returns 0 (C99 5.1.2.2.3)
+					walker.Info.builder.CreateStore(llvm.ConstNull(walker.Info.retval.Type().ElementType()),
+						walker.Info.retval)
+					walker.Info.builder.CreateBr(ret_bb)
+
+				default:
+					if reachable {
+						addReport(ast.Warning, "codegen.return-type", sym.Name, "control reaches end of non-void function")
+					}
+					walker.Info.builder.CreateUnreachable()
 				}
 			}
+
+			ret_bb.MoveAfter(orig.Parent().LastBasicBlock())
+			walker.Info.builder.SetInsertPointAtEnd(ret_bb)
+			if walker.Info.retval.IsNil() {
+				walker.Info.builder.CreateRetVoid()
+			} else {
+				walker.Info.builder.CreateRet(walker.Info.builder.CreateLoad(walker.Info.retval, ""))
+			}
 			Drop()
 			// all labels should be in function scope
 			DropAllLabels()
+			walker.Info.diScopes = nil
 		}
 	}
 	walker.WalkDeclStmt = func(ws ast.WalkStage, e *ast.DeclStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return false
-		}
 		return true
 	}
 	walker.WalkExprStmt = func(ws ast.WalkStage, e *ast.ExprStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return false
-		}
 		if ws == ast.WalkerPropagate {
+			setLocation(e.Start)
 			ast.WalkAst(e.Expr, walker, ctx)
 			return false
 		}
@@ -949,144 +2118,100 @@ func MakeLLVMCodeGen() ast.AstWalker {
 
 	walker.WalkCaseStmt = func(ws ast.WalkStage, e *ast.CaseStmt, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			if InSwitchCaseCounting() {
-				walker.Info.sw.num_cases++
-				return true
-
-			} else {
-				var orig = walker.Info.builder.GetInsertBlock()
-				var fn = orig.Parent()
-
-				var case_bb = llvm.AddBasicBlock(fn, "")
-				var on = ast.WalkAst(e.ConstExpr, walker, ctx).(llvm.Value)
-				walker.Info.sw.switch_val.AddCase(on, case_bb)
-
-				walker.Info.sw.cases = append(walker.Info.sw.cases, case_bb)
+			var orig = walker.Info.builder.GetInsertBlock()
+			var fn = orig.Parent()
+			var sw = GetSwitchState()
 
-				// insert a fallthrough for last case
-				if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
-					walker.Info.builder.CreateBr(case_bb)
-				}
+			// value has been evaluated by sema in type of condition
+			var case_bb = llvm.AddBasicBlock(fn, "")
+			var on = llvm.ConstInt(sw.inst.Operand(0).Type(), uint64(e.Value), false)
+			sw.inst.AddCase(on, case_bb)
 
-				walker.Info.builder.SetInsertPoint(case_bb, case_bb.FirstInstruction())
-				if e.Stmt == nil {
-					panic("should not be null")
-				}
-				ast.WalkAst(e.Stmt, walker, ctx)
+			// insert a fallthrough for last case
+			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
+				walker.Info.builder.CreateBr(case_bb)
 			}
+
+			walker.Info.builder.SetInsertPointAtEnd(case_bb)
+			ast.WalkAst(e.Stmt, walker, ctx)
 			return false
 		}
 		return true
 	}
 	walker.WalkDefaultStmt = func(ws ast.WalkStage, e *ast.DefaultStmt, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			if InSwitchCaseCounting() {
-				walker.Info.sw.has_default = true
-			} else {
-				if !walker.Info.sw.has_default {
-					panic("impossible")
-				}
+			var orig = walker.Info.builder.GetInsertBlock()
+			var fn = orig.Parent()
+			var sw = GetSwitchState()
 
-				var orig = walker.Info.builder.GetInsertBlock()
-				walker.Info.sw.default_bb.MoveAfter(orig)
-				if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
-					walker.Info.builder.CreateBr(walker.Info.sw.default_bb)
-				}
+			var default_bb = llvm.AddBasicBlock(fn, "default")
+			sw.inst.SetOperand(1, default_bb.AsValue())
 
-				walker.Info.builder.SetInsertPoint(walker.Info.sw.default_bb,
-					walker.Info.sw.default_bb.FirstInstruction())
-				if e.Stmt == nil {
-					panic("should not be null")
-				}
-				ast.WalkAst(e.Stmt, walker, ctx)
+			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
+				walker.Info.builder.CreateBr(default_bb)
 			}
+
+			walker.Info.builder.SetInsertPointAtEnd(default_bb)
+			ast.WalkAst(e.Stmt, walker, ctx)
 			return false
 		}
 		return true
 	}
 
 	walker.WalkReturnStmt = func(ws ast.WalkStage, e *ast.ReturnStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return false
-		}
-		if ws == ast.WalkerBubbleUp {
-			var fn = walker.Info.builder.GetInsertBlock().Parent()
-			var val = ctx.Value.(llvm.Value)
-
-			var rty = fn.Type().ElementType().ReturnType()
-			val = doConversion(val, rty)
-
-			//TODO: collect rets from all paths, and do one ret at the end of function
-			ctx.Value = walker.Info.builder.CreateRet(val)
+		if ws == ast.WalkerPropagate {
+			setLocation(e.Start)
+		} else {
+			if e.Expr != nil && !walker.Info.retval.IsNil() {
+				// conversion to return type has been made explicit by sema
+				var val = loadRValue(e.Expr, ctx.Value.(llvm.Value), ctx)
+				walker.Info.builder.CreateStore(val, walker.Info.retval)
+			}
+			endLifetime(0)
+			ctx.Value = walker.Info.builder.CreateBr(walker.Info.ret_bb)
+			startDeadBlock()
 		}
 		return true
 	}
 	walker.WalkSwitchStmt = func(ws ast.WalkStage, e *ast.SwitchStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return false
-		}
-
 		if ws == ast.WalkerPropagate {
-			var orig = walker.Info.builder.GetInsertBlock()
-			var fn = orig.Parent()
-
-			PushSwitchState()
-
-			walker.Info.sw.count_cases = true
-			log("SwitchStmt collecting  cases\n")
-			ast.WalkAst(e.Body, walker, ctx)
-			walker.Info.sw.count_cases = false
-
-			log("SwitchStmt collect #%d cases, default %d\n", walker.Info.sw.num_cases,
-				walker.Info.sw.has_default)
+			setLocation(e.Start)
+			var fn = walker.Info.builder.GetInsertBlock().Parent()
 
-			var cond_bb = llvm.AddBasicBlock(fn, "")
-			var default_bb llvm.BasicBlock
+			// condition has been promoted by sema, it goes to the end if no
+			// label matches until a default label is met
+			var cond = rvalue(e.Cond, ctx)
 			var end_bb = llvm.AddBasicBlock(fn, "")
-
-			if walker.Info.sw.has_default {
-				default_bb = llvm.AddBasicBlock(fn, "default")
-			}
-			walker.Info.sw.default_bb = default_bb
+			var inst = walker.Info.builder.CreateSwitch(cond, end_bb, 0)
+			PushSwitchState(SwitchState{inst, end_bb})
 			PushBreak(end_bb)
 
-			walker.Info.builder.CreateBr(cond_bb)
-
-			walker.Info.builder.SetInsertPoint(cond_bb, cond_bb.FirstInstruction())
-			var cond = ast.WalkAst(e.Cond, walker, ctx).(llvm.Value)
-
-			for cond.Type().TypeKind() == llvm.PointerTypeKind {
-				cond = walker.Info.builder.CreateLoad(cond, "")
-			}
-			if default_bb.IsNil() {
-				walker.Info.sw.switch_val = walker.Info.builder.CreateSwitch(cond, end_bb,
-					walker.Info.sw.num_cases)
-			} else {
-				walker.Info.sw.switch_val = walker.Info.builder.CreateSwitch(cond, default_bb,
-					walker.Info.sw.num_cases)
-			}
-
+			// statements before the first label are unreachable
+			var body_bb = llvm.AddBasicBlock(fn, "")
+			walker.Info.builder.SetInsertPointAtEnd(body_bb)
 			ast.WalkAst(e.Body, walker, ctx)
 
-			orig = walker.Info.builder.GetInsertBlock()
+			var orig = walker.Info.builder.GetInsertBlock()
 			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
-				walker.Info.builder.CreateBr(GetBreak())
+				walker.Info.builder.CreateBr(end_bb)
+			}
+
+			// the placeholder only falls through to the first label
+			if first := body_bb.FirstInstruction(); first == body_bb.LastInstruction() {
+				body_bb.EraseFromParent()
 			}
 
 			end_bb.MoveAfter(fn.LastBasicBlock())
-			walker.Info.builder.SetInsertPoint(end_bb, end_bb.LastInstruction())
+			walker.Info.builder.SetInsertPointAtEnd(end_bb)
 			PopBreak()
-
 			PopSwitchState()
 			return false
 		}
 		return true
 	}
 	walker.WalkWhileStmt = func(ws ast.WalkStage, e *ast.WhileStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return true
-		}
 		if ws == ast.WalkerPropagate {
+			setLocation(e.Start)
 			var orig = walker.Info.builder.GetInsertBlock()
 			var fn = orig.Parent()
 
@@ -1095,11 +2220,12 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				walker.Info.builder.CreateBr(cond_bb)
 			}
 			walker.Info.builder.SetInsertPoint(cond_bb, cond_bb.FirstInstruction())
-			var cond = ast.WalkAst(e.Cond, walker, ctx).(llvm.Value)
+			var cond = toBool(rvalue(e.Cond, ctx))
 
 			var body_bb = llvm.AddBasicBlock(fn, "")
 			var merge_bb = llvm.AddBasicBlock(fn, "")
 			PushBreak(merge_bb)
+			PushContinue(cond_bb)
 			walker.Info.builder.CreateCondBr(cond, body_bb, merge_bb)
 
 			walker.Info.builder.SetInsertPoint(body_bb, body_bb.FirstInstruction())
@@ -1112,6 +2238,7 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				walker.Info.builder.CreateBr(cond_bb)
 			}
 
+			PopContinue()
 			PopBreak()
 			walker.Info.builder.SetInsertPoint(merge_bb, merge_bb.LastInstruction())
 			return false
@@ -1119,17 +2246,17 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		return true
 	}
 	walker.WalkDoStmt = func(ws ast.WalkStage, e *ast.DoStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return true
-		}
 		if ws == ast.WalkerPropagate {
+			setLocation(e.Start)
 			var orig = walker.Info.builder.GetInsertBlock()
 			var fn = orig.Parent()
 
-			// cond_bb should create before body travesal, so break stmt inside body can use this
-			// as jump-out label
+			// cond_bb and merge_bb should create before body travesal, so
+			// continue and break stmt inside body can use them as jump-out label
 			var cond_bb = llvm.AddBasicBlock(fn, "")
-			PushBreak(cond_bb)
+			var merge_bb = llvm.AddBasicBlock(fn, "")
+			PushBreak(merge_bb)
+			PushContinue(cond_bb)
 			var body_bb = llvm.AddBasicBlock(fn, "do")
 			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
 				walker.Info.builder.CreateBr(body_bb)
@@ -1145,11 +2272,12 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				walker.Info.builder.CreateBr(cond_bb)
 			}
 			walker.Info.builder.SetInsertPoint(cond_bb, cond_bb.FirstInstruction())
-			var cond = ast.WalkAst(e.Cond, walker, ctx).(llvm.Value)
+			var cond = toBool(rvalue(e.Cond, ctx))
 
-			var merge_bb = llvm.AddBasicBlock(fn, "")
+			merge_bb.MoveAfter(walker.Info.builder.GetInsertBlock())
 			walker.Info.builder.CreateCondBr(cond, body_bb, merge_bb)
 
+			PopContinue()
 			PopBreak()
 			walker.Info.builder.SetInsertPoint(merge_bb, merge_bb.LastInstruction())
 			return false
@@ -1158,10 +2286,8 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		return true
 	}
 	walker.WalkIfStmt = func(ws ast.WalkStage, e *ast.IfStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return true
-		}
 		if ws == ast.WalkerPropagate {
+			setLocation(e.Start)
 			var orig = walker.Info.builder.GetInsertBlock()
 			var fn = orig.Parent()
 
@@ -1169,14 +2295,18 @@ func MakeLLVMCodeGen() ast.AstWalker {
 			var else_bb = llvm.AddBasicBlock(fn, "")
 			var merge_bb = llvm.AddBasicBlock(fn, "")
 
-			var cond = ast.WalkAst(e.Cond, walker, ctx).(llvm.Value)
+			var cond = toBool(rvalue(e.Cond, ctx))
 			walker.Info.builder.CreateCondBr(cond, then_bb, else_bb)
 
 			walker.Info.builder.SetInsertPoint(then_bb, then_bb.FirstInstruction())
 			if e.TrueBranch != nil {
 				ast.WalkAst(e.TrueBranch, walker, ctx)
 			}
-			if then_bb.LastInstruction().IsNil() || then_bb.LastInstruction().IsATerminatorInst().IsNil() {
+
+			// a branch may end in another block than it starts, e.g. with
+			// nested if or && in it
+			orig = walker.Info.builder.GetInsertBlock()
+			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
 				walker.Info.builder.CreateBr(merge_bb)
 			}
 
@@ -1197,10 +2327,6 @@ func MakeLLVMCodeGen() ast.AstWalker {
 	}
 
 	walker.WalkLabelStmt = func(ws ast.WalkStage, e *ast.LabelStmt, ctx *ast.WalkContext) {
-		if InSwitchCaseCounting() {
-			return
-		}
-
 		if ws == ast.WalkerPropagate {
 			var orig = walker.Info.builder.GetInsertBlock()
 			var fn = orig.Parent()
@@ -1220,9 +2346,6 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		}
 	}
 	walker.WalkGotoStmt = func(ws ast.WalkStage, e *ast.GotoStmt, ctx *ast.WalkContext) {
-		if InSwitchCaseCounting() {
-			return
-		}
 		if ws == ast.WalkerPropagate {
 			var label_bb = FindLabel(e.Label)
 			if label_bb.IsNil() {
@@ -1232,14 +2355,13 @@ func MakeLLVMCodeGen() ast.AstWalker {
 				AddLabel(e.Label, label_bb)
 			}
 			walker.Info.builder.CreateBr(label_bb)
+			startDeadBlock()
 		}
 	}
 
 	walker.WalkForStmt = func(ws ast.WalkStage, e *ast.ForStmt, ctx *ast.WalkContext) bool {
-		if InSwitchCaseCounting() {
-			return true
-		}
 		if ws == ast.WalkerPropagate {
+			setLocation(e.Start)
 			Append(llvm.Value{}) // nil value as delim
 
 			var orig = walker.Info.builder.GetInsertBlock()
@@ -1258,13 +2380,21 @@ func MakeLLVMCodeGen() ast.AstWalker {
 			walker.Info.builder.CreateBr(cond_bb)
 
 			walker.Info.builder.SetInsertPoint(cond_bb, cond_bb.FirstInstruction())
-			var cond = ast.WalkAst(e.Cond, walker, ctx).(llvm.Value)
-			walker.Info.builder.CreateCondBr(cond, body_bb, end_bb)
+			if e.Cond != nil {
+				var cond = toBool(rvalue(e.Cond, ctx))
+				walker.Info.builder.CreateCondBr(cond, body_bb, end_bb)
+			} else {
+				walker.Info.builder.CreateBr(body_bb)
+			}
 
 			walker.Info.builder.SetInsertPoint(body_bb, body_bb.FirstInstruction())
+			PushBreak(end_bb)
+			PushContinue(step_bb)
 			if e.Body != nil {
 				ast.WalkAst(e.Body, walker, ctx)
 			}
+			PopContinue()
+			PopBreak()
 			orig = walker.Info.builder.GetInsertBlock()
 			if orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil() {
 				walker.Info.builder.CreateBr(step_bb)
@@ -1284,12 +2414,29 @@ func MakeLLVMCodeGen() ast.AstWalker {
 		return true
 	}
 	walker.WalkCompoundStmt = func(ws ast.WalkStage, e *ast.CompoundStmt, ctx *ast.WalkContext) {
-		if InSwitchCaseCounting() {
-			return
-		}
 		if ws == ast.WalkerPropagate {
 			Append(llvm.Value{}) // nil value as delim
+			walker.Info.scopes = append(walker.Info.scopes, nil)
+
+			// the body of function is in the scope of subprogram
+			if walker.Info.di != nil && len(walker.Info.scopes) > 1 {
+				var block = walker.Info.di.CreateLexicalBlock(diScope(), llvm.DILexicalBlock{
+					File:   walker.Info.diFile,
+					Line:   e.Start.Line,
+					Column: e.Start.Column + 1,
+				})
+				walker.Info.diScopes = append(walker.Info.diScopes, block)
+			}
 		} else {
+			var n = len(walker.Info.scopes) - 1
+			var orig = walker.Info.builder.GetInsertBlock()
+			if (orig.LastInstruction().IsNil() || orig.LastInstruction().IsATerminatorInst().IsNil()) && !isDeadBlock(orig) {
+				endLifetime(n)
+			}
+			walker.Info.scopes = walker.Info.scopes[:n]
+			if walker.Info.di != nil && n > 0 {
+				walker.Info.diScopes = walker.Info.diScopes[:len(walker.Info.diScopes)-1]
+			}
 			Drop()
 		}
 	}
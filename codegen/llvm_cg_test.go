@@ -14,6 +14,17 @@ import (
 	llvm "tinygo.org/x/go-llvm"
 )
 
+// tests are run by the interpreter unless they rely on native code
+// generation, e.g. the x86-64 va_arg sequence
+var newEngine = llvm.NewExecutionEngine
+
+func newMCJIT(mod llvm.Module) (llvm.ExecutionEngine, error) {
+	llvm.LinkInMCJIT()
+	llvm.InitializeNativeTarget()
+	llvm.InitializeNativeAsmPrinter()
+	return llvm.NewMCJITCompiler(mod, llvm.NewMCJITCompilerOptions())
+}
+
 func testTemplate(t *testing.T, text string, args []llvm.GenericValue, expect uint64,
 	run func(llvm.Module, llvm.ExecutionEngine)) ast.Ast {
 	opts := parser.ParseOption{
@@ -44,7 +55,7 @@ func testTemplate(t *testing.T, text string, args []llvm.GenericValue, expect ui
 		}
 		llvm.VerifyModule(mod, llvm.AbortProcessAction)
 
-		if engine, err := llvm.NewExecutionEngine(mod); err == nil {
+		if engine, err := newEngine(mod); err == nil {
 			if run != nil {
 				run(mod, engine)
 			} else {
@@ -673,6 +684,1558 @@ int main(int arg)
 	testTemplate(t, text, nil, 0, run)
 }
 
+func TestSimple17(t *testing.T) {
+	var text = `
+int main(int arg)
+{
+	unsigned int u = -1;
+	int i = -1;
+	unsigned char uc = 200;
+	char c = -56;
+	unsigned short us = 65535;
+	long l = 0;
+	unsigned long ul = 0;
+
+	if (arg == 0)
+		return u > 1;
+	if (arg == 1)
+		return i < 1;
+	if (arg == 2)
+		return i < u;
+	if (arg == 3)
+		return uc / 2;
+	if (arg == 4)
+		return c / 2;
+	if (arg == 5)
+		return u >> 28;
+	if (arg == 6)
+		return i >> 28;
+	if (arg == 7)
+		return u % 10;
+	if (arg == 8)
+		return -7 % 3;
+	if (arg == 9) {
+		l = i;
+		return l < 0;
+	}
+	if (arg == 10) {
+		l = u;
+		return l > 0;
+	}
+	if (arg == 11)
+		return us + 1 > 65535;
+	if (arg == 12) {
+		uc += 100;
+		return uc;
+	}
+	if (arg == 13) {
+		c >>= 2;
+		return c;
+	}
+	if (arg == 14) {
+		u /= 2;
+		return u == 2147483647;
+	}
+	if (arg == 15) {
+		ul = 1;
+		return ul > i;
+	}
+	if (arg == 16)
+		return (unsigned char)c;
+	return ~uc;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{1, 1, 0, 100, -28, 15, -1, 5, -1, 1, 1, 1, 44, -14, 1, 0, 200, -201}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple18(t *testing.T) {
+	var text = `
+int main(int arg)
+{
+	double d = 2.5;
+	float f = 0.5f;
+	unsigned int u = -1;
+	double r = 0;
+
+	if (arg == 0)
+		return d * 4;
+	if (arg == 1)
+		return d + f > 2.75;
+	if (arg == 2)
+		return (d - arg * 3) * 10;
+	if (arg == 3) {
+		r = d / 0.5;
+		return r == 5.0;
+	}
+	if (arg == 4) {
+		r = u;
+		return r / 1000000000;
+	}
+	if (arg == 5) {
+		f += 1;
+		f *= 3;
+		return f;
+	}
+	if (arg == 6)
+		return -d * 2;
+	if (arg == 7) {
+		d++;
+		--f;
+		return d + f * 10;
+	}
+	if (arg == 8)
+		return !(f - 0.5) + (d != d);
+	if (arg == 9) {
+		r = 7.5;
+		return r % 2 * 10;
+	}
+	if (arg <= 10 && d)
+		return (unsigned char)(d * 100);
+	return 0;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{10, 1, -35, 1, 4, 4, -5, -1, 1, 15, 250}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple19(t *testing.T) {
+	var text = `
+int main(int arg)
+{
+	int n = 0;
+	int r = 0;
+	unsigned int u = 1;
+	int *p = 0;
+
+	if (arg == 0) {
+		r = (arg != 0) && (n = 1);
+		return n * 10 + r;
+	}
+	if (arg == 1) {
+		r = (arg == 1) || n++;
+		return n * 10 + r;
+	}
+	if (arg == 2) {
+		r = arg == 2 && (n++, 3);
+		return n * 10 + r;
+	}
+	if (arg == 3) {
+		r = arg > 5 ? n++ : n--;
+		return n * 10 + r;
+	}
+	if (arg == 4) {
+		r = arg ? 2.5 * 2 : n++;
+		return r * 10 + n;
+	}
+	if (arg == 5)
+		return (arg && arg - 5) || (arg > 3 && !n++ && !n++) || n++;
+	if (arg == 6)
+		return (arg > 3 ? -1 : u) > 0;
+	if (arg == 7) {
+		p = arg ? &n : 0;
+		*p = 7;
+		return p ? n : -1;
+	}
+	while (n < 3 && arg++)
+		n++;
+	return n * 100 + arg;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{0, 1, 11, -10, 50, 1, 1, 7, 311}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple20(t *testing.T) {
+	var text = `
+int main(int arg)
+{
+	int a[8];
+	int *p = a;
+	int *q = 0;
+	char *s = "hello";
+	char *c = 0;
+
+	for (int i = 0; i < 8; i++)
+		a[i] = i * 10;
+
+	if (arg == 0)
+		return *(p + 3);
+	if (arg == 1) {
+		q = p + 5;
+		return q - p;
+	}
+	if (arg == 2) {
+		q = &a[6];
+		return p - q;
+	}
+	if (arg == 3) {
+		p++;
+		++p;
+		return *p;
+	}
+	if (arg == 4) {
+		q = a + 7;
+		q -= 2;
+		q--;
+		return *q + (q > p) + (p < q) * 2 + (p >= p) * 4 + (q <= p) * 8;
+	}
+	if (arg == 5)
+		return (q == 0) + (p != 0) * 2 + (0 == c) * 4 + !q * 8;
+	if (arg == 6) {
+		unsigned int u = 2;
+		q = p + u;
+		p += 4;
+		return p[-1] + *q + (2 + p)[1];
+	}
+	if (arg == 7) {
+		void *v = p;
+		return (v == p) + (p == v) * 2 + (v != 0) * 4;
+	}
+	c = s;
+	while (*c)
+		c++;
+	return c - s;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{30, 5, -6, 20, 47, 15, 120, 7, 5}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple21(t *testing.T) {
+	var text = `
+int g[2][3] = {{1, 2, 3}, {4, 5}};
+int h[][2] = {1, 2, 3, 4, 5};
+
+int main(int arg)
+{
+	int a[3][4];
+	int i, j, s = 0;
+	for (i = 0; i < 3; i++)
+		for (j = 0; j < 4; j++)
+			a[i][j] = i * 10 + j;
+
+	if (arg == 0)
+		return a[2][3] + a[1][0];
+	if (arg == 1) {
+		int b[2][2][2] = {{{1, 2}, {3, 4}}, {{5, 6}, {7, 8}}};
+		return b[1][0][1] * 10 + b[0][1][0];
+	}
+	if (arg == 2) {
+		int c[2][3] = {1, 2, 3, 4};
+		return c[1][0] * 100 + c[1][1] * 10 + c[1][2];
+	}
+	if (arg == 3)
+		return g[1][1] * 10 + g[1][2] + g[0][2];
+	if (arg == 4)
+		return h[2][0] * 10 + h[2][1] + h[1][1];
+	if (arg == 5) {
+		int (*r)[4] = a;
+		r++;
+		return r[1][2] + (*r)[3];
+	}
+	if (arg == 6) {
+		int *p = a[1];
+		return p[5] + *(a[2] + 1);
+	}
+	int d[][3] = {{1}, {2, 5}, {3}};
+	for (i = 0; i < 3; i++)
+		s += d[i][0] + d[i][1] + d[i][2];
+	return s;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{33, 63, 400, 53, 54, 35, 42, 11}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple22(t *testing.T) {
+	var text = `
+int add1(int x) { return x + 1; }
+int dbl(int x) { return x * 2; }
+int neg(int x) { return -x; }
+
+int (*gops[3])(int) = {add1, dbl, neg};
+
+struct ops {
+	int (*f)(int);
+	int base;
+};
+
+int apply(int (*f)(int), int v) { return f(v); }
+int apply2(int g(int), int v) { return (*g)(g(v)); }
+
+int main(int arg)
+{
+	int (*fp)(int) = add1;
+	int (*ops[3])(int) = {add1, dbl, &neg};
+	struct ops o;
+
+	if (arg == 0)
+		return fp(41);
+	if (arg == 1) {
+		fp = &dbl;
+		return (*fp)(21);
+	}
+	if (arg == 2)
+		return ops[0](1) + ops[1](2) + ops[2](3);
+	if (arg == 3)
+		return gops[1](gops[0](4));
+	if (arg == 4)
+		return apply(neg, 7) + apply(dbl, 10);
+	if (arg == 5)
+		return apply2(dbl, 3);
+	o.f = add1;
+	o.base = 5;
+	return o.f(o.base) + (fp == add1) * 100;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{42, 42, 3, 10, 13, 12, 106}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple23(t *testing.T) {
+	var text = `
+int vsum(int n, __builtin_va_list ap)
+{
+	int s = 0;
+	while (n-- > 0)
+		s += __builtin_va_arg(ap, int);
+	return s;
+}
+
+int sum(int n, ...)
+{
+	__builtin_va_list ap, ap2;
+	__builtin_va_start(ap, n);
+	__builtin_va_copy(ap2, ap);
+	int s = vsum(n, ap) * 2 + vsum(n, ap2);
+	__builtin_va_end(ap2);
+	__builtin_va_end(ap);
+	return s;
+}
+
+double fsum(int n, ...)
+{
+	__builtin_va_list ap;
+	double s = 0;
+	__builtin_va_start(ap, n);
+	while (n-- > 0)
+		s += __builtin_va_arg(ap, double);
+	__builtin_va_end(ap);
+	return s;
+}
+
+long mixed(int n, ...)
+{
+	__builtin_va_list ap;
+	__builtin_va_start(ap, n);
+	int a = __builtin_va_arg(ap, int);
+	double d = __builtin_va_arg(ap, double);
+	int *p = __builtin_va_arg(ap, int *);
+	long l = __builtin_va_arg(ap, long);
+	__builtin_va_end(ap);
+	return a + (int)d + *p + l;
+}
+
+int main(int arg)
+{
+	int x = 7;
+	if (arg == 0)
+		return sum(8, 1, 2, 3, 4, 5, 6, 7, 8);
+	if (arg == 1)
+		return fsum(10, 1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 8.0, 9.0, 10.5);
+	return mixed(4, 1, 2.5, &x, (long)100);
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{108, 55, 110}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+
+	newEngine = newMCJIT
+	defer func() { newEngine = llvm.NewExecutionEngine }()
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple24(t *testing.T) {
+	var text = `
+struct point {
+	int x;
+	int y;
+};
+
+int x = 7;
+int arr[5] = {1, 2, 3};
+int grid[2][3] = {1, 2, 3, 4};
+struct point pts[2] = {{1, 2}, 3, 4};
+struct point origin = {10};
+char s[] = "hello";
+char t[8] = "ab";
+char names[2][4] = {"ab", "cde"};
+char *msg = "world";
+int *px = &x;
+int *pa = &arr[2];
+int *pb = arr + 1;
+int *py = &origin.y;
+struct point *pp = pts;
+struct point *pq = &pts[1];
+long size = sizeof(arr) / sizeof(arr[0]) * 2 + (3 > 2 ? 1 : 0) + (1 && 0);
+double half = 1.0 / 2;
+
+int main(int arg)
+{
+	struct point lp = {5, 6};
+	char ls[6] = "hi";
+	int la[2][2] = {{1}, {2, 3}};
+
+	if (arg == 0)
+		return arr[0] + arr[1] + arr[2] + arr[3] + grid[1][0];
+	if (arg == 1)
+		return pp->y + pq->x + pq->y + origin.x + origin.y;
+	if (arg == 2)
+		return s[1] + sizeof(s) + t[1] + t[5] + names[1][2];
+	if (arg == 3)
+		return *px + *pa + *pb + *py + msg[0];
+	if (arg == 4)
+		return size + half * 4;
+	return lp.x + lp.y + ls[1] + ls[4] + la[0][1] + la[1][1];
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var expects = []int{10, 19, 306, 131, 13, 119}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple25(t *testing.T) {
+	var text = `
+extern int later;
+static int counter;
+int tent;
+int tent;
+int twice(int v);
+
+static int helper(int v) { return v * 2; }
+int twice(int v) { return helper(v); }
+
+int next()
+{
+	static int n = 10;
+	static int *p = &n;
+	counter++;
+	return (*p)++;
+}
+
+int main(int arg)
+{
+	extern int tent;
+	if (arg == 0)
+		return twice(later);
+	if (arg == 1)
+		return next() + next() + counter;
+	tent = 3;
+	return tent;
+}
+
+int later = 21;
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var linkages = map[string]llvm.Linkage{
+			"counter": llvm.InternalLinkage,
+			"tent":    llvm.ExternalLinkage,
+			"later":   llvm.ExternalLinkage,
+			"next.n":  llvm.InternalLinkage,
+		}
+		for name, linkage := range linkages {
+			if v := mod.NamedGlobal(name); v.IsNil() || v.Linkage() != linkage || v.Initializer().IsNil() {
+				t.Errorf("global %s should be defined with linkage %v", name, linkage)
+			}
+		}
+		if mod.NamedFunction("helper").Linkage() != llvm.InternalLinkage {
+			t.Errorf("static function should be internal")
+		}
+
+		var expects = []int{42, 23, 3}
+		for i := 0; i < len(expects); i++ {
+			var args = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(i), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), args)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", i, expects[i], int(ret.Int(true)))
+			}
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple26(t *testing.T) {
+	var text = `
+enum color { RED, GREEN = 4, BLUE, LAST = BLUE * 2 };
+
+int classify(int n)
+{
+	int r = 0;
+	switch (n) {
+	case RED:
+		r = 1;
+	case GREEN:
+		r += 10;
+		break;
+	default:
+		r = -1;
+	case BLUE: {
+		int k = n * 3;
+		r += k;
+		break;
+	}
+	case LAST:
+		switch (n - LAST) {
+		case 0:
+			r = 100;
+			break;
+		default:
+			r = 200;
+		}
+		r += 1;
+		break;
+	case (char)258:
+		r = 2;
+		break;
+	case 1 + 2 * 3:
+		r = 7;
+		int m = 70;
+		r += m;
+		break;
+	}
+	return r;
+}
+
+int main(int arg)
+{
+	char c = 'b';
+	int t = 0;
+	switch (c) {
+	case 'a':
+		t = 1;
+		break;
+	case 'b':
+		t = 2;
+		break;
+	}
+	return classify(arg) + t;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var ninsts = 0
+		for bb := mod.NamedFunction("classify").FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if !inst.IsASwitchInst().IsNil() {
+					ninsts++
+				}
+			}
+		}
+		if ninsts != 2 {
+			t.Errorf("each switch should be a single switch instruction, got %d", ninsts)
+		}
+
+		var args = []int{0, 1, 2, 4, 5, 7, 10}
+		var expects = []int{13, 4, 4, 12, 17, 79, 103}
+		for i, arg := range args {
+			var params = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(arg), false),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), params)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", arg, expects[i], int(ret.Int(true)))
+			}
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple27(t *testing.T) {
+	var text = `
+int pick(int x)
+{
+	if (x > 0)
+		return 1;
+	else
+		return 2;
+}
+
+int maybe(int x)
+{
+	if (x)
+		return 3;
+}
+
+void set(int *p, int v)
+{
+	if (v < 0)
+		return;
+	*p = v;
+}
+
+int main(int arg)
+{
+	int v = 0;
+	set(&v, arg);
+	if (v == 1)
+		return pick(v) + maybe(v);
+	if (v == 2)
+		return pick(-v);
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		for _, name := range []string{"pick", "maybe", "set", "main"} {
+			var nrets = 0
+			for bb := mod.NamedFunction(name).FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+				if !bb.LastInstruction().IsAReturnInst().IsNil() {
+					nrets++
+				}
+			}
+			if nrets != 1 {
+				t.Errorf("%s should have a single exit, got %d", name, nrets)
+			}
+		}
+
+		if len(Reports) != 1 || Reports[0].Desc != "control reaches end of non-void function" ||
+			Reports[0].AsString() != "maybe" {
+			t.Errorf("should warn on falling off the end of maybe")
+		}
+
+		var args = []int{1, 2, 3, -1}
+		var expects = []int{4, 2, 0, 0}
+		for i, arg := range args {
+			var params = []llvm.GenericValue{
+				llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(arg), true),
+			}
+			ret := engine.RunFunction(mod.NamedFunction("main"), params)
+			if ret.Int(true) != uint64(expects[i]) {
+				t.Errorf("wrong answer for %d: expect %d, ret %d", arg, expects[i], int(ret.Int(true)))
+			}
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple28(t *testing.T) {
+	var text = `
+int jump(int n)
+{
+	int r = 0;
+again:
+	{
+		int k = n;
+		r += k;
+	}
+	n--;
+	if (n > 0)
+		goto again;
+	return r;
+}
+
+int main()
+{
+	int sum = 0;
+	int i;
+	for (i = 0; i < 10; i++) {
+		int sq = i * i;
+		if (sq < 50)
+			sum += sq;
+		sum += sq;
+	}
+	while (sum > 100) {
+		int half = sum / 2;
+		sum = half;
+	}
+	return sum + jump(3);
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var count = func(name string, pred func(llvm.Value) bool) (entry, others int) {
+			var fn = mod.NamedFunction(name)
+			for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+				for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+					if !pred(inst) {
+						continue
+					}
+					if bb == fn.EntryBasicBlock() {
+						entry++
+					} else {
+						others++
+					}
+				}
+			}
+			return
+		}
+		var isAlloca = func(v llvm.Value) bool { return !v.IsAAllocaInst().IsNil() }
+		var isLifetime = func(v llvm.Value) bool {
+			return !v.IsACallInst().IsNil() &&
+				strings.HasPrefix(v.CalledValue().Name(), "llvm.lifetime.")
+		}
+
+		// retval, sum, i, sq, half
+		if entry, others := count("main", isAlloca); entry != 5 || others != 0 {
+			t.Errorf("allocas of main should be in entry block, got %d and %d", entry, others)
+		}
+		// sq and half start and end in loop bodies, sum and i end at return
+		if _, others := count("main", isLifetime); others != 6 {
+			t.Errorf("lifetimes of locals in loops should be marked, got %d", others)
+		}
+		// labels can bypass declarations
+		if entry, others := count("jump", isLifetime); entry+others != 0 {
+			t.Errorf("lifetime should not be marked when there are labels")
+		}
+
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 59 {
+			t.Errorf("wrong answer, expect %d, ret %d", 59, int(ret.Int(true)))
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple29(t *testing.T) {
+	var text = `
+const int limit = 10;
+const int primes[3] = {2, 3, 5};
+volatile int flag;
+
+struct dev {
+	volatile int status;
+	int count;
+};
+
+int sum(int n, int * restrict out, const int * restrict in)
+{
+	volatile int acc = 0;
+	int i;
+	for (i = 0; i < n; i++)
+		acc += in[i];
+	*out = acc;
+	return flag + limit;
+}
+
+int poll(struct dev *d)
+{
+	d->status = 1;
+	d->count++;
+	return d->status + d->count;
+}
+
+int main()
+{
+	int o;
+	struct dev d;
+	d.count = 0;
+	return sum(3, &o, primes) + o + poll(&d);
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var count = func(name string) (volatiles, others int) {
+			var fn = mod.NamedFunction(name)
+			for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+				for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+					if inst.IsALoadInst().IsNil() && inst.IsAStoreInst().IsNil() {
+						continue
+					}
+					if inst.IsVolatile() {
+						volatiles++
+					} else {
+						others++
+					}
+				}
+			}
+			return
+		}
+
+		// acc: init, load and store in loop, read; flag: read
+		if volatiles, _ := count("sum"); volatiles != 5 {
+			t.Errorf("accesses of volatile objects should be volatile, got %d", volatiles)
+		}
+		// status is written then read, count is not volatile
+		if volatiles, _ := count("poll"); volatiles != 2 {
+			t.Errorf("accesses of volatile members should be volatile, got %d", volatiles)
+		}
+		if volatiles, _ := count("main"); volatiles != 0 {
+			t.Errorf("no volatile access expected in main, got %d", volatiles)
+		}
+
+		for _, name := range []string{"limit", "primes"} {
+			if !mod.NamedGlobal(name).IsGlobalConstant() {
+				t.Errorf("const global %s should be constant", name)
+			}
+		}
+		if mod.NamedGlobal("flag").IsGlobalConstant() {
+			t.Errorf("volatile global flag should not be constant")
+		}
+
+		var noalias = llvm.AttributeKindID("noalias")
+		var fn = mod.NamedFunction("sum")
+		for i, expect := range []bool{false, true, true} {
+			if got := !fn.GetEnumAttributeAtIndex(i+1, noalias).IsNil(); got != expect {
+				t.Errorf("noalias of param %d of sum should be %v", i, expect)
+			}
+		}
+
+		// 10 + 10 + 2
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 22 {
+			t.Errorf("wrong answer, expect %d, ret %d", 22, int(ret.Int(true)))
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestSimple30(t *testing.T) {
+	var text = `
+struct dev { int count; };
+struct P { int x; int y; };
+struct N { volatile struct N *q; int x; };
+
+int poll(volatile struct dev *d)
+{
+	return d->count;
+}
+
+int origin_y()
+{
+	const struct P origin = {1, 2};
+	return origin.y;
+}
+
+int set(volatile struct P *a, int i)
+{
+	a[i].x = 3;
+	return a[i].x;
+}
+
+int chain(struct N *p)
+{
+	p->q->x = 4;
+	return p->q->x;
+}
+
+int main()
+{
+	struct dev d;
+	struct P ps[2];
+	struct N n1, n2;
+	d.count = 1;
+	n1.q = &n2;
+	n2.x = 0;
+	return poll(&d) + origin_y() + set(ps, 1) + chain(&n1) + ps[1].x + n2.x;
+}
+`
+	var ran = false
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		ran = true
+		var volatiles = func(name string) (n int) {
+			var fn = mod.NamedFunction(name)
+			for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+				for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+					if (!inst.IsALoadInst().IsNil() || !inst.IsAStoreInst().IsNil()) && inst.IsVolatile() {
+						n++
+					}
+				}
+			}
+			return
+		}
+
+		// members are qualified as the records they are accessed through
+		for name, expect := range map[string]int{"poll": 1, "origin_y": 0, "set": 2, "chain": 2, "main": 0} {
+			if got := volatiles(name); got != expect {
+				t.Errorf("%s should have %d volatile accesses, got %d", name, expect, got)
+			}
+		}
+
+		// 1 + 2 + 3 + 4 + 3 + 4
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 17 {
+			t.Errorf("wrong answer, expect %d, ret %d", 17, int(ret.Int(true)))
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+	if !ran {
+		t.Errorf("members of qualified records should compile")
+	}
+}
+
+func TestSimple31(t *testing.T) {
+	var text = `
+int f(int a, int b)
+{
+	if (a) {
+		if (b)
+			return 1;
+	}
+	return 0;
+}
+
+int main()
+{
+	return f(1, 1) * 100 + f(1, 0) * 10 + f(0, 1);
+}
+`
+	testTemplate(t, text, nil, 100, nil)
+}
+
+func TestSimple32(t *testing.T) {
+	var text = `
+int calls;
+
+int hit(int v)
+{
+	calls++;
+	return v;
+}
+
+int g(int a, int b)
+{
+	int x = 0;
+	if (a)
+		x = a && hit(b);
+	else
+		x = b ? hit(2) : 3;
+	return x;
+}
+
+int h(int a, int b)
+{
+	if (a || hit(b))
+		return a > b ? a : b;
+	else
+		return a || hit(0);
+}
+
+int main()
+{
+	int r = g(1, 1) + g(0, 0) * 10 + g(0, 1) * 100 + h(2, 1) * 1000 + h(0, 0) * 10000;
+	return r + calls * 100000;
+}
+`
+	// g: 1, 3, 2, h: 2, 0 with hit called 4 times
+	testTemplate(t, text, nil, 402231, nil)
+}
+
+func TestSimple33(t *testing.T) {
+	var text = `
+int main()
+{
+	unsigned int a = 0xFFFFFFFFu;
+	long b = 4294967295;
+	long c = 5000000000L;
+	unsigned long d = 18446744073709551615ul;
+	int ok = 0;
+	if (a / 2u == 2147483647u)
+		ok += 1;
+	if (b == 4294967295L)
+		ok += 2;
+	if (c / 1000000000 == 5)
+		ok += 4;
+	if (d > 0 && d + 1 == 0)
+		ok += 8;
+	if (0xFFFFFFFF > 0)
+		ok += 16;
+	if (-1 < 0x7FFFFFFF)
+		ok += 32;
+	if (sizeof(4294967295) == 8 && sizeof(0xFFFFFFFF) == 4 && sizeof(1ll) == 8)
+		ok += 64;
+	return ok;
+}
+`
+	testTemplate(t, text, nil, 127, nil)
+}
+
+func TestSimple34(t *testing.T) {
+	var text = `
+int loop(int n)
+{
+	int s = 0;
+	int i;
+	for (i = 0; i < n; i++) {
+		int a = i;
+		if (a == 2)
+			continue;
+		if (a == 5 && n < 8) {
+			int b = a * 2;
+			s += b;
+			break;
+		}
+		if (a == 7)
+			return -1;
+		s += a;
+	}
+	while (s > 0) {
+		int c = s;
+		s = c - 10;
+		if (c < 20)
+			break;
+	}
+	do {
+		int d = s;
+		s = d + 1;
+		continue;
+	} while (s < 0);
+	return s;
+}
+
+int main()
+{
+	return loop(4) + 1 + loop(6) * 10 + (loop(9) == -1) * 100;
+}
+`
+	var ran = false
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		ran = true
+
+		// lifetime of a local ends on every exit of its scope
+		var ends = make(map[string]int)
+		var fn = mod.NamedFunction("loop")
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if inst.IsACallInst().IsNil() || inst.CalledValue().Name() != "llvm.lifetime.end.p0i8" {
+					continue
+				}
+				var ptr = inst.Operand(1)
+				if !ptr.IsABitCastInst().IsNil() {
+					ptr = ptr.Operand(0)
+				}
+				ends[ptr.Name()]++
+			}
+		}
+		// a by continue, break, return and falling through, b by break, s
+		// and i by the returns, c by break and falling through, d by continue
+		var expects = map[string]int{"a": 4, "b": 1, "s": 2, "i": 2, "c": 2, "d": 1}
+		for name, n := range expects {
+			if ends[name] != n {
+				t.Errorf("lifetime of %s should end %d times, got %d", name, n, ends[name])
+			}
+		}
+
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 191 {
+			t.Errorf("wrong answer, expect %d, ret %d", 191, int(ret.Int(true)))
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+	if !ran {
+		t.Errorf("loop should compile")
+	}
+}
+
+func TestSimple35(t *testing.T) {
+	var text = `
+int f(void);
+int (*pf)(void) = f;
+
+int main(void)
+{
+	return pf() + 3;
+}
+
+int f(void)
+{
+	return 39;
+}
+`
+	var ran = false
+	testTemplate(t, text, nil, 0, func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		ran = true
+		for _, name := range []string{"main", "f"} {
+			if n := mod.NamedFunction(name).ParamsCount(); n != 0 {
+				t.Errorf("%s of (void) should have no params, got %d", name, n)
+			}
+		}
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 42 {
+			t.Errorf("wrong answer, expect %d, ret %d", 42, int(ret.Int(true)))
+		}
+	})
+	if !ran {
+		t.Errorf("functions of (void) should compile")
+	}
+}
+
+func TestSimple36(t *testing.T) {
+	var text = `
+int after(int x)
+{
+	return x;
+	x = 2;
+}
+
+int jumps(int n)
+{
+	int s = 0;
+	int i;
+	for (i = 0; i < n; i++) {
+		if (i == 1) {
+			continue;
+			s += 100;
+		}
+		if (i == 3) {
+			break;
+			s += 100;
+		}
+		s += i;
+	}
+	goto out;
+	s = -1;
+out:
+	return s;
+}
+
+int forever(int x)
+{
+	while (1) {
+		return x;
+	}
+}
+
+int forever2(int x)
+{
+	for (;;) {
+		return x;
+	}
+}
+
+int maybe(int x)
+{
+	while (x) {
+		return x;
+	}
+}
+
+int main(void)
+{
+	return after(1) + jumps(5) + forever(3) + forever2(4) + maybe(5);
+}
+`
+	Reports = nil
+	defer func() { Reports = nil }()
+
+	var ran = false
+	testTemplate(t, text, nil, 0, func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		ran = true
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 15 {
+			t.Errorf("wrong answer, expect %d, ret %d", 15, int(ret.Int(true)))
+		}
+	})
+	if !ran {
+		t.Errorf("statements after jumps should compile")
+	}
+
+	// only a loop of a non-constant condition may fall off the end
+	if len(Reports) != 1 || Reports[0].ID != "codegen.return-type" || Reports[0].AsString() != "maybe" {
+		t.Errorf("should warn on falling off the end of maybe only, got %v", Reports)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	var text = `
+int sq(int x)
+{
+	return x * x;
+}
+
+int main()
+{
+	int i;
+	int s = 0;
+	for (i = 0; i < 10; i++)
+		s += sq(i);
+	return s;
+}
+`
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var count = func(name string, pred func(llvm.Value) bool) (n int) {
+			var fn = mod.NamedFunction(name)
+			for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+				for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+					if pred(inst) {
+						n++
+					}
+				}
+			}
+			return
+		}
+		var isMemory = func(v llvm.Value) bool {
+			return !v.IsAAllocaInst().IsNil() || !v.IsALoadInst().IsNil() || !v.IsAStoreInst().IsNil()
+		}
+		var isCall = func(v llvm.Value) bool { return !v.IsACallInst().IsNil() }
+
+		// locals are promoted to registers, the loop is kept
+		Optimize(mod, 1, 0)
+		if n := count("main", isMemory); n != 0 {
+			t.Errorf("locals should be promoted at -O1, got %d memory accesses", n)
+		}
+		if n := count("main", isCall); n != 1 {
+			t.Errorf("sq should not be inlined at -O1, got %d calls", n)
+		}
+
+		// sq is inlined and the loop is folded
+		Optimize(mod, 2, 0)
+		var fn = mod.NamedFunction("main")
+		if fn.BasicBlocksCount() != 1 || count("main", isCall) != 0 {
+			t.Errorf("loop of main should be folded at -O2")
+		}
+		var ret = fn.EntryBasicBlock().LastInstruction()
+		if ret.IsAReturnInst().IsNil() || ret.Operand(0).IsAConstantInt().IsNil() ||
+			ret.Operand(0).SExtValue() != 285 {
+			t.Errorf("main should return constant 285")
+		}
+
+		if v := engine.RunFunction(fn, nil); v.Int(true) != 285 {
+			t.Errorf("wrong answer, expect %d, ret %d", 285, int(v.Int(true)))
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestTargetILP32(t *testing.T) {
+	var text = `
+long g;
+unsigned u;
+
+int main()
+{
+	long a[4];
+	long *p = &a[3];
+	long long big = 1;
+	return (p - a) + (u < (long)-1) + (big << 40 != 0);
+}
+`
+	ast.Target = ast.TargetFor("i686-pc-linux-gnu", 0)
+	defer func() { ast.Target = ast.TargetFor("x86_64-pc-linux-gnu", 0) }()
+
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		if w := mod.NamedGlobal("g").Type().ElementType().IntTypeWidth(); w != 32 {
+			t.Errorf("long should be 32 bits on ILP32, got %d", w)
+		}
+
+		var fn = mod.NamedFunction("main")
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if !inst.IsAPtrToIntInst().IsNil() && inst.Type().IntTypeWidth() != 32 {
+					t.Errorf("pointer difference should be 32 bits on ILP32")
+				}
+			}
+		}
+
+		// (long)-1 is converted to unsigned long as wide as unsigned int
+		ret := engine.RunFunction(fn, nil)
+		if ret.Int(true) != 5 {
+			t.Errorf("wrong answer, expect %d, ret %d", 5, int(ret.Int(true)))
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
+func TestVaArgTypes(t *testing.T) {
+	var text = `
+enum color { RED, GREEN, BLUE };
+
+int pick(int n, ...)
+{
+	__builtin_va_list ap;
+	__builtin_va_start(ap, n);
+	enum color c = __builtin_va_arg(ap, enum color);
+	int k = __builtin_va_arg(ap, const int);
+	char *s = __builtin_va_arg(ap, char *const);
+	int ci = c;
+	__builtin_va_end(ap);
+	return ci * 100 + k * 10 + s[1];
+}
+
+int main()
+{
+	return pick(3, BLUE, 4, "ab") - 'b';
+}
+`
+	var ran = false
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		ran = true
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 240 {
+			t.Errorf("wrong answer, expect %d, ret %d", 240, int(ret.Int(true)))
+		}
+	}
+
+	newEngine = newMCJIT
+	defer func() { newEngine = llvm.NewExecutionEngine }()
+	testTemplate(t, text, nil, 0, run)
+	if !ran {
+		t.Errorf("va_arg of enum and qualified types should compile")
+	}
+}
+
+func TestVaArgTarget(t *testing.T) {
+	var text = `
+int sum(int n, ...)
+{
+	__builtin_va_list ap;
+	int s = 0;
+	__builtin_va_start(ap, n);
+	while (n-- > 0)
+		s += __builtin_va_arg(ap, int);
+	__builtin_va_end(ap);
+	return s;
+}
+
+int main()
+{
+	return sum(2, 1, 2);
+}
+`
+	ast.Target = ast.TargetFor("aarch64-unknown-linux-gnu", 0)
+	defer func() { ast.Target = ast.TargetFor("x86_64-pc-linux-gnu", 0) }()
+
+	var ran = false
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		ran = true
+		var n = 0
+		var fn = mod.NamedFunction("sum")
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if !inst.IsAVAArgInst().IsNil() {
+					n++
+				}
+			}
+		}
+		if n != 1 {
+			t.Errorf("va_arg should be left to llvm on aarch64, got %d va_arg instructions", n)
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+	if !ran {
+		t.Errorf("va_arg should compile for aarch64")
+	}
+}
+
+func TestVaArgUnsupported(t *testing.T) {
+	var text = `
+struct pair { int a; int b; };
+
+int first(int n, ...)
+{
+	__builtin_va_list ap;
+	__builtin_va_start(ap, n);
+	struct pair p = __builtin_va_arg(ap, struct pair);
+	__builtin_va_end(ap);
+	return p.a;
+}
+
+int main()
+{
+	return 0;
+}
+`
+	Reports = nil
+	defer func() { Reports = nil }()
+
+	testTemplate(t, text, nil, 0, func(llvm.Module, llvm.ExecutionEngine) {})
+	if len(Reports) != 1 || Reports[0].ID != "codegen.unsupported-va-arg" {
+		t.Errorf("va_arg of struct should be reported, got %v", Reports)
+	}
+}
+
+func TestNormalizeTriple(t *testing.T) {
+	var cases = [][2]string{
+		{"x86_64-pc-linux-gnu", "x86_64-pc-linux-gnu"},
+		{"x86_64-linux-gnux32", "x86_64-unknown-linux-gnux32"},
+		{"x86_64-pc-windows-msvc", "x86_64-pc-windows-msvc"},
+		{"aarch64-linux-gnu", "aarch64-unknown-linux-gnu"},
+	}
+	for _, c := range cases {
+		if n := NormalizeTriple(c[0]); n != c[1] {
+			t.Errorf("%s should be normalized to %s, got %s", c[0], c[1], n)
+		}
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	var text = `
+enum color { RED, GREEN = 5, BLUE };
+
+struct node {
+	int v;
+	struct node *next;
+};
+
+int counter = 3;
+
+int sum(int *a, int n)
+{
+	int s = 0;
+	int i;
+	for (i = 0; i < n; i++) {
+		int x = a[i];
+		s += x;
+	}
+	return s;
+}
+
+int main()
+{
+	int arr[2];
+	struct node n;
+	enum color c = BLUE;
+	arr[0] = counter;
+	arr[1] = 4;
+	n.v = sum(arr, 2);
+	int k = c;
+	n.next = &n;
+	return n.v + k;
+}
+`
+	DebugInfo = true
+	defer func() { DebugInfo = false }()
+
+	var run = func(mod llvm.Module, engine llvm.ExecutionEngine) {
+		var ir = mod.String()
+		for _, want := range []string{
+			`DICompileUnit(language: DW_LANG_C99`,
+			`DISubprogram(name: "sum"`,
+			`DILocalVariable(name: "a", arg: 1`,
+			`DILocalVariable(name: "x", scope: `,
+			`DILexicalBlock(`,
+			`DIGlobalVariable(name: "counter"`,
+			`DW_TAG_structure_type, name: "node"`,
+			`DW_TAG_member, name: "next"`,
+			`DW_TAG_array_type`,
+			`DW_TAG_pointer_type`,
+			`DW_TAG_enumeration_type, name: "color"`,
+			`DIEnumerator(name: "GREEN", value: 5)`,
+		} {
+			if !strings.Contains(ir, want) {
+				t.Errorf("debug info should contain %s", want)
+			}
+		}
+
+		// every instruction of sum is located, calls of lifetime markers included
+		var fn = mod.NamedFunction("sum")
+		if fn.Subprogram().IsNil() {
+			t.Errorf("sum should have a subprogram")
+		}
+		var declares = 0
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if !inst.IsACallInst().IsNil() && inst.CalledValue().Name() == "llvm.dbg.declare" {
+					declares++
+				}
+				if !inst.IsACallInst().IsNil() && inst.InstructionDebugLoc().IsNil() {
+					t.Errorf("call in sum should have a location")
+				}
+			}
+		}
+		// a, n, s, i, x
+		if declares != 5 {
+			t.Errorf("expect 5 variables declared in sum, got %d", declares)
+		}
+
+		ret := engine.RunFunction(mod.NamedFunction("main"), nil)
+		if ret.Int(true) != 13 {
+			t.Errorf("wrong answer, expect %d, ret %d", 13, int(ret.Int(true)))
+		}
+	}
+	testTemplate(t, text, nil, 0, run)
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	os.Exit(m.Run())
@@ -0,0 +1,11 @@
+//go:build !byollvm && darwin && llvm12
+// +build !byollvm,darwin,llvm12
+
+package codegen
+
+// the llvm-c headers of dibuilder.go are found the same way as go-llvm does,
+// which links the library for us. with byollvm, flags are given by CGO_*
+
+// #cgo amd64 CPPFLAGS: -I/usr/local/opt/llvm@12/include   -D__STDC_CONSTANT_MACROS -D__STDC_FORMAT_MACROS -D__STDC_LIMIT_MACROS
+// #cgo arm64 CPPFLAGS: -I/opt/homebrew/opt/llvm@12/include   -D__STDC_CONSTANT_MACROS -D__STDC_FORMAT_MACROS -D__STDC_LIMIT_MACROS
+import "C"
@@ -0,0 +1,10 @@
+//go:build !byollvm && linux && !llvm11 && !llvm12
+// +build !byollvm,linux,!llvm11,!llvm12
+
+package codegen
+
+// the llvm-c headers of dibuilder.go are found the same way as go-llvm does,
+// which links the library for us. with byollvm, flags are given by CGO_*
+
+// #cgo CPPFLAGS: -I/usr/lib/llvm-13/include -D_GNU_SOURCE -D__STDC_CONSTANT_MACROS -D__STDC_FORMAT_MACROS -D__STDC_LIMIT_MACROS
+import "C"
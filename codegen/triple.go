@@ -0,0 +1,24 @@
+package codegen
+
+// normalization of target triples is missing from the binding of go-llvm
+
+/*
+#include <stdlib.h>
+#include <llvm-c/Core.h>
+#include <llvm-c/TargetMachine.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// the canonical form of triple, e.g. x86_64-unknown-linux-gnux32 of
+// x86_64-linux-gnux32, from which llvm derives the data layout
+func NormalizeTriple(triple string) string {
+	var ctriple = C.CString(triple)
+	defer C.free(unsafe.Pointer(ctriple))
+	var normalized = C.LLVMNormalizeTargetTriple(ctriple)
+	defer C.LLVMDisposeMessage(normalized)
+	return C.GoString(normalized)
+}
@@ -0,0 +1,290 @@
+package main
+
+// reports are rendered as text by default, or serialized into json or sarif
+// for tools, all at once before sc exits. lines and columns are counted
+// from 1, and ranges end right after their last byte
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/yanhao/sc/ast"
+	"github.com/yanhao/sc/lexer"
+)
+
+var diagnosticsFormat string = "text"
+
+// -W<opt> is split into -W and opt like -lfoo, options are applied as they
+// are given
+type warningFlag struct{}
+
+func (warningFlag) String() string { return "" }
+func (warningFlag) Set(opt string) error {
+	if err := ast.SetWarningOption(opt); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+	return nil
+}
+
+func setupDiagnosticFlags() {
+	flag.StringVar(&diagnosticsFormat, "fdiagnostics-format", diagnosticsFormat, "print diagnostics in `format`, one of text, json and sarif")
+	flag.Var(warningFlag{}, "W", "warning `option`: all, extra, error, <group>, no-<group>, error=<group> or no-error=<group>")
+}
+
+// check the format, after which reports of other formats than text are
+// collected
+func setupDiagnostics() error {
+	switch diagnosticsFormat {
+	case "text":
+	case "json", "sarif":
+		ast.CollectReports = true
+	default:
+		return fmt.Errorf("invalid format %s for -fdiagnostics-format, expect text, json or sarif", diagnosticsFormat)
+	}
+	return nil
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p jsonPosition) before(q jsonPosition) bool {
+	return p.Line < q.Line || p.Line == q.Line && p.Column < q.Column
+}
+
+type jsonRange struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonFixIt struct {
+	Range jsonRange `json:"range"`
+	Text  string    `json:"text"`
+}
+
+type jsonReport struct {
+	ID      string        `json:"id,omitempty"`
+	Kind    string        `json:"kind"`
+	File    string        `json:"file"`
+	Range   *jsonRange    `json:"range,omitempty"`
+	Message string        `json:"message"`
+	Notes   []*jsonReport `json:"notes,omitempty"`
+	FixIts  []jsonFixIt   `json:"fixits,omitempty"`
+}
+
+func makeRange(loc lexer.Location, width int) jsonRange {
+	return jsonRange{
+		Start: jsonPosition{loc.Line, loc.Column + 1},
+		End:   jsonPosition{loc.Line, loc.Column + width + 1},
+	}
+}
+
+// reports of tokens made up by the compiler have no range, the others span
+// the token together with the expression reported if any
+func reportRange(r *ast.Report) *jsonRange {
+	if r.Line == 0 {
+		return nil
+	}
+	var rg = makeRange(r.Location, ast.TokenWidth(r.Token))
+	if r.End.Line > 0 {
+		if begin := (jsonPosition{r.Begin.Line, r.Begin.Column + 1}); begin.before(rg.Start) {
+			rg.Start = begin
+		}
+		if end := (jsonPosition{r.End.Line, r.End.Column + 1}); rg.End.before(end) {
+			rg.End = end
+		}
+	}
+	return &rg
+}
+
+func toJSONReport(r *ast.Report) *jsonReport {
+	var ret = &jsonReport{
+		ID:      r.ID,
+		Kind:    r.Kind.String(),
+		File:    ast.DisplayName(r.File),
+		Range:   reportRange(r),
+		Message: r.Desc,
+	}
+	for _, n := range r.Notes {
+		ret.Notes = append(ret.Notes, toJSONReport(n))
+	}
+	for _, fix := range r.FixIts {
+		ret.FixIts = append(ret.FixIts, jsonFixIt{makeRange(fix.Location, fix.Len), fix.Text})
+	}
+	return ret
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifFix struct {
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId,omitempty"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// columns of reports are in bytes, which are counted in code points by
+// regions of file
+func toRegion(file string, rg *jsonRange) *sarifRegion {
+	if rg == nil {
+		return nil
+	}
+	var column = func(p jsonPosition) int {
+		return ast.CodePointColumn(file, p.Line, p.Column-1) + 1
+	}
+	return &sarifRegion{rg.Start.Line, column(rg.Start), rg.End.Line, column(rg.End)}
+}
+
+func sarifURI(file string) sarifArtifactLocation {
+	return sarifArtifactLocation{filepath.ToSlash(ast.DisplayName(file))}
+}
+
+func toSarifLocation(r *ast.Report) sarifLocation {
+	return sarifLocation{PhysicalLocation: sarifPhysicalLocation{sarifURI(r.File), toRegion(r.File, reportRange(r))}}
+}
+
+// sarif has no level of info, which is taken as a note
+func sarifLevel(kd ast.ReportKind) string {
+	switch kd {
+	case ast.Error:
+		return "error"
+	case ast.Warning:
+		return "warning"
+	}
+	return "note"
+}
+
+func toSarif(reports []*ast.Report) *sarifLog {
+	var run sarifRun
+	run.Tool.Driver.Name = "sc"
+	run.ColumnKind = "unicodeCodePoints"
+	run.Results = []sarifResult{}
+
+	var rules = make(map[string]bool)
+	for _, r := range reports {
+		var res = sarifResult{
+			RuleID:    r.ID,
+			Level:     sarifLevel(r.Kind),
+			Message:   sarifMessage{r.Desc},
+			Locations: []sarifLocation{toSarifLocation(r)},
+		}
+		for _, n := range r.Notes {
+			var loc = toSarifLocation(n)
+			loc.Message = &sarifMessage{n.Desc}
+			res.RelatedLocations = append(res.RelatedLocations, loc)
+		}
+		for _, fix := range r.FixIts {
+			var rg = makeRange(fix.Location, fix.Len)
+			res.Fixes = append(res.Fixes, sarifFix{[]sarifArtifactChange{{
+				ArtifactLocation: sarifURI(r.File),
+				Replacements:     []sarifReplacement{{*toRegion(r.File, &rg), sarifMessage{fix.Text}}},
+			}}})
+		}
+		run.Results = append(run.Results, res)
+		if r.ID != "" {
+			rules[r.ID] = true
+		}
+	}
+
+	run.Tool.Driver.Rules = []sarifRule{}
+	for id := range rules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{id})
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool {
+		return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID
+	})
+
+	return &sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
+
+// print reports collected to stderr, which is done once before exit
+func flushDiagnostics() {
+	if !ast.CollectReports {
+		return
+	}
+
+	var doc interface{}
+	if diagnosticsFormat == "sarif" {
+		doc = toSarif(ast.Collected)
+	} else {
+		var reports = []*jsonReport{}
+		for _, r := range ast.Collected {
+			reports = append(reports, toJSONReport(r))
+		}
+		doc = reports
+	}
+	ast.Collected = nil
+
+	var enc = json.NewEncoder(os.Stderr)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yanhao/sc/ast"
+	"github.com/yanhao/sc/lexer"
+)
+
+func TestReportRange(t *testing.T) {
+	var at = func(line, col int) lexer.Location {
+		return lexer.Location{Line: line, Column: col}
+	}
+	var cases = []struct {
+		tok        lexer.Location
+		begin, end lexer.Location
+		expect     jsonRange
+	}{
+		// the token only
+		{at(2, 4), lexer.Location{}, lexer.Location{}, jsonRange{jsonPosition{2, 5}, jsonPosition{2, 6}}},
+		// the token within its expression
+		{at(2, 4), at(2, 0), at(2, 9), jsonRange{jsonPosition{2, 1}, jsonPosition{2, 10}}},
+		// the expression before the token
+		{at(2, 4), at(2, 0), at(2, 3), jsonRange{jsonPosition{2, 1}, jsonPosition{2, 6}}},
+		// over lines
+		{at(2, 4), at(1, 7), at(3, 2), jsonRange{jsonPosition{1, 8}, jsonPosition{3, 3}}},
+	}
+
+	for i, c := range cases {
+		var tok = lexer.MakeToken(lexer.IDENTIFIER, "x")
+		tok.Location = c.tok
+		var r = ast.MakeReport(ast.Error, tok, "")
+		r.Begin, r.End = c.begin, c.end
+		if rg := reportRange(r); rg == nil || *rg != c.expect {
+			t.Errorf("report #%d should span %v, got %v", i, c.expect, rg)
+		}
+	}
+}
+
+func TestSarifColumns(t *testing.T) {
+	// é takes 2 bytes, 中 takes 3
+	var text = "char *s = \"h\u00e9\u4e2d\"; int y = zz;\n"
+	ast.AddSource("u.c", []byte(text))
+
+	var tok = lexer.MakeToken(lexer.IDENTIFIER, "zz")
+	tok.Location = lexer.Location{Line: 1, Column: 28}
+	var r = ast.MakeReport(ast.Error, tok, "use of undeclared identifier 'zz'")
+	r.File = "u.c"
+	r.AddFixIt(tok, "y", false)
+
+	var run = toSarif([]*ast.Report{r}).Runs[0]
+	if run.ColumnKind != "unicodeCodePoints" {
+		t.Errorf("columns should be of code points, got %s", run.ColumnKind)
+	}
+	var expect = sarifRegion{1, 26, 1, 28}
+	if rg := run.Results[0].Locations[0].PhysicalLocation.Region; rg == nil || *rg != expect {
+		t.Errorf("region of zz should be %v, got %v", expect, rg)
+	}
+	if rg := run.Results[0].Fixes[0].ArtifactChanges[0].Replacements[0].DeletedRegion; rg != expect {
+		t.Errorf("region of the fix-it should be %v, got %v", expect, rg)
+	}
+
+	// json keeps columns in bytes
+	if rg := reportRange(r); rg.Start.Column != 29 {
+		t.Errorf("json column of zz should be 29, got %d", rg.Start.Column)
+	}
+}
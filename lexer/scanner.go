@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 )
 
 type Kind int
@@ -93,8 +94,9 @@ func (self Value) AsString() string {
 	return self.content
 }
 
+// integer suffix decides the type, it is not part of the value either
 func (self Value) AsInt() int {
-	if i, err := strconv.ParseInt(self.content, 0, 32); err != nil {
+	if i, err := strconv.ParseInt(strings.TrimRight(self.content, "uUlL"), 0, 64); err != nil {
 		panic("Can not convert to int")
 	} else {
 		return int(i)
@@ -102,6 +104,38 @@ func (self Value) AsInt() int {
 
 }
 
+// value of an integer literal, false if it takes more than 64 bits
+func (self Value) AsUint64() (uint64, bool) {
+	var u, err = strconv.ParseUint(strings.TrimRight(self.content, "uUlL"), 0, 64)
+	return u, err == nil
+}
+
+// the suffix of an integer literal, as if it is unsigned and the number of
+// l of it
+func (self Value) IntSuffix() (unsigned bool, longs int) {
+	var suffix = self.content[len(strings.TrimRight(self.content, "uUlL")):]
+	return strings.ContainsAny(suffix, "uU"), strings.Count(strings.ToLower(suffix), "l")
+}
+
+// u, l and ll in any order and case, but the two l of ll are of one case
+func isIntSuffix(s string) bool {
+	switch strings.ToLower(s) {
+	case "", "u", "l", "ul", "lu", "ll", "ull", "llu":
+		return !strings.Contains(s, "lL") && !strings.Contains(s, "Ll")
+	}
+	return false
+}
+
+// float suffix decides the type, it is not part of the value
+func (self Value) AsFloat() float64 {
+	var s = strings.TrimRight(self.content, "fFlL")
+	if f, err := strconv.ParseFloat(s, 64); err != nil {
+		panic("Can not convert to float")
+	} else {
+		return f
+	}
+}
+
 func (self Value) AsChar() byte {
 	if len(self.content) != 1 {
 		panic("Can not convert to char")
@@ -126,7 +160,15 @@ func (self Token) String() string {
 		TokKinds[self.Kind], self.Location, self.Value.AsString())
 }
 
+// #pragma directives are kept by the scanner instead of being tokens, with
+// their text after pragma, e.g. sc diagnostic push
+type Pragma struct {
+	Location
+	Text string
+}
+
 type Scanner struct {
+	Pragmas     []Pragma
 	start       int64  // start of next token
 	offset      int64  // total offset in source file
 	lines       int    // current line
@@ -167,9 +209,9 @@ func (self *Scanner) acceptOne(group []byte) bool {
 }
 
 func (self *Scanner) accept(group []byte) {
-	for bytes.IndexByte(group, self.next()) >= 0 {
+	for bytes.IndexByte(group, self.peek()) >= 0 {
+		self.next()
 	}
-	self.backup() // retreat unmatched byte
 }
 
 // skip until a seq point found
@@ -195,7 +237,7 @@ func (self *Scanner) next() byte {
 	self.cols++
 	if c == '\n' {
 		self.lines++
-		self.precols = self.cols
+		self.precols = self.cols - 1
 		self.cols = 0
 	}
 	self.val = append(self.val, c)
@@ -230,9 +272,13 @@ func (self *Scanner) backup() {
 }
 
 func (self *Scanner) emit(kd Kind) {
+	var col = self.cols - len(self.val)
+	if kd == STR_LITERAL || kd == CHAR_LITERAL {
+		col -= 2 // quotes are not part of the value
+	}
 	tok := Token{
 		Kind:     kd,
-		Location: Location{Offset: self.start, Line: self.lines, Column: self.cols - len(self.val)},
+		Location: Location{Offset: self.start, Line: self.lines, Column: col},
 		Value:    Value{string(self.val)},
 	}
 	self.start = 0
@@ -285,6 +331,8 @@ func start(self *Scanner) StateFn {
 		return stateCharConstant
 	case '"':
 		return stateStrConstant
+	case '#':
+		return stateDirective
 
 	case '/':
 		switch self.peek() {
@@ -295,8 +343,17 @@ func start(self *Scanner) StateFn {
 			self.next()
 			return stateBlockComment
 		default:
-			self.backup()
-			return statePunctuator
+			// NOTE: can not backup here, UnreadByte fails right after a Peek,
+			// so '/' and '/=' are emitted directly
+			self.start = self.offset - 1
+			self.val = append(self.val[:0], c)
+			if self.peek() == '=' {
+				self.next()
+				self.emit(DIV_ASSIGN)
+			} else {
+				self.emit(DIV)
+			}
+			return start
 		}
 
 	case eof:
@@ -319,7 +376,6 @@ func stateError(self *Scanner) StateFn {
 }
 
 //FIXME: detect more wrong constants
-//FIXME: support integer-suffix (see N1256)
 func stateIntConstant(self *Scanner) StateFn {
 	self.start = self.offset
 	self.val = self.val[:0]
@@ -338,11 +394,24 @@ func stateIntConstant(self *Scanner) StateFn {
 		}
 	}
 
+	// fraction and exponent of a float are decimal unless it is hexadecimal
+	var digits = []byte("0123456789")
+	if inside(group, 'A') {
+		digits = group
+	}
+
 	//log.Printf("stateIntConstant group %s", group)
-	self.accept(group)
+	if inside(group, 'A') {
+		self.accept(group)
+	} else {
+		// 09.5 is a valid float, bad octals are reported by conversion
+		self.accept(digits)
+	}
 
+	is_float := false
 	if self.acceptOne([]byte(".")) {
-		self.accept(group)
+		is_float = true
+		self.accept(digits)
 	}
 
 	has_exp := false
@@ -352,7 +421,19 @@ func stateIntConstant(self *Scanner) StateFn {
 	}
 	if self.acceptOne([]byte(exp)) {
 		has_exp = true
-		self.accept(group)
+		is_float = true
+		self.acceptOne([]byte("+-"))
+		self.accept([]byte("0123456789"))
+	}
+
+	var suffix = len(self.val)
+	if is_float {
+		self.acceptOne([]byte("fFlL"))
+	} else {
+		self.accept([]byte("uUlL"))
+		if !isIntSuffix(string(self.val[suffix:])) {
+			return stateError
+		}
 	}
 
 	if self.peek() == '.' ||
@@ -363,7 +444,11 @@ func stateIntConstant(self *Scanner) StateFn {
 		return stateError
 	}
 
-	self.emit(INT_LITERAL)
+	if is_float {
+		self.emit(FLOAT_LITERAL)
+	} else {
+		self.emit(INT_LITERAL)
+	}
 	return start
 }
 
@@ -401,6 +486,25 @@ func stateStrConstant(self *Scanner) StateFn {
 }
 
 //FIXME: handle line escape  with '\' at the end of line
+// a directive takes the rest of its line, only #pragma is supported
+func stateDirective(self *Scanner) StateFn {
+	var loc = Location{Offset: self.offset - 1, Line: self.lines, Column: self.cols - 1}
+	self.start = self.offset - 1
+	self.val = append(self.val[:0], '#')
+	for c := self.peek(); c != '\n' && c != eof; c = self.peek() {
+		self.next()
+	}
+
+	var text = strings.TrimSpace(string(self.val[1:]))
+	if fields := strings.Fields(text); len(fields) > 0 && fields[0] == "pragma" {
+		self.Pragmas = append(self.Pragmas, Pragma{loc, strings.TrimSpace(text[len("pragma"):])})
+		self.val = self.val[:0]
+		return start
+	}
+	self.emit(ERROR)
+	return start
+}
+
 func stateLineComment(self *Scanner) StateFn {
 	self.start = self.offset - 2
 	self.val = self.val[:0]
@@ -655,7 +759,11 @@ func (self *Scanner) Peek() Token {
 func (self *Scanner) Next() Token {
 	for {
 		select {
-		case tok := <-self.tokens:
+		case tok, ok := <-self.tokens:
+			if !ok {
+				// the scanner stopped at the end or at an error it gives up
+				return Token{Kind: EOT, Location: Location{Offset: self.offset, Line: self.lines, Column: self.cols}}
+			}
 			return tok
 		default:
 			// nothing, just polling
@@ -669,6 +777,9 @@ func init() {
 		"else", "enum", "extern", "float", "for", "goto", "if", "inline", "int", "long",
 		"register", "restrict", "return", "short", "signed", "sizeof", "static", "struct",
 		"switch", "typedef", "union", "unsigned", "void", "volatile", "while",
+		// builtins
+		"__builtin_va_list", "__builtin_va_start", "__builtin_va_arg", "__builtin_va_end",
+		"__builtin_va_copy",
 	}
 
 	keywords = make(map[string]bool)
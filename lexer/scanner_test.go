@@ -41,6 +41,63 @@ func TestIntConstant(t *testing.T) {
 	}
 }
 
+func TestIntSuffix(t *testing.T) {
+	src := []byte(`42u 0xFFFFFFFFu 7L 8ll 9uLL 10LLu 11lu 18446744073709551615ull 5lL`)
+	s := NewScanner(bytes.NewReader(src))
+
+	expect := []struct {
+		value    uint64
+		unsigned bool
+		longs    int
+	}{
+		{42, true, 0}, {0xFFFFFFFF, true, 0}, {7, false, 1}, {8, false, 2}, {9, true, 2},
+		{10, true, 2}, {11, true, 1}, {18446744073709551615, true, 2},
+	}
+	var i = 0
+	for tok := s.Next(); tok.Kind != EOT; tok = s.Next() {
+		if i == len(expect) {
+			// l of ll are in one case
+			if tok.Kind != ERROR {
+				t.Fatalf("unexpected token %v", tok)
+			}
+			break
+		}
+		var v, ok = tok.AsUint64()
+		var unsigned, longs = tok.IntSuffix()
+		var e = expect[i]
+		if tok.Kind != INT_LITERAL || !ok || v != e.value || unsigned != e.unsigned || longs != e.longs {
+			t.Fatalf("unexpected token %v at %d", tok, i)
+		}
+		i++
+	}
+	if i != len(expect) {
+		t.Fatalf("expect %d integers, got %d", len(expect), i)
+	}
+}
+
+func TestFloatConstant(t *testing.T) {
+	src := []byte(`2.0 0.5f 1e3 2.5e-1L 0x1.8p1 09.5 42`)
+	s := NewScanner(bytes.NewReader(src))
+
+	expect := []float64{2.0, 0.5, 1000, 0.25, 3, 9.5}
+	var i = 0
+	for tok := s.Next(); tok.Kind != EOT; tok = s.Next() {
+		if i == len(expect) {
+			if tok.Kind != INT_LITERAL {
+				t.Fatalf("unexpected token %v", tok)
+			}
+			break
+		}
+		if tok.Kind != FLOAT_LITERAL || tok.AsFloat() != expect[i] {
+			t.Fatalf("unexpected token %v at %d", tok, i)
+		}
+		i++
+	}
+	if i != len(expect) {
+		t.Fatalf("expect %d floats, got %d", len(expect), i)
+	}
+}
+
 func TestDeclarations(t *testing.T) {
 	src := []byte(`
 int i = 0xdeedbeef;
@@ -84,3 +141,41 @@ int main() {
 		fmt.Printf("\033[38;5;199mtok: %v\033[00m \n", tok)
 	}
 }
+
+func TestDivision(t *testing.T) {
+	src := []byte(`a / b; c /= 2; d/e;`)
+	s := NewScanner(bytes.NewReader(src))
+
+	expect := []Kind{IDENTIFIER, DIV, IDENTIFIER, SEMICOLON,
+		IDENTIFIER, DIV_ASSIGN, INT_LITERAL, SEMICOLON,
+		IDENTIFIER, DIV, IDENTIFIER, SEMICOLON}
+
+	var i = 0
+	for tok := s.Next(); tok.Kind != EOT; tok = s.Next() {
+		if i >= len(expect) || tok.Kind != expect[i] {
+			t.Fatalf("unexpected token %v at %d", tok, i)
+		}
+		i++
+	}
+	if i != len(expect) {
+		t.Fatalf("expect %d tokens, got %d", len(expect), i)
+	}
+}
+
+func TestStopAtError(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(`return 'ab';`)))
+
+	var kinds []Kind
+	for tok := s.Next(); tok.Kind != EOT; tok = s.Next() {
+		kinds = append(kinds, tok.Kind)
+		if len(kinds) > 3 {
+			t.Fatalf("scanning should end after an error, got %v", kinds)
+		}
+	}
+	if len(kinds) != 2 || kinds[1] != ERROR {
+		t.Fatalf("expect return and an error, got %v", kinds)
+	}
+	if tok := s.Next(); tok.Kind != EOT {
+		t.Fatalf("EOT should be repeated, got %v", tok)
+	}
+}
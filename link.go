@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yanhao/sc/ast"
+)
+
+// objects are linked into an executable by a C compiler driver, which knows
+// where crt files and libc are, or by ld directly with those found here
+
+var (
+	ldPath     string   = "cc"
+	libDirs    listFlag = nil
+	libs       listFlag = nil
+	linkStatic bool     = false
+
+	// under which system libraries are searched, which is only moved by tests
+	sysRoot string = "/"
+)
+
+// a flag can be given many times, e.g. -L and -l
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func setupLinkFlags() {
+	flag.StringVar(&ldPath, "ld-path", ldPath, "link by `linker`, either a C compiler driver or ld")
+	flag.Var(&libDirs, "L", "add `dir` to library search path")
+	flag.Var(&libs, "l", "link with `library`")
+	flag.BoolVar(&linkStatic, "static", linkStatic, "link statically")
+}
+
+// split joined -lfoo, -Ldir and -Wall into two args, which the flag package
+// can not parse, unlike flags like -link-modules
+func splitJoinedArgs(args []string) []string {
+	var ret []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(ret, args[i:]...)
+		}
+		var name = strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if flag.Lookup(name) != nil {
+			ret = append(ret, arg)
+		} else if len(arg) > 2 && (strings.HasPrefix(arg, "-l") || strings.HasPrefix(arg, "-L") || strings.HasPrefix(arg, "-W")) {
+			ret = append(ret, arg[:2], arg[2:])
+		} else {
+			ret = append(ret, arg)
+		}
+	}
+	return ret
+}
+
+// inputs linked as they are
+func isLinkerInput(name string) bool {
+	switch filepath.Ext(name) {
+	case ".o", ".a", ".so":
+		return true
+	default:
+		return false
+	}
+}
+
+func isLd(linker string) bool {
+	var base = filepath.Base(linker)
+	return base == "ld" || strings.HasPrefix(base, "ld.")
+}
+
+// link objs, which are objects and archives, into executable output
+func link(objs []string, output string) error {
+	var args []string
+	if isLd(ldPath) {
+		var err error
+		if args, err = ldArgs(objs, output); err != nil {
+			return err
+		}
+	} else {
+		args = append([]string{"-o", output}, objs...)
+		for _, dir := range libDirs {
+			args = append(args, "-L"+dir)
+		}
+		for _, lib := range libs {
+			args = append(args, "-l"+lib)
+		}
+		if linkStatic {
+			args = append(args, "-static")
+		}
+	}
+
+	if beVerbose {
+		fmt.Fprintf(os.Stderr, "%s %s\n", ldPath, strings.Join(args, " "))
+	}
+	var cmd = exec.Command(ldPath, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("linker command failed: %s", err)
+	}
+	return nil
+}
+
+// multiarch name of target, under which system libraries are installed
+func multiarch() string {
+	var arch = strings.SplitN(ast.Target.Triple, "-", 2)[0]
+	switch arch {
+	case "i386", "i486", "i586", "i686":
+		arch = "i386"
+	}
+	return arch + "-linux-gnu"
+}
+
+// directories searched for crt files and system libraries, the newest gcc
+// installation comes first for crtbegin.o and libgcc
+func systemLibDirs() []string {
+	var dirs []string
+	var gccs, _ = filepath.Glob(filepath.Join(sysRoot, "usr/lib/gcc", multiarch(), "*"))
+	sort.Slice(gccs, func(i, j int) bool {
+		var vi, _ = strconv.Atoi(strings.SplitN(filepath.Base(gccs[i]), ".", 2)[0])
+		var vj, _ = strconv.Atoi(strings.SplitN(filepath.Base(gccs[j]), ".", 2)[0])
+		return vi > vj
+	})
+	if len(gccs) > 0 {
+		dirs = append(dirs, gccs[0])
+	}
+
+	for _, dir := range []string{"usr/lib/" + multiarch(), "lib/" + multiarch(), "usr/lib64", "lib64", "usr/lib", "lib"} {
+		dirs = append(dirs, filepath.Join(sysRoot, dir))
+	}
+	return dirs
+}
+
+func findLibFile(name string) (string, error) {
+	for _, dir := range systemLibDirs() {
+		var p = filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find %s for linking", name)
+}
+
+// arguments of ld, which needs crt files, libc and the dynamic linker
+// spelled out
+func ldArgs(objs []string, output string) ([]string, error) {
+	var dynamicLinkers = map[string]string{
+		"x86_64":  "/lib64/ld-linux-x86-64.so.2",
+		"i386":    "/lib/ld-linux.so.2",
+		"aarch64": "/lib/ld-linux-aarch64.so.1",
+		"riscv64": "/lib/ld-linux-riscv64-lp64d.so.1",
+	}
+
+	var args = []string{"-o", output}
+	var crtbegin = "crtbegin.o"
+	if linkStatic {
+		args = append(args, "-static")
+		crtbegin = "crtbeginT.o"
+	} else {
+		var arch = strings.SplitN(multiarch(), "-", 2)[0]
+		var ldso, ok = dynamicLinkers[arch]
+		if !ok {
+			return nil, fmt.Errorf("no dynamic linker known for target %s", ast.Target.Triple)
+		}
+		args = append(args, "-dynamic-linker", ldso)
+	}
+
+	var crt = func(names ...string) error {
+		for _, name := range names {
+			var p, err = findLibFile(name)
+			if err != nil {
+				return err
+			}
+			args = append(args, p)
+		}
+		return nil
+	}
+
+	if err := crt("crt1.o", "crti.o", crtbegin); err != nil {
+		return nil, err
+	}
+	args = append(args, objs...)
+	for _, dir := range libDirs {
+		args = append(args, "-L"+dir)
+	}
+	for _, dir := range systemLibDirs() {
+		args = append(args, "-L"+dir)
+	}
+	for _, lib := range libs {
+		args = append(args, "-l"+lib)
+	}
+	if linkStatic {
+		args = append(args, "--start-group", "-lgcc", "-lgcc_eh", "-lc", "--end-group")
+	} else {
+		args = append(args, "-lgcc", "--as-needed", "-lgcc_s", "--no-as-needed", "-lc", "-lgcc")
+	}
+	if err := crt("crtend.o", "crtn.o"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
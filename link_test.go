@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yanhao/sc/ast"
+)
+
+func TestSplitJoinedArgs(t *testing.T) {
+	var cases = []struct {
+		args, expect []string
+	}{
+		{[]string{"-lm", "-L/opt/lib", "a.c"}, []string{"-l", "m", "-L", "/opt/lib", "a.c"}},
+		{[]string{"-l", "m", "-L", "lib"}, []string{"-l", "m", "-L", "lib"}},
+		{[]string{"-Wall", "-Wno-unused-variable", "-Werror"}, []string{"-W", "all", "-W", "no-unused-variable", "-W", "error"}},
+		{[]string{"-link-modules", "-ld-path=ld", "-static"}, []string{"-link-modules", "-ld-path=ld", "-static"}},
+		{[]string{"--ld-path", "ld", "-o", "out"}, []string{"--ld-path", "ld", "-o", "out"}},
+		{[]string{"-run", "a.c", "--", "-lfoo", "-Wbar"}, []string{"-run", "a.c", "--", "-lfoo", "-Wbar"}},
+		{[]string{"-l", "-W"}, []string{"-l", "-W"}},
+		{[]string{"lib.c", "-"}, []string{"lib.c", "-"}},
+	}
+
+	for _, c := range cases {
+		if args := splitJoinedArgs(c.args); !reflect.DeepEqual(args, c.expect) {
+			t.Errorf("%v should be split into %v, got %v", c.args, c.expect, args)
+		}
+	}
+}
+
+func TestIsLinkerInput(t *testing.T) {
+	var cases = map[string]bool{
+		"a.o":             true,
+		"dir/libx.a":      true,
+		"libx.so":         true,
+		"a.c":             false,
+		"a.h":             false,
+		"a":               false,
+		"a.o.c":           false,
+		"libx.so.1":       false,
+		"dir.o/a.c":       false,
+		"/tmp/sc123/0.o":  true,
+		"archive.tar.gz":  false,
+		"object.obj":      false,
+		"shared.dylib":    false,
+		"relative/./b.so": true,
+	}
+
+	for name, expect := range cases {
+		if isLinkerInput(name) != expect {
+			t.Errorf("isLinkerInput(%q) should be %v", name, expect)
+		}
+	}
+}
+
+func TestMultiarch(t *testing.T) {
+	var cases = map[string]string{
+		"x86_64-pc-linux-gnu":       "x86_64-linux-gnu",
+		"i686-pc-linux-gnu":         "i386-linux-gnu",
+		"i386-unknown-linux-gnu":    "i386-linux-gnu",
+		"aarch64-unknown-linux-gnu": "aarch64-linux-gnu",
+		"riscv64-unknown-linux-gnu": "riscv64-linux-gnu",
+	}
+
+	var saved = ast.Target
+	defer func() { ast.Target = saved }()
+	for triple, expect := range cases {
+		ast.Target = ast.TargetFor(triple, 0)
+		if m := multiarch(); m != expect {
+			t.Errorf("multiarch of %s should be %s, got %s", triple, expect, m)
+		}
+	}
+}
+
+// a system root with crt files of gcc 9 and 12 and of libc for arch, whose
+// files found are given relative to the root
+func makeSysRoot(t *testing.T, arch string) string {
+	var root = t.TempDir()
+	var files = []string{
+		"usr/lib/gcc/" + arch + "/9/crtbegin.o",
+		"usr/lib/gcc/" + arch + "/12/crtbegin.o",
+		"usr/lib/gcc/" + arch + "/12/crtbeginT.o",
+		"usr/lib/gcc/" + arch + "/12/crtend.o",
+		"usr/lib/" + arch + "/crt1.o",
+		"usr/lib/" + arch + "/crti.o",
+		"usr/lib/" + arch + "/crtn.o",
+	}
+	for _, f := range files {
+		var p = filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestLdArgs(t *testing.T) {
+	var cases = []struct {
+		triple string
+		static bool
+		expect string // with the root as $
+	}{
+		{"x86_64-pc-linux-gnu", false,
+			"-o out -dynamic-linker /lib64/ld-linux-x86-64.so.2 " +
+				"$/usr/lib/x86_64-linux-gnu/crt1.o $/usr/lib/x86_64-linux-gnu/crti.o $/usr/lib/gcc/x86_64-linux-gnu/12/crtbegin.o " +
+				"a.o libx.a -L/opt/lib " +
+				"-L$/usr/lib/gcc/x86_64-linux-gnu/12 -L$/usr/lib/x86_64-linux-gnu -L$/lib/x86_64-linux-gnu " +
+				"-L$/usr/lib64 -L$/lib64 -L$/usr/lib -L$/lib -lm " +
+				"-lgcc --as-needed -lgcc_s --no-as-needed -lc -lgcc " +
+				"$/usr/lib/gcc/x86_64-linux-gnu/12/crtend.o $/usr/lib/x86_64-linux-gnu/crtn.o"},
+		{"x86_64-pc-linux-gnu", true,
+			"-o out -static " +
+				"$/usr/lib/x86_64-linux-gnu/crt1.o $/usr/lib/x86_64-linux-gnu/crti.o $/usr/lib/gcc/x86_64-linux-gnu/12/crtbeginT.o " +
+				"a.o libx.a -L/opt/lib " +
+				"-L$/usr/lib/gcc/x86_64-linux-gnu/12 -L$/usr/lib/x86_64-linux-gnu -L$/lib/x86_64-linux-gnu " +
+				"-L$/usr/lib64 -L$/lib64 -L$/usr/lib -L$/lib -lm " +
+				"--start-group -lgcc -lgcc_eh -lc --end-group " +
+				"$/usr/lib/gcc/x86_64-linux-gnu/12/crtend.o $/usr/lib/x86_64-linux-gnu/crtn.o"},
+		{"i686-pc-linux-gnu", false,
+			"-o out -dynamic-linker /lib/ld-linux.so.2 " +
+				"$/usr/lib/i386-linux-gnu/crt1.o $/usr/lib/i386-linux-gnu/crti.o $/usr/lib/gcc/i386-linux-gnu/12/crtbegin.o " +
+				"a.o libx.a -L/opt/lib " +
+				"-L$/usr/lib/gcc/i386-linux-gnu/12 -L$/usr/lib/i386-linux-gnu -L$/lib/i386-linux-gnu " +
+				"-L$/usr/lib64 -L$/lib64 -L$/usr/lib -L$/lib -lm " +
+				"-lgcc --as-needed -lgcc_s --no-as-needed -lc -lgcc " +
+				"$/usr/lib/gcc/i386-linux-gnu/12/crtend.o $/usr/lib/i386-linux-gnu/crtn.o"},
+	}
+
+	var saved = ast.Target
+	defer func() {
+		ast.Target = saved
+		sysRoot, libDirs, libs, linkStatic = "/", nil, nil, false
+	}()
+	libDirs, libs = listFlag{"/opt/lib"}, listFlag{"m"}
+
+	for _, c := range cases {
+		ast.Target = ast.TargetFor(c.triple, 0)
+		sysRoot, linkStatic = makeSysRoot(t, multiarch()), c.static
+
+		var args, err = ldArgs([]string{"a.o", "libx.a"}, "out")
+		if err != nil {
+			t.Errorf("ld args of %s should be found: %s", c.triple, err)
+			continue
+		}
+		var expect = strings.ReplaceAll(c.expect, "$", sysRoot)
+		if s := strings.Join(args, " "); s != expect {
+			t.Errorf("wrong ld args of %s, static %v, expect\n%s\ngot\n%s", c.triple, c.static, expect, s)
+		}
+	}
+
+	// crt files are required
+	ast.Target = ast.TargetFor("aarch64-unknown-linux-gnu", 0)
+	sysRoot, linkStatic = t.TempDir(), false
+	if _, err := ldArgs([]string{"a.o"}, "out"); err == nil || !strings.Contains(err.Error(), "crt1.o") {
+		t.Errorf("missing crt1.o should be reported, got %v", err)
+	}
+
+	// so is the dynamic linker
+	ast.Target = ast.TargetFor("mips-unknown-linux-gnu", 0)
+	if _, err := ldArgs([]string{"a.o"}, "out"); err == nil || !strings.Contains(err.Error(), "dynamic linker") {
+		t.Errorf("unknown dynamic linker should be reported, got %v", err)
+	}
+}
@@ -19,12 +19,16 @@ const NR_LA = 4
 type Parser struct {
 	lex             *lexer.Scanner
 	tokens          [NR_LA]lexer.Token // support 4-lookahead
+	last            lexer.Token        // the token consumed last
+	consumed        int                // number of tokens consumed
+	recovered       int                // consumed when a ; was last assumed
 	cursor          int
 	eot             bool // meet EOT
 	ctx             *ast.AstContext
 	currentScope    *ast.SymbolScope
 	tu              *ast.TranslationUnit
 	effectiveParent ast.Ast // This is a bad name, it is used for ast.RecordDecl parsing
+	paramLevel      int     // > 0 when parsing a parameter list
 	verbose         bool
 	Reports         []*ast.Report
 }
@@ -57,7 +61,7 @@ func (self *Parser) peek(n int) lexer.Token {
 
 func (self *Parser) getNextToken() lexer.Token {
 	if self.eot {
-		return lexer.Token{Kind: lexer.EOT}
+		return self.tokens[NR_LA-1] // EOT, which keeps its location
 	}
 
 	tok := self.lex.Next()
@@ -69,6 +73,8 @@ func (self *Parser) getNextToken() lexer.Token {
 
 func (self *Parser) next() lexer.Token {
 	tok := self.tokens[0]
+	self.last = tok
+	self.consumed++
 	for i := 1; i <= NR_LA-1; i++ {
 		self.tokens[i-1] = self.tokens[i]
 	}
@@ -81,9 +87,35 @@ func (self *Parser) match(kd lexer.Kind) {
 	if self.peek(0).Kind == kd {
 		self.next()
 	} else {
-		self.parseError(self.peek(0),
-			fmt.Sprintf("expect %s, but %s found",
-				lexer.TokKinds[kd], lexer.TokKinds[self.peek(0).Kind]))
+		var tok = self.peek(0)
+		if n := len(self.Reports); tok.Kind == lexer.EOT && n > 0 && self.Reports[n-1].Token.Kind == lexer.EOT {
+			panic(self.Reports[n-1]) // enclosing constructs end here too
+		}
+		var r = self.makeReport("parser.expected-token", tok, fmt.Sprintf("expect %s, but %s found",
+			lexer.TokKinds[kd], lexer.TokKinds[tok.Kind]))
+		switch kd {
+		case lexer.SEMICOLON, lexer.RPAREN, lexer.CLOSE_BRACKET:
+			// a missing closing token is inserted after the last token, where
+			// it is reported if the next token is in another line
+			if self.last.Line > 0 {
+				if tok.Line != self.last.Line {
+					r.Token = lexer.MakeToken(kd, "")
+					r.Location = self.last.Location
+					r.Column += ast.TokenWidth(self.last)
+				}
+				r.AddFixIt(self.last, lexer.TokKinds[kd], true)
+			}
+		}
+		self.Reports = append(self.Reports, r)
+
+		// a ; missing before } or the end of line is assumed to go on, unless
+		// nothing is consumed since the last time
+		var atEnd = tok.Kind == lexer.RBRACE || tok.Line != self.last.Line
+		if kd == lexer.SEMICOLON && self.last.Line > 0 && atEnd && self.recovered != self.consumed {
+			self.recovered = self.consumed
+			return
+		}
+		panic(r)
 	}
 }
 
@@ -91,9 +123,15 @@ func (self *Parser) makeNode(tk lexer.Token) ast.Node {
 	return ast.Node{Ctx: self.ctx, Start: tk}
 }
 
-// the only entry
+// the only entry, which can be called again to parse more text in the same
+// top scope, as the repl does
 func (self *Parser) Parse(opts *ParseOption) ast.Ast {
 	self.lex = lexer.NewScanner(opts.Reader)
+	self.eot = false
+	self.last = lexer.Token{}
+	self.recovered = -1
+	self.currentScope = self.ctx.Top
+	self.paramLevel = 0
 	for i := range self.tokens {
 		self.tokens[i] = self.getNextToken()
 	}
@@ -102,16 +140,19 @@ func (self *Parser) Parse(opts *ParseOption) ast.Ast {
 
 	var tu = self.parseTU(opts)
 
-	defer func() {
-		for _, r := range self.Reports {
-			util.Printf(util.Parser, util.Critical, "Error: %v",
-				fmt.Sprintf("%s(%s) %d:%d, %s", lexer.TokKinds[r.Token.Kind], r.AsString(),
-					r.Line, r.Column, r.Desc))
-		}
-	}()
+	// pragmas are all scanned once the end of text is met
+	if self.eot {
+		self.Reports = append(self.Reports, ast.SetDiagnosticPragmas(opts.Filename, self.lex.Pragmas)...)
+	}
+	self.Reports = ast.FilterReports(self.Reports)
 	return tu
 }
 
+// render reports of the last parse to stderr
+func (self *Parser) DumpReports() {
+	ast.DumpReports(self.Reports)
+}
+
 // translation-unit: external-declaration+
 func (self *Parser) parseTU(opts *ParseOption) ast.Ast {
 	defer self.trace("")()
@@ -128,12 +169,27 @@ func (self *Parser) parseTU(opts *ParseOption) ast.Ast {
 	return self.tu
 }
 
-func (self *Parser) parseError(tok lexer.Token, msg string) {
+// an error of the unit being parsed, whose diagnostic is id
+func (self *Parser) makeReport(id string, tok lexer.Token, msg string) *ast.Report {
 	var r = ast.MakeReport(ast.Error, tok, msg)
+	r.ID = id
+	r.File = self.tu.Filename
+	return r
+}
+
+func (self *Parser) parseError(id string, tok lexer.Token, msg string) {
+	var r = self.makeReport(id, tok, msg)
 	self.Reports = append(self.Reports, r)
 	panic(r)
 }
 
+// report an error which does not break the parse, unlike parseError
+func (self *Parser) reportError(id string, tok lexer.Token, msg string) *ast.Report {
+	var r = self.makeReport(id, tok, msg)
+	self.Reports = append(self.Reports, r)
+	return r
+}
+
 func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 	defer self.trace("")()
 	var (
@@ -156,31 +212,31 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 		)
 		if l > 0 {
 			if s > 0 {
-				self.parseError(tok, fmt.Sprintf(err1, "long", "short"))
+				self.parseError("parser.invalid-type-specifier", tok, fmt.Sprintf(err1, "long", "short"))
 			} else if c > 0 {
-				self.parseError(tok, fmt.Sprintf(err2, "long char"))
+				self.parseError("parser.invalid-type-specifier", tok, fmt.Sprintf(err2, "long char"))
 			}
 
 			if l > 2 {
-				self.parseError(tok, fmt.Sprintf(err1, "long", "long long"))
+				self.parseError("parser.invalid-type-specifier", tok, fmt.Sprintf(err1, "long", "long long"))
 			}
 		} else if i > 0 {
 			if c > 0 {
-				self.parseError(tok, fmt.Sprintf(err1, "char", "int"))
+				self.parseError("parser.invalid-type-specifier", tok, fmt.Sprintf(err1, "char", "int"))
 			} else if s > 1 {
 				// report duplicaton
 			} else if i > 1 {
-				self.parseError(tok, fmt.Sprintf(err1, "int", "int"))
+				self.parseError("parser.invalid-type-specifier", tok, fmt.Sprintf(err1, "int", "int"))
 			}
 		} else if s > 0 {
 			if c > 0 {
-				self.parseError(tok, fmt.Sprintf(err1, "char", "short"))
+				self.parseError("parser.invalid-type-specifier", tok, fmt.Sprintf(err1, "char", "short"))
 			}
 		}
 
 		if unsigned > 0 {
 			if signed > 0 {
-				self.parseError(tok, fmt.Sprintf(err1, "signed", "unsigned"))
+				self.parseError("parser.invalid-type-specifier", tok, fmt.Sprintf(err1, "signed", "unsigned"))
 			}
 		}
 	}
@@ -197,19 +253,19 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 						util.Printf(util.Parser, util.Critical, "this is a typedefing")
 					}
 				} else {
-					self.parseError(tok, "multiple storage class specified")
+					self.parseError("parser.multiple-storage-classes", tok, "multiple storage class specified")
 				}
 			} else if ast.IsTypeSpecifier(tok) {
 				ts := tok.AsString()
 				switch ts {
 				case "union", "struct":
 					if ty != nil {
-						self.parseError(tok, err3)
+						self.parseError("parser.invalid-type-specifier", tok, err3)
 					}
 					ty = self.parseRecordType()
 				case "enum":
 					if ty != nil {
-						self.parseError(tok, err3)
+						self.parseError("parser.invalid-type-specifier", tok, err3)
 					}
 					ty = self.parseEnumType()
 
@@ -217,7 +273,7 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 					self.next()
 					parts[ts] = append(parts[ts], tok.Location)
 					if ty != nil {
-						self.parseError(tok, err3)
+						self.parseError("parser.invalid-type-specifier", tok, err3)
 					}
 					switch ts {
 					case "int", "long", "char", "short", "unsigned", "signed":
@@ -228,8 +284,10 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 						ty = &ast.FloatType{}
 					case "double":
 						ty = &ast.DoubleType{}
+					case "__builtin_va_list":
+						ty = ast.MakeVaListType()
 					default:
-						self.parseError(tok, "unknown type specifier")
+						self.parseError("parser.unknown-type-specifier", tok, "unknown type specifier")
 					}
 				}
 				doCheckError(tok)
@@ -241,7 +299,7 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 				self.next()
 				//FIXME: ignore now
 			} else {
-				self.parseError(tok, "invalid declaration specifier")
+				self.parseError("parser.invalid-declaration-specifier", tok, "invalid declaration specifier")
 			}
 		} else if tok.Kind == lexer.IDENTIFIER {
 			//TODO: make doCheckError check user type
@@ -249,7 +307,7 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 			if uty := self.LookupTypedef(tok.AsString()); uty != nil {
 				util.Printf("found usertype %s", tok.AsString())
 				if ty != nil {
-					self.parseError(tok, err3)
+					self.parseError("parser.invalid-type-specifier", tok, err3)
 				}
 				ty = uty
 				self.next()
@@ -267,6 +325,7 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 			l        = len(parts["long"])
 			i        = len(parts["int"])
 			s        = len(parts["short"])
+			c        = len(parts["char"])
 			unsigned = len(parts["unsigned"])
 		)
 		ity := &ast.IntegerType{}
@@ -284,8 +343,11 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 			}
 		} else if s > 0 {
 			ity.Kind = "short"
-		} else {
+		} else if c > 0 {
 			ity.Kind = "char"
+		} else {
+			// `unsigned` or `signed` alone means int
+			ity.Kind = "int"
 		}
 
 		if unsigned > 0 {
@@ -308,8 +370,24 @@ func (self *Parser) parseTypeDecl(sym *ast.Symbol) (isTypedef bool) {
 	return
 }
 
+// a lone void in the param list declares a function of no params (C99
+// 6.7.5.3p10), which is consumed here
+func (self *Parser) skipVoidParams() bool {
+	if tk := self.peek(0); tk.Kind == lexer.KEYWORD && tk.AsString() == "void" && self.peek(1).Kind == lexer.RPAREN {
+		self.next()
+		return true
+	}
+	return false
+}
+
 func (self *Parser) parseFunctionParams(decl *ast.FunctionDecl, ty *ast.Function) {
 	defer self.trace("")()
+	self.paramLevel++
+	defer func() { self.paramLevel-- }()
+
+	if self.skipVoidParams() {
+		return
+	}
 
 	for {
 		if self.peek(0).Kind == lexer.RPAREN {
@@ -318,7 +396,7 @@ func (self *Parser) parseFunctionParams(decl *ast.FunctionDecl, ty *ast.Function
 
 		if self.peek(0).Kind == lexer.ELLIPSIS {
 			if self.peek(1).Kind != lexer.RPAREN {
-				self.parseError(self.peek(0), "ellipsis should be the last arg of varidic function")
+				self.parseError("parser.misplaced-ellipsis", self.peek(0), "ellipsis should be the last arg of varidic function")
 			}
 			self.next()
 			decl.IsVariadic = true
@@ -328,7 +406,7 @@ func (self *Parser) parseFunctionParams(decl *ast.FunctionDecl, ty *ast.Function
 
 		var tmpl = &ast.Symbol{}
 		if isTypedef := self.parseTypeDecl(tmpl); isTypedef {
-			self.parseError(self.peek(0), "typedef is not allowed in function param")
+			self.parseError("parser.typedef-in-parameter", self.peek(0), "typedef is not allowed in function param")
 		}
 		if arg := self.parseDeclarator(tmpl); arg == nil {
 			break
@@ -342,7 +420,7 @@ func (self *Parser) parseFunctionParams(decl *ast.FunctionDecl, ty *ast.Function
 				ty.Args = append(ty.Args, pty.Type)
 				util.Printf("parsed arg %v", pd.Repr())
 			default:
-				self.parseError(self.peek(0), "invalid parameter declaration")
+				self.parseError("parser.invalid-parameter", self.peek(0), "invalid parameter declaration")
 			}
 		}
 
@@ -354,6 +432,12 @@ func (self *Parser) parseFunctionParams(decl *ast.FunctionDecl, ty *ast.Function
 
 func (self *Parser) parseFunctionParamTypes(ty *ast.Function) {
 	defer self.trace("")()
+	self.paramLevel++
+	defer func() { self.paramLevel-- }()
+
+	if self.skipVoidParams() {
+		return
+	}
 
 	for {
 		if self.peek(0).Kind == lexer.RPAREN {
@@ -362,7 +446,7 @@ func (self *Parser) parseFunctionParamTypes(ty *ast.Function) {
 
 		if self.peek(0).Kind == lexer.ELLIPSIS {
 			if self.peek(1).Kind != lexer.RPAREN {
-				self.parseError(self.peek(0), "ellipsis should be the last arg of varidic function")
+				self.parseError("parser.misplaced-ellipsis", self.peek(0), "ellipsis should be the last arg of varidic function")
 			}
 			self.next()
 			ty.IsVariadic = true
@@ -371,7 +455,7 @@ func (self *Parser) parseFunctionParamTypes(ty *ast.Function) {
 
 		var tmpl = &ast.Symbol{}
 		if isTypedef := self.parseTypeDecl(tmpl); isTypedef {
-			self.parseError(self.peek(0), "typedef is not allowed in function param")
+			self.parseError("parser.typedef-in-parameter", self.peek(0), "typedef is not allowed in function param")
 		}
 		if arg := self.parseDeclarator(tmpl); arg == nil {
 			break
@@ -383,7 +467,7 @@ func (self *Parser) parseFunctionParamTypes(ty *ast.Function) {
 				ty.Args = append(ty.Args, pty.Type)
 				util.Printf("parsed arg type %v", pty.Type)
 			default:
-				self.parseError(self.peek(0), "invalid parameter declaration")
+				self.parseError("parser.invalid-parameter", self.peek(0), "invalid parameter declaration")
 			}
 		}
 
@@ -405,7 +489,8 @@ func (self *Parser) parseDeclarator(tmpl *ast.Symbol) ast.Ast {
 		id                    *lexer.Token
 		idLevel               = 0
 		finalSym              = ast.Symbol{Storage: tmpl.Storage}
-		nested                = 0 // nested level
+		prevSym               *ast.Symbol // previous declaration of the same entity
+		nested                = 0         // nested level
 		isTypedef             = tmpl.Storage == ast.Typedef
 		refTok                = self.peek(0)
 	)
@@ -485,7 +570,9 @@ func (self *Parser) parseDeclarator(tmpl *ast.Symbol) ast.Ast {
 			idLevel = nested
 			finalSym.Name = *id
 			finalSym.NS = ast.OrdinaryNS
-			self.AddSymbol(&finalSym)
+			if prevSym = self.lookupRedeclaration(&finalSym); prevSym == nil {
+				self.AddSymbol(&finalSym)
+			}
 		}
 
 		switch self.peek(0).Kind {
@@ -521,7 +608,8 @@ func (self *Parser) parseDeclarator(tmpl *ast.Symbol) ast.Ast {
 				pt.hole = basePartial.hole
 			}
 
-			if nested == 1 && id != nil && idLevel == nested {
+			// a param of function type is not a function declaration
+			if nested == 1 && id != nil && idLevel == nested && self.paramLevel == 0 {
 				var fdecl = &ast.FunctionDecl{Node: self.makeNode(*id)}
 				decl = fdecl
 				fdecl.Name = id.AsString()
@@ -578,7 +666,7 @@ func (self *Parser) parseDeclarator(tmpl *ast.Symbol) ast.Ast {
 					operations[lexer.COMMA].LedPred = oldpred
 				}
 			default:
-				self.parseError(self.peek(0), "Initializer is not allowed here (only variables can be initialized)")
+				self.parseError("parser.invalid-initializer", self.peek(0), "Initializer is not allowed here (only variables can be initialized)")
 			}
 		}
 
@@ -606,10 +694,62 @@ func (self *Parser) parseDeclarator(tmpl *ast.Symbol) ast.Ast {
 		self.AddNamedType(finalSym.Type)
 	}
 
+	if prevSym != nil {
+		self.mergeRedeclaration(prevSym, &finalSym)
+	}
+
 	util.Printf(util.Parser, util.Verbose, "parsed %v %v", finalSym.Name.AsString(), finalSym.Type)
 	return decl
 }
 
+// objects and functions may be declared more than once at file scope, and
+// by `extern` in a block (C99 6.2.2, 6.7). all these declarations refer to
+// the symbol of the first one
+func (self *Parser) lookupRedeclaration(sym *ast.Symbol) *ast.Symbol {
+	if sym.Storage == ast.Typedef {
+		return nil
+	}
+	if _, yes := self.currentScope.Owner.(*ast.TranslationUnit); !yes && sym.Storage != ast.External {
+		return nil
+	}
+
+	for _, prev := range self.currentScope.Symbols {
+		if prev.NS == sym.NS && prev.Name.AsString() == sym.Name.AsString() {
+			if prev.Storage == ast.Typedef {
+				return nil
+			}
+			return prev
+		}
+	}
+	return nil
+}
+
+// merge the type and storage of sym into its previous declaration prev
+func (self *Parser) mergeRedeclaration(prev, sym *ast.Symbol) {
+	var name = sym.Name.AsString()
+	var ty, ok = ast.CompositeType(prev.Type, sym.Type)
+	if !ok {
+		self.reportError("parser.conflicting-types", sym.Name, fmt.Sprintf("conflicting types for '%s'", name)).
+			AddNote(prev.Name, "previous declaration is here")
+		return
+	}
+	prev.Type = ty
+
+	// the linkage is decided by the first declaration, except that an object
+	// defined without storage class has external linkage
+	_, isFunc := ty.(*ast.Function)
+	switch {
+	case prev.Storage == ast.Static && sym.Storage == ast.NilStorage && !isFunc:
+		self.reportError("parser.non-static-after-static", sym.Name, fmt.Sprintf("non-static declaration of '%s' follows static declaration", name)).
+			AddNote(prev.Name, "previous declaration is here")
+	case prev.Storage != ast.Static && sym.Storage == ast.Static:
+		self.reportError("parser.static-after-non-static", sym.Name, fmt.Sprintf("static declaration of '%s' follows non-static declaration", name)).
+			AddNote(prev.Name, "previous declaration is here")
+	case prev.Storage == ast.External && sym.Storage == ast.NilStorage:
+		prev.Storage = ast.NilStorage
+	}
+}
+
 func (self *Parser) parseEnumType() ast.SymbolType {
 	defer self.trace("")()
 	var (
@@ -700,7 +840,7 @@ func (self *Parser) parseEnumType() ast.SymbolType {
 		)
 		tok = self.next()
 		if tok.Kind != lexer.IDENTIFIER {
-			self.parseError(tok, "need a valid enumerator constant")
+			self.parseError("parser.invalid-enumerator", tok, "need a valid enumerator constant")
 		}
 
 		et.Name = tok.AsString()
@@ -708,6 +848,7 @@ func (self *Parser) parseEnumType() ast.SymbolType {
 		es.Name = tok
 		//FIXME: do check if redeclaration happens
 		self.AddNamedType(et)
+		ret.List = append(ret.List, et)
 		es.NS = ast.OrdinaryNS
 		self.AddSymbol(es)
 
@@ -838,7 +979,7 @@ func (self *Parser) parseRecordType() ast.SymbolType {
 		var loc = self.peek(0).Location
 
 		if isTypedef := self.parseTypeDecl(tmplSym); isTypedef {
-			self.parseError(self.peek(0), "typedef is not allowed in record")
+			self.parseError("parser.typedef-in-record", self.peek(0), "typedef is not allowed in record")
 		}
 
 		util.Printf("parsed field type template %v", tmplSym)
@@ -869,7 +1010,7 @@ func (self *Parser) parseRecordType() ast.SymbolType {
 					ft.Name = fd.Sym
 
 				default:
-					self.parseError(self.peek(0), "invalid field declarator")
+					self.parseError("parser.invalid-field-declarator", self.peek(0), "invalid field declarator")
 				}
 			} else {
 				fd.Loc = loc
@@ -958,7 +1099,7 @@ func (self *Parser) parseCompoundStmt() *ast.CompoundStmt {
 	self.match(lexer.LBRACE)
 
 	for {
-		if self.peek(0).Kind == lexer.RBRACE {
+		if kd := self.peek(0).Kind; kd == lexer.RBRACE || kd == lexer.EOT {
 			break
 		}
 
@@ -1068,9 +1209,13 @@ func (self *Parser) tolerableParse(rule func() ast.Expression, follow ...lexer.T
 	defer self.trace("")()
 	defer func() {
 		if p := recover(); p != nil {
-			util.Printf(util.Parser, util.Critical, "Parse Error, ignore until %v\n", follow[0])
-			tok := self.peek(0)
-			for {
+			// errors raised by parseError are reported already
+			if _, ok := p.(*ast.Report); !ok {
+				self.reportError("parser.expected-expression", self.peek(0), fmt.Sprintf("expect an expression, %v", p))
+			}
+			util.Printf(util.Parser, util.Verbose, "Parse Error, ignore until %v\n", follow[0])
+			// the follow token is left to the caller
+			for tok := self.peek(0); ; tok = self.peek(0) {
 				if tok.Kind == lexer.EOT {
 					panic(p)
 				}
@@ -1079,9 +1224,8 @@ func (self *Parser) tolerableParse(rule func() ast.Expression, follow ...lexer.T
 						return
 					}
 				}
-				tok = self.next()
+				self.next()
 			}
-			retVal = nil
 		}
 	}()
 
@@ -1109,12 +1253,12 @@ func (self *Parser) parseDoStatement() *ast.DoStmt {
 	self.next()
 	doStmt.Body = self.parseStatement()
 	if tok = self.next(); tok.AsString() != "while" {
-		self.parseError(tok, "exepect while")
+		self.parseError("parser.expected-while", tok, "exepect while")
 	}
 	self.match(lexer.LPAREN)
 	doStmt.Cond = self.parseExpression(0)
 	self.match(lexer.RPAREN)
-	self.mayIgnore(lexer.SEMICOLON)
+	self.match(lexer.SEMICOLON)
 
 	return doStmt
 }
@@ -1142,7 +1286,7 @@ func (self *Parser) parseLabelStatement() *ast.LabelStmt {
 
 	tok := self.next()
 	if tok.Kind != lexer.IDENTIFIER {
-		self.parseError(tok, "expect identifier")
+		self.parseError("parser.expected-identifier", tok, "expect identifier")
 	}
 	labelStmt.Label = tok.AsString()
 	self.match(lexer.COLON)
@@ -1158,10 +1302,10 @@ func (self *Parser) parseGotoStatement() *ast.GotoStmt {
 	self.next()
 	tok := self.next()
 	if tok.Kind != lexer.IDENTIFIER {
-		self.parseError(tok, "expect identifier")
+		self.parseError("parser.expected-identifier", tok, "expect identifier")
 	}
 	gotoStmt.Label = tok.AsString()
-	self.mayIgnore(lexer.SEMICOLON)
+	self.match(lexer.SEMICOLON)
 	return gotoStmt
 }
 
@@ -1171,7 +1315,7 @@ func (self *Parser) parseContinueStatement() *ast.ContinueStmt {
 	var continueStmt = &ast.ContinueStmt{Node: self.makeNode(self.peek(0))}
 
 	self.next()
-	self.mayIgnore(lexer.SEMICOLON)
+	self.match(lexer.SEMICOLON)
 
 	return continueStmt
 }
@@ -1182,7 +1326,7 @@ func (self *Parser) parseBreakStatement() *ast.BreakStmt {
 	var breakStmt = &ast.BreakStmt{Node: self.makeNode(self.peek(0))}
 
 	self.next()
-	self.mayIgnore(lexer.SEMICOLON)
+	self.match(lexer.SEMICOLON)
 
 	return breakStmt
 }
@@ -1196,7 +1340,7 @@ func (self *Parser) parseReturnStatement() *ast.ReturnStmt {
 	if self.peek(0).Kind != lexer.SEMICOLON {
 		returnStmt.Expr = self.parseExpression(0)
 	}
-	self.mayIgnore(lexer.SEMICOLON)
+	self.match(lexer.SEMICOLON)
 
 	return returnStmt
 }
@@ -1229,14 +1373,14 @@ func (self *Parser) parseForStatement() *ast.ForStmt {
 		newScope = true
 		forStmt.Decl = self.parseDeclStatement()
 	} else {
-		forStmt.Init = self.parseExpression(0)
+		forStmt.Init = self.parseOptionalExpression(lexer.SEMICOLON)
 		self.match(lexer.SEMICOLON)
 	}
 
-	forStmt.Cond = self.parseExpression(0)
+	forStmt.Cond = self.parseOptionalExpression(lexer.SEMICOLON)
 	self.match(lexer.SEMICOLON)
 
-	forStmt.Step = self.parseExpression(0)
+	forStmt.Step = self.parseOptionalExpression(lexer.RPAREN)
 
 	self.match(lexer.RPAREN)
 	forStmt.Body = self.parseStatement()
@@ -1294,6 +1438,9 @@ func (self *Parser) parseDeclStatement() *ast.DeclStmt {
 
 		if self.peek(0).Kind == lexer.COMMA {
 			self.next()
+		} else {
+			self.match(lexer.SEMICOLON)
+			break
 		}
 	}
 
@@ -1305,13 +1452,21 @@ func (self *Parser) parseExprStatement() (ret *ast.ExprStmt) {
 
 	var exprStmt = &ast.ExprStmt{Node: self.makeNode(self.peek(0))}
 
+	// a null statement
+	if self.mayIgnore(lexer.SEMICOLON) {
+		return nil
+	}
+
+	var n = len(self.Reports)
 	exprStmt.Expr = self.tolerableParse(func() ast.Expression {
 		return self.parseExpression(0)
 	}, lexer.MakeToken(lexer.SEMICOLON, ";"))
-	self.mayIgnore(lexer.SEMICOLON)
+	self.match(lexer.SEMICOLON)
 
 	if exprStmt.Expr == nil {
-		util.Printf(util.Parser, util.Warning, "null expression near %v\n", self.peek(0))
+		if len(self.Reports) == n {
+			self.reportError("parser.expected-expression", exprStmt.Start, "expect an expression")
+		}
 		return nil
 	}
 
@@ -1420,7 +1575,7 @@ func condop_led(p *Parser, lhs ast.Expression, op *operation) ast.Expression {
 func sizeof_nud(p *Parser, op *operation) ast.Expression {
 	defer p.trace("")()
 	if tok := p.next(); tok.AsString() != "sizeof" {
-		p.parseError(tok, "invalid keyword in expression, maybe sizeof ?")
+		p.parseError("parser.invalid-keyword-in-expression", tok, "invalid keyword in expression, maybe sizeof ?")
 	}
 
 	e := &ast.SizeofExpr{Node: p.makeNode(op.Token)}
@@ -1431,7 +1586,7 @@ func sizeof_nud(p *Parser, op *operation) ast.Expression {
 			p.match(lexer.LPAREN)
 			e.Type = p.parseTypeExpression()
 			if e.Type == nil {
-				p.parseError(p.peek(0), "invalid type name")
+				p.parseError("parser.invalid-type-name", p.peek(0), "invalid type name")
 			}
 			p.match(lexer.RPAREN)
 		} else {
@@ -1443,6 +1598,52 @@ func sizeof_nud(p *Parser, op *operation) ast.Expression {
 	return e
 }
 
+// for keywords appear in expression
+func keyword_nud(p *Parser, op *operation) ast.Expression {
+	defer p.trace("")()
+	switch op.Token.AsString() {
+	case "__builtin_va_start", "__builtin_va_arg", "__builtin_va_end", "__builtin_va_copy":
+		return builtin_nud(p, op)
+	}
+	return sizeof_nud(p, op)
+}
+
+// for builtins of form `name(args)`, only __builtin_va_arg takes a type
+// name as its last operand
+func builtin_nud(p *Parser, op *operation) ast.Expression {
+	defer p.trace("")()
+	p.next()
+	p.match(lexer.LPAREN)
+	var e = &ast.BuiltinCallExpr{Node: p.makeNode(op.Token), Name: op.Token.AsString()}
+
+	oldpred := operations[lexer.COMMA].LedPred
+	operations[lexer.COMMA].LedPred = -1
+	defer func() { operations[lexer.COMMA].LedPred = oldpred }()
+
+	for {
+		if p.peek(0).Kind == lexer.RPAREN {
+			break
+		}
+
+		if e.Name == "__builtin_va_arg" && len(e.Args) == 1 {
+			if e.Type = p.tryParseTypeExpression(); e.Type == nil {
+				p.parseError("parser.invalid-type-name", p.peek(0), "expect a type name")
+			}
+		} else {
+			e.Args = append(e.Args, p.parseExpression(0))
+		}
+		if p.peek(0).Kind == lexer.COMMA {
+			p.next()
+			if p.peek(0).Kind == lexer.RPAREN {
+				p.parseError("parser.expected-expression", p.peek(0), "expect an expression")
+			}
+		}
+	}
+
+	p.match(lexer.RPAREN)
+	return e
+}
+
 // for unary (including prefix)
 func unaryop_nud(p *Parser, op *operation) ast.Expression {
 	defer p.trace("")()
@@ -1498,6 +1699,7 @@ func lparen_led(p *Parser, lhs ast.Expression, op *operation) ast.Expression {
 	// which will be parsed as `comma expr`, so to handle this correctly,
 	// I temperarily mark COMMA as END-OF-EXPR, and restore precedence later
 	operations[lexer.COMMA].LedPred = -1
+	defer func() { operations[lexer.COMMA].LedPred = oldpred }()
 
 	for {
 		if p.peek(0).Kind == lexer.RPAREN {
@@ -1507,10 +1709,12 @@ func lparen_led(p *Parser, lhs ast.Expression, op *operation) ast.Expression {
 		expr.Args = append(expr.Args, p.parseExpression(0))
 		if p.peek(0).Kind == lexer.COMMA {
 			p.next()
+			if p.peek(0).Kind == lexer.RPAREN {
+				p.parseError("parser.expected-expression", p.peek(0), "expect an expression")
+			}
 		}
 	}
 
-	operations[lexer.COMMA].LedPred = oldpred
 	p.match(lexer.RPAREN)
 	return expr
 }
@@ -1535,7 +1739,7 @@ func (self *Parser) tryParseTypeExpression() ast.SymbolType {
 	if ast.IsStorageClass(tok) || ast.IsTypeQualifier(tok) || ast.IsTypeSpecifier(tok) {
 		ty = self.parseTypeExpression()
 		if ty == nil {
-			self.parseError(tok, "invalid type name for casting")
+			self.parseError("parser.invalid-type-name", tok, "invalid type name for casting")
 		}
 	}
 
@@ -1561,7 +1765,7 @@ func lparen_nud(p *Parser, op *operation) ast.Expression {
 			p.match(lexer.RPAREN)
 			return expr
 		} else {
-			p.parseError(op.Token, "near (")
+			p.parseError("parser.syntax-error", op.Token, "near (")
 		}
 	} else {
 		p.match(lexer.RPAREN)
@@ -1635,12 +1839,12 @@ func expr_led(p *Parser, lhs ast.Expression, op *operation) ast.Expression {
 
 // parse error
 func error_led(p *Parser, lhs ast.Expression, op *operation) ast.Expression {
-	p.parseError(op.Token, "expect an operator")
+	p.parseError("parser.expected-operator", op.Token, "expect an operator")
 	return nil
 }
 
 func error_nud(p *Parser, op *operation) ast.Expression {
-	p.parseError(op.Token, "expect an expression")
+	p.parseError("parser.expected-expression", op.Token, "expect an expression")
 	return nil
 }
 
@@ -1658,6 +1862,8 @@ func literal_nud(p *Parser, op *operation) ast.Expression {
 	switch op.Kind {
 	case lexer.INT_LITERAL:
 		return &ast.IntLiteralExpr{Node: p.makeNode(op.Token), Tok: op.Token}
+	case lexer.FLOAT_LITERAL:
+		return &ast.FloatLiteralExpr{Node: p.makeNode(op.Token), Tok: op.Token}
 	case lexer.STR_LITERAL:
 		return &ast.StringLiteralExpr{Node: p.makeNode(op.Token), Tok: op.Token}
 	case lexer.CHAR_LITERAL:
@@ -1666,25 +1872,40 @@ func literal_nud(p *Parser, op *operation) ast.Expression {
 	return nil
 }
 
-func (self *Parser) parseExpression(rbp int) (ret ast.Expression) {
-	defer self.trace("")()
-
-	if self.peek(0).Kind == lexer.SEMICOLON {
+// an expression which may be left out right before end, e.g. in for
+func (self *Parser) parseOptionalExpression(end lexer.Kind) ast.Expression {
+	if self.peek(0).Kind == end {
 		return nil
 	}
+	return self.parseExpression(0)
+}
 
-	operand := self.newOperation(self.peek(0))
+func (self *Parser) parseExpression(rbp int) (ret ast.Expression) {
+	defer self.trace("")()
+
+	first := self.peek(0)
+	operand := self.newOperation(first)
 	lhs := operand.nud(self, operand)
+	self.setRange(lhs, first)
 
 	op := self.newOperation(self.peek(0))
 	for rbp < op.LedPred {
 		lhs = op.led(self, lhs, op)
+		self.setRange(lhs, first)
 		op = self.newOperation(self.peek(0))
 	}
 
 	return lhs
 }
 
+// e spans from first up to the token consumed last, which is before first if
+// nothing is consumed in recovery
+func (self *Parser) setRange(e ast.Expression, first lexer.Token) {
+	if e != nil && self.last.Line > 0 && self.last.Offset >= first.Offset {
+		e.SetRange(first, self.last)
+	}
+}
+
 func (self *Parser) PushScope() *ast.SymbolScope {
 	var scope = &ast.SymbolScope{}
 	scope.Parent = self.currentScope
@@ -1718,9 +1939,26 @@ func (self *Parser) AddSymbol(sym *ast.Symbol) {
 		}
 
 	}
+
+	// a redeclaration is reported before the declaration is given up
+	defer func() {
+		if p := recover(); p != nil {
+			if r, ok := p.(*ast.Report); ok {
+				r.ID = "parser.redeclaration"
+				ast.SetReportsFile([]*ast.Report{r}, self.tu.Filename)
+				self.Reports = append(self.Reports, r)
+			}
+			panic(p)
+		}
+	}()
 	current.AddSymbol(sym)
 }
 
+// the file scope, which is kept by parses after the first one
+func (self *Parser) TopScope() *ast.SymbolScope {
+	return self.ctx.Top
+}
+
 func (self *Parser) LookupSymbol(name string, ns ast.SymbolNamespace) *ast.Symbol {
 	return self.currentScope.LookupSymbol(name, ns)
 }
@@ -1789,6 +2027,7 @@ func (self *Parser) DumpAst() {
 	var walker = struct {
 		WalkTranslationUnit      func(ast.WalkStage, *ast.TranslationUnit, *ast.WalkContext)
 		WalkIntLiteralExpr       func(ws ast.WalkStage, e *ast.IntLiteralExpr, ctx *ast.WalkContext) bool
+		WalkFloatLiteralExpr     func(ws ast.WalkStage, e *ast.FloatLiteralExpr, ctx *ast.WalkContext) bool
 		WalkCharLiteralExpr      func(ws ast.WalkStage, e *ast.CharLiteralExpr, ctx *ast.WalkContext) bool
 		WalkStringLiteralExpr    func(ws ast.WalkStage, e *ast.StringLiteralExpr, ctx *ast.WalkContext) bool
 		WalkBinaryOperation      func(ws ast.WalkStage, e *ast.BinaryOperation, ctx *ast.WalkContext) bool
@@ -1799,6 +2038,7 @@ func (self *Parser) DumpAst() {
 		WalkArraySubscriptExpr   func(ws ast.WalkStage, e *ast.ArraySubscriptExpr, ctx *ast.WalkContext) bool
 		WalkMemberExpr           func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool
 		WalkFunctionCall         func(ws ast.WalkStage, e *ast.FunctionCall, ctx *ast.WalkContext) bool
+		WalkBuiltinCallExpr      func(ws ast.WalkStage, e *ast.BuiltinCallExpr, ctx *ast.WalkContext) bool
 		WalkCompoundAssignExpr   func(ws ast.WalkStage, e *ast.CompoundAssignExpr, ctx *ast.WalkContext) bool
 		WalkCastExpr             func(ws ast.WalkStage, e *ast.CastExpr, ctx *ast.WalkContext) bool
 		WalkImplicitCastExpr     func(ws ast.WalkStage, e *ast.ImplicitCastExpr, ctx *ast.WalkContext) bool
@@ -1861,6 +2101,18 @@ func (self *Parser) DumpAst() {
 		return true
 	}
 
+	walker.WalkFloatLiteralExpr = func(ws ast.WalkStage, e *ast.FloatLiteralExpr, ctx *ast.WalkContext) bool {
+		if ws == ast.WalkerPropagate {
+			if arraymode {
+				arraylog = append(arraylog, e.Tok.AsString())
+				return false
+			} else {
+				log(e.Repr())
+			}
+		}
+		return true
+	}
+
 	walker.WalkCharLiteralExpr = func(ws ast.WalkStage, e *ast.CharLiteralExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
 			if arraymode {
@@ -2045,6 +2297,28 @@ func (self *Parser) DumpAst() {
 		}
 		return true
 	}
+	walker.WalkBuiltinCallExpr = func(ws ast.WalkStage, e *ast.BuiltinCallExpr, ctx *ast.WalkContext) bool {
+		if ws == ast.WalkerPropagate {
+			if arraymode {
+				arraylog = append(arraylog, e.Name+"(")
+				for _, a := range e.Args {
+					ast.WalkAst(a, walker)
+				}
+				arraylog = append(arraylog, ")")
+				return false
+			}
+
+			if e.Type == nil {
+				log(e.Repr())
+			} else {
+				log(fmt.Sprintf("BuiltinCall(%s, %v)", e.Name, e.Type))
+			}
+			stack++
+		} else {
+			stack--
+		}
+		return true
+	}
 	walker.WalkCompoundAssignExpr = func(ws ast.WalkStage, e *ast.CompoundAssignExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
 			if arraymode {
@@ -2411,8 +2685,8 @@ func init() {
 	operations[lexer.MOD] = &operation{lexer.Token{}, LeftAssoc, -1, 130, error_nud, binop_led}
 
 	// unary !, ~
-	operations[lexer.NOT] = &operation{lexer.Token{}, LeftAssoc, -1, 140, unaryop_nud, error_led}
-	operations[lexer.TILDE] = &operation{lexer.Token{}, LeftAssoc, -1, 140, unaryop_nud, error_led}
+	operations[lexer.NOT] = &operation{lexer.Token{}, LeftAssoc, 140, -1, unaryop_nud, error_led}
+	operations[lexer.TILDE] = &operation{lexer.Token{}, LeftAssoc, 140, -1, unaryop_nud, error_led}
 	// &, *, +, - is assigned beforehand
 
 	// NOTE: ( can appear at a lot of places: primary (expr), postfix (type){initlist}, postfix func()
@@ -2427,8 +2701,8 @@ func init() {
 	operations[lexer.INC] = &operation{lexer.Token{}, LeftAssoc, 140, 160, unaryop_nud, unaryop_led}
 	operations[lexer.DEC] = &operation{lexer.Token{}, LeftAssoc, 140, 160, unaryop_nud, unaryop_led}
 
-	// for sizeof unary op
-	operations[lexer.KEYWORD] = &operation{lexer.Token{}, LeftAssoc, 140, -1, sizeof_nud, error_led}
+	// for sizeof unary op and builtins
+	operations[lexer.KEYWORD] = &operation{lexer.Token{}, LeftAssoc, 140, -1, keyword_nud, error_led}
 
 	operations[lexer.OPEN_BRACKET] = &operation{lexer.Token{}, LeftAssoc, -1, 160, error_nud, array_led}
 	operations[lexer.CLOSE_BRACKET] = &operation{lexer.Token{}, LeftAssoc, -1, -1, error_nud, expr_led}
@@ -2436,6 +2710,7 @@ func init() {
 	operations[lexer.REFERENCE] = &operation{lexer.Token{}, LeftAssoc, -1, 160, error_nud, member_led}
 
 	operations[lexer.INT_LITERAL] = &operation{lexer.Token{}, NoAssoc, 200, -1, literal_nud, error_led}
+	operations[lexer.FLOAT_LITERAL] = &operation{lexer.Token{}, NoAssoc, 200, -1, literal_nud, error_led}
 	operations[lexer.STR_LITERAL] = &operation{lexer.Token{}, NoAssoc, 200, -1, literal_nud, error_led}
 	operations[lexer.CHAR_LITERAL] = &operation{lexer.Token{}, NoAssoc, 200, -1, literal_nud, error_led}
 	operations[lexer.IDENTIFIER] = &operation{lexer.Token{}, NoAssoc, 200, -1, id_nud, error_led}
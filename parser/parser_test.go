@@ -78,7 +78,8 @@ void signal(int signo, ...);
 	if tu, ok := ast.(*a.TranslationUnit); !ok {
 		t.Errorf("parse failed")
 	} else {
-		if len(tu.Decls) != 21 {
+		// fp is declared twice, which is valid
+		if len(tu.Decls) != 22 {
 			t.Errorf("failed to parse some decls")
 		}
 
@@ -102,7 +103,7 @@ void signal(int signo, ...);
 			t.Errorf("failed to parse some records")
 		}
 
-		if vd != 15 {
+		if vd != 16 {
 			t.Errorf("failed to parse some vars")
 		}
 	}
@@ -624,6 +625,202 @@ int foo(int a, int b)
 	testTemplate(t, text)
 }
 
+func TestParseRedeclarations(t *testing.T) {
+	var text = `
+extern int x;
+int x;
+int a[];
+int a[3];
+int f();
+int f(int v);
+static int g(int v);
+int g(int v) { return v; }
+int x;
+double x;
+static int y;
+int y;
+int z;
+static int z;
+`
+	opts := ParseOption{
+		Filename: "./test.txt",
+		Reader:   strings.NewReader(text),
+	}
+	p := NewParser()
+	top := p.Parse(&opts).(*a.TranslationUnit)
+
+	var expect = []string{
+		"conflicting types for 'x'",
+		"non-static declaration of 'y' follows static declaration",
+		"static declaration of 'z' follows non-static declaration",
+	}
+	if len(p.Reports) != len(expect) {
+		t.Fatalf("should have %d reports, but %d", len(expect), len(p.Reports))
+	}
+	for i, r := range p.Reports {
+		if r.Desc != expect[i] {
+			t.Errorf("report #%d should be '%s', but '%s'", i, expect[i], r.Desc)
+		}
+	}
+
+	var syms = map[string]string{"x": "'int' x", "a": "'int[]' a", "f": "'int (int)' f", "g": "'int (int)' g static"}
+	for name, str := range syms {
+		var n = 0
+		for _, sym := range top.Ctx.Top.Symbols {
+			if sym.Name.AsString() == name {
+				n++
+			}
+		}
+		if sym := top.Ctx.Top.LookupSymbol(name, a.OrdinaryNS); n != 1 || sym.String() != str {
+			t.Errorf("%s should be declared once as %s, but %v", name, str, sym)
+		}
+	}
+	if l := top.Ctx.Top.LookupSymbol("a", a.OrdinaryNS).Type.(*a.Array).Len(); l != 3 {
+		t.Errorf("a should be completed to 3 elements, but %d", l)
+	}
+}
+
+func TestParseReports(t *testing.T) {
+	var text = `
+int main() {
+	int n = 1
+	int n;
+	return n
+}
+`
+	opts := ParseOption{Filename: "./test.txt", Reader: strings.NewReader(text)}
+	p := NewParser()
+	p.Parse(&opts)
+
+	// missing ; are inserted after the last tokens and the parse goes on
+	var expect = []struct {
+		id, desc, note string
+		line, col      int
+		fix            string
+	}{
+		{"parser.expected-token", "expect ;, but KEYWORD found", "", 3, 10, ";"},
+		{"parser.redeclaration", "redeclaration of 'n'", "previous declaration is here", 4, 5, ""},
+		{"parser.expected-token", "expect ;, but } found", "", 5, 9, ";"},
+	}
+	if len(p.Reports) != len(expect) {
+		t.Fatalf("should have %d reports, but %d", len(expect), len(p.Reports))
+	}
+	for i, r := range p.Reports {
+		var e = expect[i]
+		if r.Desc != e.desc || r.Line != e.line || r.Column != e.col || r.File != "./test.txt" {
+			t.Errorf("report #%d should be '%s' at %d:%d, but '%s' at %s:%d:%d", i, e.desc, e.line, e.col,
+				r.Desc, r.File, r.Line, r.Column)
+		}
+		if r.ID != e.id {
+			t.Errorf("report #%d should be of %s, but %s", i, e.id, r.ID)
+		}
+		if e.note != "" && (len(r.Notes) != 1 || r.Notes[0].Desc != e.note || r.Notes[0].Line != 3) {
+			t.Errorf("report #%d should have note '%s' at line 3, but %v", i, e.note, r.Notes)
+		}
+		if e.fix != "" && (len(r.FixIts) != 1 || r.FixIts[0].Text != e.fix || r.FixIts[0].Column != e.col) {
+			t.Errorf("report #%d should insert '%s' at %d, but %v", i, e.fix, e.col, r.FixIts)
+		}
+	}
+}
+
+func TestParseMissingOperands(t *testing.T) {
+	var texts = []string{
+		"int main() { int x = ; return 0; }",
+		"int main() { return 1 + ; }",
+		"int main() { int x; x = x * ; return 0; }",
+		"int f(int a, int b); int main() { return f(1, ); }",
+	}
+	for i, text := range texts {
+		opts := ParseOption{Filename: "./test.txt", Reader: strings.NewReader(text)}
+		p := NewParser()
+		p.Parse(&opts)
+
+		if len(p.Reports) != 1 || p.Reports[0].ID != "parser.expected-expression" {
+			t.Errorf("text #%d should be reported as parser.expected-expression, but %v", i, p.Reports)
+		}
+	}
+
+	// expressions may be left out of null statements and for
+	var text = "int main() { int i; ; for (;;) break; for (i = 0; ; ) break; return 0; }"
+	opts := ParseOption{Filename: "./test.txt", Reader: strings.NewReader(text)}
+	p := NewParser()
+	p.Parse(&opts)
+	if len(p.Reports) != 0 {
+		t.Errorf("there should be no reports, but %v", p.Reports)
+	}
+}
+
+func TestParsePragmas(t *testing.T) {
+	var text = `
+#pragma once
+#pragma sc diagnostic push
+#pragma sc diagnostic ignored "-Wshadow"
+#pragma sc diagnostic ignored "shadows"
+#pragma sc diagnostic ignored shadow
+#pragma sc diagnostic pop
+int main() { return 0; }
+`
+	opts := ParseOption{Filename: "./test.txt", Reader: strings.NewReader(text)}
+	p := NewParser()
+	p.Parse(&opts)
+
+	// pragmas of others than sc are ignored, and unknown-pragmas is left
+	// to -Wall
+	var expect = []struct {
+		id   string
+		line int
+	}{
+		{"parser.unknown-warning-option", 5},
+	}
+	if len(p.Reports) != len(expect) {
+		t.Fatalf("should have %d reports, but %v", len(expect), p.Reports)
+	}
+	for i, r := range p.Reports {
+		if r.ID != expect[i].id || r.Line != expect[i].line || r.Kind != a.Warning {
+			t.Errorf("report #%d should be warning of %s at line %d, but %v of %s at line %d", i,
+				expect[i].id, expect[i].line, r.Kind, r.ID, r.Line)
+		}
+	}
+}
+
+func TestParseAgain(t *testing.T) {
+	var texts = []string{
+		"struct point { int x, y; };\nint n;",
+		"int f() { struct point p; p.x = n; return p.x; }\nenum e { 3 };",
+		"int g(struct point *p);\nint n;",
+	}
+
+	p := NewParser()
+	var parse = func(text string) *a.TranslationUnit {
+		p.Reports = nil
+		var opts = ParseOption{Filename: "./test.txt", Reader: strings.NewReader(text)}
+		return p.Parse(&opts).(*a.TranslationUnit)
+	}
+
+	parse(texts[0])
+	var mark = p.TopScope().Mark()
+	// a broken unit leaves its symbols behind, but not the parser broken
+	parse(texts[1])
+	if len(p.Reports) == 0 || p.TopScope().LookupSymbol("f", a.OrdinaryNS) == nil {
+		t.Fatalf("f should be declared with errors")
+	}
+	p.TopScope().Restore(mark)
+	if p.TopScope().LookupSymbol("f", a.OrdinaryNS) != nil {
+		t.Errorf("f should be dropped")
+	}
+
+	var tu = parse(texts[2])
+	if len(p.Reports) > 0 {
+		t.Fatalf("struct point and n of the first unit should be seen")
+	}
+	if tu.Ctx.Top != p.TopScope() || len(tu.Decls) != 2 {
+		t.Errorf("the top scope should be kept")
+	}
+	if n := len(p.TopScope().Symbols); n != 3 {
+		t.Errorf("point, n and g should be declared, but %d symbols", n)
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	os.Exit(m.Run())
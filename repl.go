@@ -0,0 +1,514 @@
+package main
+
+// sc repl compiles every snippet into a module of its own, which is added to
+// one execution engine, while all snippets share the top scope of one parser.
+// leading declarations of a snippet become globals, the rest is wrapped into
+// a function run at once, and the value of an expression not terminated by ;
+// is printed with its type
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/yanhao/sc/ast"
+	"github.com/yanhao/sc/codegen"
+	"github.com/yanhao/sc/lexer"
+	"github.com/yanhao/sc/parser"
+	"github.com/yanhao/sc/sema"
+
+	llvm "tinygo.org/x/go-llvm"
+)
+
+const replHelp = `enter declarations, statements or expressions, values of expressions without ; are printed
+  :type expr   print the type of expr
+  :ast expr    dump the ast of expr
+  :llvm        dump the llvm ir of the last snippet
+  :quit        exit`
+
+// functions wrapping snippets are named by this prefix
+const replFuncPrefix = "__repl_"
+
+type repl struct {
+	parser  *parser.Parser
+	top     *ast.SymbolScope
+	engine  llvm.ExecutionEngine
+	lastMod llvm.Module
+	seq     int
+
+	// declarations of earlier snippets, which are replayed in later modules
+	// as prototypes and extern declarations to refer to their definitions
+	replay []ast.Statement
+
+	// the snippets symbols of the top scope are declared in, for notes
+	// pointing to them
+	origins map[*ast.Symbol]string
+
+	// the current snippet and lines its wrapper puts before it, which are
+	// taken off lines of its reports
+	src       string
+	wrapLines int
+
+	stdout, stderr io.Writer // of values and errors
+}
+
+func newRepl() *repl {
+	var p = parser.NewParser()
+	return &repl{parser: p, top: p.TopScope(), origins: make(map[*ast.Symbol]string),
+		stdout: os.Stdout, stderr: os.Stderr}
+}
+
+// a declaration starts with a storage class, a type or a typedef name
+func (r *repl) isDeclaration(src string) bool {
+	var tok = lexer.NewScanner(strings.NewReader(src)).Next()
+	switch {
+	case ast.IsStorageClass(tok), ast.IsTypeSpecifier(tok), ast.IsTypeQualifier(tok):
+		return true
+	case tok.Kind == lexer.IDENTIFIER:
+		return r.parser.LookupTypedef(tok.AsString()) != nil
+	}
+	return false
+}
+
+// the first declaration or statement of src and the rest of it, which ends
+// by ; or by the } of a function body out of brackets
+func firstPiece(src string) (string, string) {
+	var depth = 0
+	var quote rune
+	var escaped = false
+	var last rune // non-blank out of brackets
+	var body = false
+	for i, c := range src {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{' || c == '(' || c == '[':
+			if depth == 0 && c == '{' {
+				body = last == ')'
+			}
+			depth++
+		case c == '}' || c == ')' || c == ']':
+			depth--
+			if depth == 0 && c == '}' && body {
+				return src[:i+1], strings.TrimSpace(src[i+1:])
+			}
+		case c == ';' && depth == 0:
+			return src[:i+1], strings.TrimSpace(src[i+1:])
+		}
+		if depth == 0 && !unicode.IsSpace(c) {
+			last = c
+		}
+	}
+	return src, ""
+}
+
+// move reports of the current snippet from lines of the text parsed back to
+// those of the snippet
+func (r *repl) unwrap(reports []*ast.Report) {
+	var file = fmt.Sprintf("<repl:%d>", r.seq)
+	var lines = strings.Split(r.src, "\n")
+	var move = func(loc *lexer.Location) {
+		loc.Line -= r.wrapLines
+		switch {
+		case loc.Line < 1:
+			loc.Line, loc.Column = 1, 0
+		case loc.Line > len(lines):
+			loc.Line, loc.Column = len(lines), len(lines[len(lines)-1])
+		}
+	}
+	for _, rp := range reports {
+		if rp.File == file && rp.Line > 0 {
+			move(&rp.Location)
+			if rp.End.Line > 0 {
+				move(&rp.Begin)
+				move(&rp.End)
+			}
+			for i := range rp.FixIts {
+				move(&rp.FixIts[i].Location)
+			}
+		}
+		r.unwrap(rp.Notes)
+	}
+}
+
+// parse and check text as a translation unit in the shared top scope, which
+// is src with wrapLines lines of a wrapper before it
+func (r *repl) parse(text, src string, wrapLines int) *ast.TranslationUnit {
+	r.seq++
+	r.src, r.wrapLines = src, wrapLines
+	var opts = parser.ParseOption{
+		Filename: fmt.Sprintf("<repl:%d>", r.seq),
+		Reader:   strings.NewReader(text),
+		Verbose:  beVerbose,
+	}
+	ast.AddSource(opts.Filename, []byte(src))
+	r.parser.Reports = nil
+	var tu = r.parser.Parse(&opts).(*ast.TranslationUnit)
+	r.unwrap(r.parser.Reports)
+	r.parser.DumpReports()
+	if ast.HasErrors(r.parser.Reports) {
+		return nil
+	}
+
+	// snippets are modules of their own, file scope statics of one snippet
+	// have to be seen by others
+	for _, decl := range tu.Decls {
+		var name string
+		switch e := decl.(type) {
+		case *ast.FunctionDecl:
+			name = e.Name
+		case *ast.VariableDecl:
+			name = e.Sym
+		default:
+			continue
+		}
+		if sym := r.top.LookupSymbol(name, ast.OrdinaryNS); sym.Storage == ast.Static {
+			sym.Storage = ast.NilStorage
+		}
+	}
+
+	sema.Reports = nil
+	sema.RunWalkers(tu)
+	for _, rp := range sema.Reports {
+		for _, n := range rp.Notes {
+			for _, sym := range r.top.Symbols {
+				if file, ok := r.origins[sym]; ok && sym.Name == n.Token {
+					n.File = file
+				}
+			}
+		}
+	}
+	r.unwrap(sema.Reports)
+	sema.DumpReports()
+	if ast.HasErrors(sema.Reports) {
+		return nil
+	}
+	return tu
+}
+
+// wrap statements into a function of void in lines of its own, whose name is
+// returned
+func (r *repl) parseStmts(src string) (*ast.TranslationUnit, string) {
+	src = strings.TrimSpace(src)
+	var text = src
+	if !strings.HasSuffix(src, ";") && !strings.HasSuffix(src, "}") {
+		text += ";"
+	}
+	var name = fmt.Sprintf("%s%d", replFuncPrefix, r.seq+1)
+	return r.parse(fmt.Sprintf("void %s() {\n%s\n}\n", name, text), src, 1), name
+}
+
+// the function of a snippet and its expression if the snippet ends by an
+// expression statement
+func snippetExpr(tu *ast.TranslationUnit, name string) (*ast.FunctionDecl, ast.Expression) {
+	for _, decl := range tu.Decls {
+		if fd, ok := decl.(*ast.FunctionDecl); ok && fd.Name == name {
+			if n := len(fd.Body.Stmts); n > 0 {
+				if es, ok := fd.Body.Stmts[n-1].(*ast.ExprStmt); ok {
+					return fd, es.Expr
+				}
+			}
+			return fd, nil
+		}
+	}
+	return nil, nil
+}
+
+// values of these types can be returned to the engine
+func isPrintable(ty ast.SymbolType) bool {
+	switch ty.(type) {
+	case *ast.IntegerType, *ast.EnumType, *ast.FloatType, *ast.DoubleType, *ast.Pointer:
+		return true
+	}
+	return false
+}
+
+func formatValue(gv llvm.GenericValue, ty ast.SymbolType) string {
+	switch ty := ty.(type) {
+	case *ast.IntegerType:
+		if ty.Unsigned {
+			return fmt.Sprint(gv.Int(false))
+		}
+		return fmt.Sprint(int64(gv.Int(true)))
+	case *ast.EnumType:
+		return fmt.Sprint(int64(gv.Int(true)))
+	case *ast.FloatType:
+		return fmt.Sprint(float32(gv.Float(llvm.FloatType())))
+	case *ast.DoubleType:
+		return fmt.Sprint(gv.Float(llvm.DoubleType()))
+	default:
+		return fmt.Sprintf("%p", gv.Pointer())
+	}
+}
+
+// generate the module of tu, which refers to globals of earlier snippets,
+// and add it to the engine
+func (r *repl) compile(tu *ast.TranslationUnit) error {
+	for _, decl := range r.replay {
+		if vd, ok := decl.(*ast.VariableDecl); ok {
+			r.top.LookupSymbol(vd.Sym, ast.OrdinaryNS).Storage = ast.External
+		}
+	}
+	var decls = tu.Decls
+	tu.Decls = append(append([]ast.Statement{}, r.replay...), decls...)
+	var mod = ast.WalkAst(tu, codegen.MakeLLVMCodeGen()).(llvm.Module)
+	tu.Decls = decls
+	r.unwrap(codegen.Reports)
+	codegen.DumpReports()
+	if ast.HasErrors(codegen.Reports) {
+		return fmt.Errorf("code generation failed")
+	}
+	if err := llvm.VerifyModule(mod, llvm.PrintMessageAction); err != nil {
+		return err
+	}
+
+	var level, sizeLevel = optLevels()
+	codegen.Optimize(mod, level, sizeLevel)
+	if r.engine.C == nil {
+		var engine, err = llvm.NewExecutionEngine(mod)
+		if err != nil {
+			return err
+		}
+		r.engine = engine
+	} else {
+		r.engine.AddModule(mod)
+	}
+	r.lastMod = mod
+
+	for _, decl := range decls {
+		switch e := decl.(type) {
+		case *ast.RecordDecl, *ast.EnumDecl, *ast.TypedefDecl:
+			r.replay = append(r.replay, decl)
+		case *ast.FunctionDecl:
+			if !strings.HasPrefix(e.Name, replFuncPrefix) {
+				var proto = *e
+				proto.Body = nil
+				r.replay = append(r.replay, &proto)
+			}
+		case *ast.VariableDecl:
+			var extern = *e
+			extern.Init = nil
+			r.replay = append(r.replay, &extern)
+		}
+	}
+	return nil
+}
+
+// evaluate the leading declarations of src one by one, then the rest of it
+// as statements
+func (r *repl) eval(src string) {
+	for src != "" {
+		var piece, rest = firstPiece(src)
+		if !r.isDeclaration(piece) {
+			r.evalSnippet(src, false)
+			return
+		}
+		if !r.evalSnippet(piece, true) {
+			return
+		}
+		src = rest
+	}
+}
+
+// evaluate a snippet of declarations or statements, which is undone if
+// anything fails
+func (r *repl) evalSnippet(src string, decl bool) (ok bool) {
+	var mark = r.top.Mark()
+	var nsyms = len(r.top.Symbols)
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Fprintf(r.stderr, "error: %v\n", p)
+		}
+		if !ok {
+			r.top.Restore(mark)
+			return
+		}
+		for _, sym := range r.top.Symbols[nsyms:] {
+			r.origins[sym] = fmt.Sprintf("<repl:%d>", r.seq)
+		}
+	}()
+
+	if decl {
+		var tu = r.parse(src, src, 0)
+		if tu == nil {
+			return
+		}
+		if err := r.compile(tu); err != nil {
+			fmt.Fprintf(r.stderr, "error: %s\n", err)
+			return
+		}
+		ok = true
+		return
+	}
+
+	var tu, name = r.parseStmts(src)
+	if tu == nil {
+		return
+	}
+	var fd, e = snippetExpr(tu, name)
+	if strings.HasSuffix(strings.TrimSpace(src), ";") {
+		// an expression statement has no value to print
+		e = nil
+	}
+	var ty ast.SymbolType
+	if e != nil {
+		ty, _ = ast.Unqualify(e.GetType())
+		if isPrintable(ty) {
+			// the snippet returns the value of the expression instead
+			var last = len(fd.Body.Stmts) - 1
+			fd.Body.Stmts[last] = &ast.ReturnStmt{Node: fd.Body.Stmts[last].(*ast.ExprStmt).Node, Expr: e}
+			var fn = tu.Ctx.Top.LookupSymbol(name, ast.OrdinaryNS)
+			fn.Type.(*ast.Function).Return = ty
+		}
+	}
+	if err := r.compile(tu); err != nil {
+		fmt.Fprintf(r.stderr, "error: %s\n", err)
+		return
+	}
+	ok = true
+
+	var gv = r.engine.RunFunction(r.lastMod.NamedFunction(name), nil)
+	defer gv.Dispose()
+	flushStdio()
+	switch {
+	case e == nil:
+	case isPrintable(ty):
+		fmt.Fprintf(r.stdout, "(%v) %s\n", ty, formatValue(gv, ty))
+	default:
+		if _, void := ty.(*ast.VoidType); !void {
+			fmt.Fprintf(r.stdout, "(%v)\n", ty)
+		}
+	}
+	return
+}
+
+// :type and :ast check expr without running it
+func (r *repl) inspect(cmd, src string) {
+	var mark = r.top.Mark()
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Fprintf(r.stderr, "error: %v\n", p)
+		}
+		r.top.Restore(mark)
+	}()
+
+	var tu, name = r.parseStmts(src)
+	if tu == nil {
+		return
+	}
+	if cmd == ":ast" {
+		r.parser.DumpAst()
+		return
+	}
+	if _, e := snippetExpr(tu, name); e != nil {
+		fmt.Fprintf(r.stdout, "%v\n", e.GetType())
+	} else {
+		fmt.Fprintf(r.stderr, "error: not an expression\n")
+	}
+}
+
+func (r *repl) command(line string) bool {
+	var fields = strings.SplitN(line, " ", 2)
+	var arg string
+	if len(fields) > 1 {
+		arg = strings.TrimSpace(fields[1])
+	}
+
+	switch fields[0] {
+	case ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprintln(r.stdout, replHelp)
+	case ":type", ":ast":
+		if arg == "" {
+			fmt.Fprintf(r.stderr, "error: %s needs an expression\n", fields[0])
+		} else {
+			r.inspect(fields[0], arg)
+		}
+	case ":llvm":
+		if r.lastMod.C == nil {
+			fmt.Fprintf(r.stderr, "error: nothing compiled yet\n")
+		} else {
+			r.lastMod.Dump()
+		}
+	default:
+		fmt.Fprintf(r.stderr, "error: unknown command %s, see :help\n", fields[0])
+	}
+	return true
+}
+
+// a snippet goes on in the next line while brackets are unbalanced
+func unbalanced(src string) bool {
+	var depth = 0
+	var quote rune
+	var escaped = false
+	for _, c := range src {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{' || c == '(' || c == '[':
+			depth++
+		case c == '}' || c == ')' || c == ']':
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+func runRepl() int {
+	var r = newRepl()
+	var interactive = false
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+		fmt.Println("sc repl, :help for commands")
+	}
+
+	var prompt = func(s string) {
+		if interactive {
+			fmt.Print(s)
+		}
+	}
+
+	var scanner = bufio.NewScanner(os.Stdin)
+	var src string
+	for prompt("sc> "); scanner.Scan(); {
+		src += scanner.Text() + "\n"
+		if unbalanced(src) {
+			prompt("... ")
+			continue
+		}
+
+		var line = strings.TrimSpace(src)
+		src = ""
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, ":"):
+			if !r.command(line) {
+				return 0
+			}
+		default:
+			r.eval(line)
+		}
+		prompt("sc> ")
+	}
+	return 0
+}
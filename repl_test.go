@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yanhao/sc/ast"
+)
+
+func TestFirstPiece(t *testing.T) {
+	var cases = []struct {
+		src, piece, rest string
+	}{
+		{"int s = 1; s = s + 1;", "int s = 1;", "s = s + 1;"},
+		{"x = 10;", "x = 10;", ""},
+		{"s + 1", "s + 1", ""},
+		{"int f(int a) { return a; } f(2)", "int f(int a) { return a; }", "f(2)"},
+		{"struct p { int a; } q; q.a", "struct p { int a; } q;", "q.a"},
+		{"struct p { int a; };", "struct p { int a; };", ""},
+		{"for (i = 0; i < 3; i++) s += i;", "for (i = 0; i < 3; i++) s += i;", ""},
+		{"char *s = \";}\"; s", "char *s = \";}\";", "s"},
+		{"char c = ';'; c", "char c = ';';", "c"},
+	}
+
+	for _, c := range cases {
+		if piece, rest := firstPiece(c.src); piece != c.piece || rest != c.rest {
+			t.Errorf("%q should be split into %q and %q, got %q and %q", c.src, c.piece, c.rest, piece, rest)
+		}
+	}
+}
+
+// feed lines of snippets to a repl, returning what is printed and reported
+func replTemplate(lines []string) (string, string, []*ast.Report) {
+	ast.CollectReports, ast.Collected = true, nil
+	defer func() { ast.CollectReports, ast.Collected = false, nil }()
+
+	var stdout, stderr bytes.Buffer
+	var r = newRepl()
+	r.stdout, r.stderr = &stdout, &stderr
+	for _, line := range lines {
+		r.eval(line)
+	}
+	return stdout.String(), stderr.String(), ast.Collected
+}
+
+func TestReplValues(t *testing.T) {
+	var lines = []string{
+		"int s = 1; s = s + 1;",
+		"s",
+		"x = 10;",
+		"int x = 3; x = 10;",
+		"x",
+		"x + 1;",
+		"x = x * 2",
+		"int f(int a) { return a * 2; } f(s)",
+		"struct p { int a; } q; q.a = 4; q.a",
+		"long t = 5; t",
+		"{ s = 0; }",
+		"s",
+	}
+	var out, errs, reports = replTemplate(lines)
+	var expect = "(int) 2\n(int) 10\n(int) 20\n(int) 4\n(int) 4\n(long) 5\n(int) 0\n"
+	if out != expect {
+		t.Errorf("wrong values, expect\n%s\ngot\n%s", expect, out)
+	}
+	if len(reports) != 1 || reports[0].ID != "sema.undeclared-identifier" {
+		t.Errorf("only x = 10 before x is declared should be reported, got %v", reports)
+	}
+	if errs != "" {
+		t.Errorf("no error expected, got %s", errs)
+	}
+}
+
+func TestReplReports(t *testing.T) {
+	var cases = []struct {
+		src          string
+		id           string
+		line, column int
+	}{
+		{"y + 1", "sema.undeclared-identifier", 1, 0},
+		{"1 + y;", "sema.undeclared-identifier", 1, 4},
+		{"int a = 1; a = a +;", "parser.expected-expression", 1, 7},
+		{"{\n  int b = 1;\n  b = zz;\n}", "sema.undeclared-identifier", 3, 6},
+		{"int g() {\n  return 1 +;\n}", "parser.expected-expression", 2, 12},
+		{"{\n  int k = 1;\n  (k + 1).a;\n}", "sema.invalid-member-reference", 3, 9},
+	}
+
+	for _, c := range cases {
+		var _, _, reports = replTemplate([]string{c.src})
+		if len(reports) == 0 {
+			t.Errorf("%q should be reported", c.src)
+			continue
+		}
+		var r = reports[0]
+		if r.ID != c.id || r.Line != c.line || r.Column != c.column {
+			t.Errorf("%q should be reported as %s at %d:%d, got %s at %d:%d",
+				c.src, c.id, c.line, c.column, r.ID, r.Line, r.Column)
+		}
+		if r.End.Line > 0 && (r.Begin.Line != r.Line || r.End.Line != r.Line) {
+			t.Errorf("range of %q should be moved to line %d, got %d to %d", c.src, r.Line, r.Begin.Line, r.End.Line)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+// programs of -run are executed by the JIT in this process, so they share
+// stdin, stdout and the C library with the compiler
+
+// only the C library is used here, llvm is reached through go-llvm, which
+// carries the cgo flags of it
+
+/*
+#include <stdio.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	llvm "tinygo.org/x/go-llvm"
+)
+
+var (
+	runArgs    []string      = nil // argv of the program, given after --
+	runTimeout time.Duration = 0
+	runStatus  int           = 0 // exit status of the program
+)
+
+// exit status of a program killed by -run-timeout, the same as timeout(1)
+const timeoutStatus = 124
+
+func setupRunFlags() {
+	flag.DurationVar(&runTimeout, "run-timeout", runTimeout, "kill the program of -run after `duration`, e.g. 10s")
+}
+
+// NULL terminated array of C strings in C memory, which the program may
+// keep until it returns, freed by the returned func
+func cStrings(strs []string) (unsafe.Pointer, func()) {
+	var arr = C.calloc(C.size_t(len(strs)+1), C.size_t(unsafe.Sizeof((*C.char)(nil))))
+	var ptrs = unsafe.Slice((**C.char)(arr), len(strs)+1)
+	for i, s := range strs {
+		ptrs[i] = C.CString(s)
+	}
+	return arr, func() {
+		for _, p := range ptrs {
+			C.free(unsafe.Pointer(p))
+		}
+		C.free(arr)
+	}
+}
+
+// run main of mod with argv args and the environment of the compiler,
+// returning its exit status after C stdio is flushed
+func runMain(mod llvm.Module, args []string) (int, error) {
+	var fn = mod.NamedFunction("main")
+	if fn.IsNil() {
+		return 0, fmt.Errorf("undefined reference to 'main'")
+	}
+
+	// the engine owns mod, which is disposed along with it
+	engine, err := llvm.NewExecutionEngine(mod)
+	if err != nil {
+		return 0, err
+	}
+	defer engine.Dispose()
+
+	var argv, freeArgv = cStrings(args)
+	defer freeArgv()
+	var envp, freeEnvp = cStrings(os.Environ())
+	defer freeEnvp()
+
+	if runTimeout > 0 {
+		// the program runs on this goroutine, it can only be killed along
+		// with the process, before which output buffered by the program and
+		// the reports collected are written out
+		var timer = time.AfterFunc(runTimeout, func() {
+			flushStdio()
+			fmt.Fprintf(os.Stderr, "program killed after running for %v\n", runTimeout)
+			flushDiagnostics()
+			os.Exit(timeoutStatus)
+		})
+		defer timer.Stop()
+	}
+
+	// main takes none, (argc, argv) or (argc, argv, envp), all of which
+	// the execution engine runs like a C runtime
+	var params = []llvm.GenericValue{
+		llvm.NewGenericValueFromInt(llvm.Int32Type(), uint64(len(args)), false),
+		llvm.NewGenericValueFromPointer(argv),
+		llvm.NewGenericValueFromPointer(envp),
+	}
+	for _, gv := range params {
+		defer gv.Dispose()
+	}
+	if fn.ParamsCount() > len(params) {
+		return 0, fmt.Errorf("main takes %d parameters, expect at most %d", fn.ParamsCount(), len(params))
+	}
+
+	engine.RunStaticConstructors()
+	var status = engine.RunFunction(fn, params[:fn.ParamsCount()])
+	engine.RunStaticDestructors()
+	flushStdio()
+	defer status.Dispose()
+	return int(int32(status.Int(true))), nil
+}
+
+// output of programs is buffered by C stdio, unlike that of the compiler
+func flushStdio() {
+	C.fflush(nil)
+}
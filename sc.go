@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/yanhao/sc/ast"
 	"github.com/yanhao/sc/codegen"
+	"github.com/yanhao/sc/lexer"
 	"github.com/yanhao/sc/parser"
 	"github.com/yanhao/sc/sema"
 
@@ -15,114 +20,446 @@ import (
 )
 
 var (
-	beVerbose  bool = false
-	dumpTokens bool = false
-	dumpAst    bool = false
-	dumpLLVM   bool = false
-	justRun    bool = false
+	beVerbose  bool   = false
+	dumpTokens bool   = false
+	dumpAst    bool   = false
+	dumpLLVM   bool   = false
+	justRun    bool   = false
+	linkIR     bool   = false
+	debugInfo  bool   = false
+	optLevel   string = "0"
+	syntaxOnly bool   = false
+	stopAfter  string = ""
+
+	outputFile   string = ""
+	compileOnly  bool   = false
+	assembleOnly bool   = false
+	emitLLVM     bool   = false
+
+	targetTriple string = ""
+	targetCPU    string = ""
+	targetArch   string = ""
+	targetAttrs  string = ""
 )
 
+// -O0..-O3, -Os and -O, each sets optLevel to its own level, the last one wins
+type optLevelFlag string
+
+func (f optLevelFlag) String() string   { return "" }
+func (f optLevelFlag) IsBoolFlag() bool { return true }
+func (f optLevelFlag) Set(string) error {
+	optLevel = string(f)
+	return nil
+}
+
+// optimization and size levels of optLevel
+func optLevels() (int, int) {
+	switch optLevel {
+	case "s":
+		return 2, 1
+	default:
+		return int(optLevel[0] - '0'), 0
+	}
+}
+
 func setupFlags() {
 	flag.BoolVar(&beVerbose, "verbose", beVerbose, "increase debug output")
 	flag.BoolVar(&dumpTokens, "dump-tokens", dumpTokens, "dump tokens scanned")
 	flag.BoolVar(&dumpAst, "dump-ast", dumpAst, "dump ast parsed")
 	flag.BoolVar(&dumpLLVM, "dump-llvm", dumpLLVM, "dump ast parsed")
-	flag.BoolVar(&justRun, "run", justRun, "run code")
+	flag.BoolVar(&justRun, "run", justRun, "run code, all inputs are linked as by -link-modules")
+	flag.BoolVar(&linkIR, "link-modules", linkIR, "compile all inputs into one module linked at the llvm ir level")
+	flag.BoolVar(&debugInfo, "g", debugInfo, "generate DWARF debug info")
+	flag.BoolVar(&syntaxOnly, "fsyntax-only", syntaxOnly, "check inputs only, the same as -stop-after=sema")
+	flag.StringVar(&stopAfter, "stop-after", stopAfter, "stop after `stage`, one of lex, parse, sema and codegen, no target is needed")
+	flag.Var(optLevelFlag("1"), "O", "same as -O1")
+	for _, l := range []string{"0", "1", "2", "3"} {
+		flag.Var(optLevelFlag(l), "O"+l, "optimization level "+l)
+	}
+	flag.Var(optLevelFlag("s"), "Os", "optimize for size")
+	flag.StringVar(&outputFile, "o", outputFile, "write output to `file`, - for stdout")
+	flag.BoolVar(&compileOnly, "c", compileOnly, "compile to object file")
+	flag.BoolVar(&assembleOnly, "S", assembleOnly, "compile to assembly file")
+	flag.BoolVar(&emitLLVM, "emit-llvm", emitLLVM, "emit llvm ir instead, bitcode with -c and text with -S")
+	flag.StringVar(&targetTriple, "target", targetTriple, "generate code for target `triple`, default to the host")
+	flag.StringVar(&targetCPU, "mcpu", targetCPU, "generate code for `cpu`, default to the generic one of target")
+	flag.StringVar(&targetArch, "march", targetArch, "same as -mcpu if that is absent")
+	flag.StringVar(&targetAttrs, "mattr", targetAttrs, "target `features` to enable or disable, e.g. +avx2,-sse4.1")
+}
+
+// create the machine of target selected by flags, which also decides the
+// data model used by sema and codegen
+func newTargetMachine() (llvm.TargetMachine, error) {
+	llvm.InitializeAllTargetInfos()
+	llvm.InitializeAllTargets()
+	llvm.InitializeAllTargetMCs()
+	llvm.InitializeAllAsmParsers()
+	llvm.InitializeAllAsmPrinters()
+
+	var triple = llvm.DefaultTargetTriple()
+	if targetTriple != "" {
+		triple = codegen.NormalizeTriple(targetTriple)
+	}
+	var cpu = targetCPU
+	if targetArch != "" && !isFlagSet("mcpu") {
+		cpu = targetArch
+	}
+
+	target, err := llvm.GetTargetFromTriple(triple)
+	if err != nil {
+		return llvm.TargetMachine{}, err
+	}
+
+	var level, _ = optLevels()
+	var machine = target.CreateTargetMachine(triple, cpu, targetAttrs,
+		codegen.CodeGenLevel(level), llvm.RelocPIC, llvm.CodeModelDefault)
+
+	var td = machine.CreateTargetData()
+	defer td.Dispose()
+	ast.Target = ast.TargetFor(triple, td.PointerSize()*8)
+	codegen.DataLayout = td.String()
+	codegen.DebugInfo = debugInfo
+	return machine, nil
+}
+
+func isFlagSet(name string) bool {
+	var set = false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
 }
 
-func parse(opts *parser.ParseOption) bool {
+// output path of input file, which is named after input in the current
+// directory unless -o is given
+func outputPath(input string) string {
+	if outputFile != "" {
+		return outputFile
+	}
+
+	var ext = ".o"
+	switch {
+	case assembleOnly && emitLLVM:
+		ext = ".ll"
+	case assembleOnly:
+		ext = ".s"
+	case emitLLVM:
+		ext = ".bc"
+	}
+
+	var base = path.Base(input)
+	if input == "" {
+		base = "stdin"
+	}
+	return strings.TrimSuffix(base, path.Ext(base)) + ext
+}
+
+// write mod in the output form selected by flags
+func emit(mod llvm.Module, machine llvm.TargetMachine, output string) error {
+	var data []byte
+	switch {
+	case assembleOnly && emitLLVM:
+		data = []byte(mod.String())
+
+	case emitLLVM:
+		var buf = llvm.WriteBitcodeToMemoryBuffer(mod)
+		defer buf.Dispose()
+		data = buf.Bytes()
+
+	default:
+		var kind = llvm.ObjectFile
+		if assembleOnly {
+			kind = llvm.AssemblyFile
+		}
+		buf, err := machine.EmitToMemoryBuffer(mod, kind)
+		if err != nil {
+			return err
+		}
+		defer buf.Dispose()
+		data = buf.Bytes()
+	}
+
+	if output == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(output, data, 0644)
+}
+
+// scan opts.Filename for -stop-after=lex, tokens are printed by -dump-tokens
+func scan(opts *parser.ParseOption) bool {
+	var scanner = lexer.NewScanner(opts.Reader)
+	var ok = true
+	for tok := scanner.Next(); tok.Kind != lexer.EOT; tok = scanner.Next() {
+		if dumpTokens {
+			fmt.Printf("%d:%d %s(%s)\n", tok.Line, tok.Column, lexer.TokKinds[tok.Kind], tok.AsString())
+		}
+		if tok.Kind == lexer.ERROR {
+			var r = ast.MakeReport(ast.Error, tok, fmt.Sprintf("invalid token '%s'", tok.AsString()))
+			r.ID, r.File = "lexer.invalid-token", opts.Filename
+			ast.DumpReports([]*ast.Report{r})
+			ok = false
+		}
+	}
+	return ok
+}
+
+// parse, check and generate code of opts.Filename, up to the stage of
+// -stop-after, after which the module is nil
+func compile(opts *parser.ParseOption) (*ast.TranslationUnit, llvm.Module, bool) {
+	// the source is kept to quote its lines in reports
+	var text, err = io.ReadAll(opts.Reader)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", ast.DisplayName(opts.Filename), err)
+		return nil, llvm.Module{}, false
+	}
+	ast.AddSource(opts.Filename, text)
+	opts.Reader = bytes.NewReader(text)
+
+	if stopAfter == "lex" {
+		return nil, llvm.Module{}, scan(opts)
+	}
+
 	p := parser.NewParser()
 	var tu = p.Parse(opts)
+	p.DumpReports()
+	if stopAfter == "parse" {
+		if dumpAst {
+			p.DumpAst()
+		}
+		return nil, llvm.Module{}, !ast.HasErrors(p.Reports) && tu != nil
+	}
 
+	sema.Reports = nil
 	sema.RunWalkers(tu)
 	sema.DumpReports()
 	if dumpAst {
 		p.DumpAst()
 	}
 
-	if len(sema.Reports) > 0 {
-		return false
+	if ast.HasErrors(p.Reports) || ast.HasErrors(sema.Reports) {
+		return nil, llvm.Module{}, false
 	}
 
 	if tu == nil {
-		return false
+		return nil, llvm.Module{}, false
+	}
+	if stopAfter == "sema" {
+		return tu.(*ast.TranslationUnit), llvm.Module{}, true
 	}
 
 	mod := ast.WalkAst(tu, codegen.MakeLLVMCodeGen()).(llvm.Module)
-	llvm.VerifyModule(mod, llvm.PrintMessageAction)
+	codegen.DumpReports()
+	if ast.HasErrors(codegen.Reports) {
+		return nil, llvm.Module{}, false
+	}
+	if err := llvm.VerifyModule(mod, llvm.PrintMessageAction); err != nil {
+		return nil, llvm.Module{}, false
+	}
+	if stopAfter == "codegen" && dumpLLVM {
+		mod.Dump()
+	}
+	return tu.(*ast.TranslationUnit), mod, true
+}
+
+// check external declarations of units against each other, then link their
+// modules into the first one
+func linkUnits(units []*ast.TranslationUnit, mods []llvm.Module) (llvm.Module, bool) {
+	sema.Reports = nil
+	sema.CheckLinkage(units)
+	sema.DumpReports()
+	if ast.HasErrors(sema.Reports) {
+		return llvm.Module{}, false
+	}
+
+	var mod = mods[0]
+	for _, src := range mods[1:] {
+		// src is destroyed by linking
+		if err := llvm.LinkModules(mod, src); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return llvm.Module{}, false
+		}
+	}
+	return mod, true
+}
+
+// optimize mod, then run it or write it into output, which is unused by -run
+func finish(mod llvm.Module, machine llvm.TargetMachine, output string) bool {
+	var level, sizeLevel = optLevels()
+	codegen.Optimize(mod, level, sizeLevel)
 
 	if dumpLLVM {
 		mod.Dump()
 	}
 
 	if justRun {
-		if engine, err := llvm.NewExecutionEngine(mod); err == nil {
-			ret := engine.RunFunction(mod.NamedFunction("main"), nil)
-			fmt.Printf("%d\n", ret.Int(true))
-		} else {
-			fmt.Fprintf(os.Stderr, err.Error())
+		if machine.Triple() != llvm.DefaultTargetTriple() {
+			fmt.Fprintf(os.Stderr, "cannot run code compiled for target %s\n", machine.Triple())
 			return false
 		}
+		var status, err = runMain(mod, runArgs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return false
+		}
+		runStatus = status
 		return true
 	}
 
-	llvm.InitializeAllTargetInfos()
-	llvm.InitializeAllTargets()
-	llvm.InitializeAllTargetMCs()
-	llvm.InitializeAllAsmParsers()
-	llvm.InitializeNativeAsmPrinter()
-
-	target, err := llvm.GetTargetFromTriple(llvm.DefaultTargetTriple())
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+	if err := emit(mod, machine, output); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		return false
 	}
-	var machine = target.CreateTargetMachine(llvm.DefaultTargetTriple(), "generic", "",
-		llvm.CodeGenLevelNone, llvm.RelocDefault, llvm.CodeModelDefault)
-
-	var td = machine.CreateTargetData()
-	mod.SetDataLayout(td.String())
-	mod.SetTarget(target.Name())
-	defer machine.Dispose()
 
-	buf, err := machine.EmitToMemoryBuffer(mod, llvm.ObjectFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		return false
-	}
+	return true
+}
 
-	file, err := os.Create(path.Base(opts.Filename) + ".o")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		return false
+// gcc style command line, where options and inputs are mixed, args after
+// -- are left in runArgs for the program of -run
+func parseArgs(args []string) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			args, runArgs = args[:i], args[i+1:]
+			break
+		}
 	}
 
-	_, err = file.Write(buf.Bytes())
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		return false
+	var inputs []string
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			return inputs
+		}
+		inputs = append(inputs, args[0])
+		args = args[1:]
 	}
-	file.Close()
-
-	return true
 }
 
 func main() {
 	setupFlags()
-	flag.Parse()
+	setupLinkFlags()
+	setupRunFlags()
+	setupDiagnosticFlags()
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		parseArgs(splitJoinedArgs(os.Args[2:]))
+		machine, err := newTargetMachine()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		machine.Dispose()
+		os.Exit(runRepl())
+	}
+	var inputs = parseArgs(splitJoinedArgs(os.Args[1:]))
+
+	if syntaxOnly && stopAfter == "" {
+		stopAfter = "sema"
+	}
+	switch stopAfter {
+	case "", "lex", "parse", "sema", "codegen":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid stage %s for -stop-after, expect lex, parse, sema or codegen\n", stopAfter)
+		os.Exit(1)
+	}
+	var checking = stopAfter != ""
+	if err := setupDiagnostics(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 
-	if flag.NArg() == 0 {
+	// without -c, -S or -emit-llvm, objects are linked into an executable
+	var linking = !compileOnly && !assembleOnly && !emitLLVM && !justRun && !checking
+	linkIR = (linkIR || justRun) && !checking
+	if outputFile != "" && len(inputs) > 1 && !linking && !linkIR {
+		fmt.Fprintf(os.Stderr, "cannot specify -o with -c, -S or -emit-llvm with multiple files\n")
+		os.Exit(1)
+	}
+
+	if len(runArgs) > 0 && !justRun {
+		fmt.Fprintf(os.Stderr, "warning: arguments after -- unused without -run\n")
+	}
+
+	// checks need no target machine but the data model of target
+	var machine llvm.TargetMachine
+	var err error
+	if checking {
+		var triple = llvm.DefaultTargetTriple()
+		if targetTriple != "" {
+			triple = codegen.NormalizeTriple(targetTriple)
+		}
+		ast.Target = ast.TargetFor(triple, 0)
+	} else {
+		if machine, err = newTargetMachine(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		defer machine.Dispose()
+	}
+
+	// objects to link are compiled into a temporary directory
+	var tmpdir string
+	if linking {
+		if tmpdir, err = os.MkdirTemp("", "sc"); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	var exit = func(code int) {
+		flushDiagnostics()
+		if tmpdir != "" {
+			os.RemoveAll(tmpdir)
+		}
+		os.Exit(code)
+	}
+
+	// with -link-modules, units are kept to be linked after all are compiled
+	var (
+		objs   []string
+		units  []*ast.TranslationUnit
+		mods   []llvm.Module
+		failed bool
+	)
+	var objPath = func() string {
+		var output = filepath.Join(tmpdir, fmt.Sprintf("%d.o", len(objs)))
+		objs = append(objs, output)
+		return output
+	}
+	var compileInput = func(opts *parser.ParseOption) {
+		var tu, mod, ok = compile(opts)
+		switch {
+		case !ok:
+			failed = true
+		case checking:
+		case linkIR:
+			units = append(units, tu)
+			mods = append(mods, mod)
+		case linking:
+			failed = !finish(mod, machine, objPath()) || failed
+		default:
+			failed = !finish(mod, machine, outputPath(opts.Filename)) || failed
+		}
+	}
+
+	if len(inputs) == 0 {
 		opts := parser.ParseOption{
 			Verbose: beVerbose,
 		}
 
 		opts.Reader = os.Stdin
-		if !parse(&opts) {
-			return
-		}
+		compileInput(&opts)
 	}
 
-	for _, f := range flag.Args() {
+	for _, f := range inputs {
+		if isLinkerInput(f) {
+			if linking {
+				objs = append(objs, f)
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: %s: linker input file unused because linking not done\n", f)
+			}
+			continue
+		}
+
 		opts := parser.ParseOption{
 			Filename: f,
 			Verbose:  beVerbose,
@@ -130,13 +467,53 @@ func main() {
 
 		if r, err := os.Open(f); err == nil {
 			opts.Reader = r
-			if !parse(&opts) {
-				return
-			}
+			compileInput(&opts)
+			r.Close()
+
+		} else {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		exit(1)
+	}
+
+	if len(mods) > 0 {
+		var mod, ok = linkUnits(units, mods)
+		if !ok {
+			exit(1)
+		}
+		// the program of -run is named after its first unit
+		var name = units[0].Filename
+		if name == "" {
+			name = "stdin"
+		}
+		runArgs = append([]string{name}, runArgs...)
 
+		var output string
+		if linking {
+			// the linked object comes before other linker inputs, which
+			// may be libraries it uses
+			output = filepath.Join(tmpdir, fmt.Sprintf("%d.o", len(objs)))
+			objs = append([]string{output}, objs...)
 		} else {
-			fmt.Errorf("%s", err)
+			output = outputPath(name)
+		}
+		if !finish(mod, machine, output) {
+			exit(1)
 		}
 	}
 
+	if linking {
+		var output = outputFile
+		if output == "" {
+			output = "a.out"
+		}
+		if err := link(objs, output); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			exit(1)
+		}
+	}
+	exit(runStatus)
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the test binary runs as sc itself if SC_TEST_MAIN is set, by which tests
+// of the driver run it as a command
+func TestMain(m *testing.M) {
+	if os.Getenv("SC_TEST_MAIN") != "" {
+		os.Args = append([]string{"sc"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+
+	setupFlags()
+	setupLinkFlags()
+	setupRunFlags()
+	setupDiagnosticFlags()
+
+	// the repl runs on the target machine of the host, as set up by main
+	machine, err := newTargetMachine()
+	if err != nil {
+		panic(err)
+	}
+	machine.Dispose()
+	os.Exit(m.Run())
+}
+
+// run sc with args in dir, returning its stdout, stderr and exit status
+func runSc(t *testing.T, dir string, args ...string) (string, string, int) {
+	var cmd = exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SC_TEST_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	var err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return stdout.String(), stderr.String(), 0
+	case errors.As(err, &exitErr):
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	default:
+		t.Fatalf("failed to run sc %v: %s", args, err)
+		return "", "", -1
+	}
+}
+
+// a directory of sources named by keys of files
+func writeSources(t *testing.T, files map[string]string) string {
+	var dir = t.TempDir()
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestOutputPath(t *testing.T) {
+	var cases = []struct {
+		output   string
+		assemble bool
+		llvm     bool
+		input    string
+		expect   string
+	}{
+		{"", false, false, "a.c", "a.o"},
+		{"", false, false, "dir/b.c", "b.o"},
+		{"", false, false, "c", "c.o"},
+		{"", false, false, "", "stdin.o"},
+		{"", true, false, "a.c", "a.s"},
+		{"", false, true, "a.c", "a.bc"},
+		{"", true, true, "a.c", "a.ll"},
+		{"", true, true, "", "stdin.ll"},
+		{"x.o", false, false, "a.c", "x.o"},
+		{"out/y", true, true, "a.c", "out/y"},
+	}
+
+	defer func() { outputFile, assembleOnly, emitLLVM = "", false, false }()
+	for _, c := range cases {
+		outputFile, assembleOnly, emitLLVM = c.output, c.assemble, c.llvm
+		if p := outputPath(c.input); p != c.expect {
+			t.Errorf("output of %q with -o %q, -S %v, -emit-llvm %v should be %s, got %s",
+				c.input, c.output, c.assemble, c.llvm, c.expect, p)
+		}
+	}
+}
+
+func TestOutputFiles(t *testing.T) {
+	var sources = map[string]string{
+		"a.c": "int f() { return 1; }\n",
+		"b.c": "int g() { return 2; }\n",
+	}
+	var cases = []struct {
+		args    []string
+		outputs []string
+	}{
+		{[]string{"-c", "a.c"}, []string{"a.o"}},
+		{[]string{"-c", "a.c", "b.c"}, []string{"a.o", "b.o"}},
+		{[]string{"-S", "a.c"}, []string{"a.s"}},
+		{[]string{"-emit-llvm", "a.c"}, []string{"a.bc"}},
+		{[]string{"-c", "-emit-llvm", "a.c"}, []string{"a.bc"}},
+		{[]string{"-S", "-emit-llvm", "a.c"}, []string{"a.ll"}},
+		{[]string{"-c", "a.c", "-o", "x.o"}, []string{"x.o"}},
+		{[]string{"-S", "-emit-llvm", "-o", "y.ll", "b.c"}, []string{"y.ll"}},
+		{[]string{"-c", "-link-modules", "-o", "ab.o", "a.c", "b.c"}, []string{"ab.o"}},
+	}
+
+	for _, c := range cases {
+		var dir = writeSources(t, sources)
+		if _, stderr, status := runSc(t, dir, c.args...); status != 0 {
+			t.Errorf("sc %v should succeed, got %d: %s", c.args, status, stderr)
+			continue
+		}
+
+		var files, _ = filepath.Glob(filepath.Join(dir, "*"))
+		if len(files) != len(sources)+len(c.outputs) {
+			t.Errorf("sc %v should write %v only, got %v", c.args, c.outputs, files)
+		}
+		for _, output := range c.outputs {
+			if _, err := os.Stat(filepath.Join(dir, output)); err != nil {
+				t.Errorf("sc %v should write %s", c.args, output)
+			}
+		}
+	}
+
+	// one -o is ambiguous for many outputs
+	var dir = writeSources(t, sources)
+	if _, _, status := runSc(t, dir, "-c", "-o", "x.o", "a.c", "b.c"); status == 0 {
+		t.Errorf("-o with -c and many inputs should fail")
+	}
+}
+
+func TestOutputExecutable(t *testing.T) {
+	if _, err := exec.LookPath(ldPath); err != nil {
+		t.Skipf("no %s to link", ldPath)
+	}
+
+	var dir = writeSources(t, map[string]string{
+		"m.c": "int f();\nint main() { return f() + 1; }\n",
+		"f.c": "int f() { return 41; }\n",
+	})
+	if _, stderr, status := runSc(t, dir, "-c", "f.c"); status != 0 {
+		t.Fatalf("sc -c f.c should succeed: %s", stderr)
+	}
+	if _, stderr, status := runSc(t, dir, "m.c", "f.o"); status != 0 {
+		t.Fatalf("sc m.c f.o should succeed: %s", stderr)
+	}
+	if _, stderr, status := runSc(t, dir, "-o", "prog", "m.c", "f.c"); status != 0 {
+		t.Fatalf("sc -o prog m.c f.c should succeed: %s", stderr)
+	}
+
+	for _, exe := range []string{"a.out", "prog"} {
+		var err = exec.Command(filepath.Join(dir, exe)).Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 42 {
+			t.Errorf("%s should exit with 42, got %v", exe, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "m.o")); err == nil {
+		t.Errorf("objects of linking should not be left")
+	}
+}
+
+func TestStopAfter(t *testing.T) {
+	var sources = map[string]string{
+		"ok.c":    "int main() { return 0; }\n",
+		"lex.c":   "int main() { return 'ab'; }\n",
+		"parse.c": "int main() { return 0 +; }\n",
+		"sema.c":  "int main() { return x; }\n",
+		"cg.c": "struct p { int a; };\n" +
+			"int f(int n, ...) { __builtin_va_list ap; __builtin_va_start(ap, n); " +
+			"struct p v = __builtin_va_arg(ap, struct p); return v.a; }\n" +
+			"int main() { return 0; }\n",
+	}
+	// the stage each source fails in
+	var failIn = map[string]int{"ok.c": 4, "lex.c": 0, "parse.c": 1, "sema.c": 2, "cg.c": 3}
+	var stages = []struct {
+		args  []string
+		stage int
+	}{
+		{[]string{"-stop-after=lex"}, 0},
+		{[]string{"-stop-after", "parse"}, 1},
+		{[]string{"-stop-after=sema"}, 2},
+		{[]string{"-fsyntax-only"}, 2},
+		{[]string{"-stop-after=codegen"}, 3},
+		{[]string{"-c"}, 3},
+	}
+
+	var dir = writeSources(t, sources)
+	for _, st := range stages {
+		for src, stage := range failIn {
+			var args = append(append([]string{}, st.args...), src)
+			var _, stderr, status = runSc(t, dir, args...)
+			switch {
+			case stage <= st.stage && status == 0:
+				t.Errorf("sc %v should fail", args)
+			case stage <= st.stage && !strings.Contains(stderr, "error:"):
+				t.Errorf("sc %v should report the error", args)
+			case stage > st.stage && (status != 0 || stderr != ""):
+				t.Errorf("sc %v should stop before the error, got %d: %s", args, status, stderr)
+			}
+		}
+
+		// nothing is written by checks
+		var files, _ = filepath.Glob(filepath.Join(dir, "*"))
+		if len(files) != len(sources) && st.args[0] != "-c" {
+			t.Errorf("sc %v should write nothing, got %v", st.args, files)
+		}
+	}
+
+	// tokens are dumped without parsing, the ast without checking
+	if stdout, _, _ := runSc(t, dir, "-stop-after=lex", "-dump-tokens", "sema.c"); !strings.Contains(stdout, "IDENTIFIER(x)") {
+		t.Errorf("tokens should be dumped by -stop-after=lex, got %s", stdout)
+	}
+	if _, stderr, status := runSc(t, dir, "-stop-after=parse", "-dump-ast", "sema.c"); status != 0 || stderr != "" {
+		t.Errorf("sema.c should parse, got %d: %s", status, stderr)
+	}
+
+	// checks need no target machine
+	if _, stderr, status := runSc(t, dir, "-fsyntax-only", "-target", "nonexistent-unknown-none", "ok.c"); status != 0 {
+		t.Errorf("-fsyntax-only should need no target, got %d: %s", status, stderr)
+	}
+	if _, _, status := runSc(t, dir, "-target", "nonexistent-unknown-none", "-c", "ok.c"); status == 0 {
+		t.Errorf("unknown target should fail code generation")
+	}
+
+	if _, stderr, status := runSc(t, dir, "-stop-after=link", "ok.c"); status == 0 || !strings.Contains(stderr, "invalid stage") {
+		t.Errorf("-stop-after=link should be rejected, got %d: %s", status, stderr)
+	}
+
+	// the status is of all inputs
+	if _, _, status := runSc(t, dir, "-fsyntax-only", "ok.c", "sema.c", "ok.c"); status == 0 {
+		t.Errorf("an error of any input should fail")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	var dir = writeSources(t, map[string]string{
+		"loop.c": "int printf(const char *fmt, ...);\n" +
+			"int f(int x) { while (x) return x; }\n" +
+			"int main(void) { printf(\"started\"); for (;;) ; }\n",
+	})
+	var stdout, stderr, status = runSc(t, dir, "-run-timeout", "500ms", "-fdiagnostics-format=json", "-run", "loop.c")
+	if status != timeoutStatus {
+		t.Errorf("a program of -run killed should exit with %d, got %d", timeoutStatus, status)
+	}
+	if stdout != "started" {
+		t.Errorf("output of a program killed should be flushed, got %q", stdout)
+	}
+	if !strings.Contains(stderr, "program killed") || !strings.Contains(stderr, `"id": "codegen.return-type"`) {
+		t.Errorf("reports collected should be written when a program is killed, got %s", stderr)
+	}
+}
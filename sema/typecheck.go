@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/yanhao/sc/ast"
 	"github.com/yanhao/sc/lexer"
@@ -16,14 +17,77 @@ var (
 	err3      = "field '%s' has incomplete type '%s'"
 	err4      = "field '%s' has incomplete type '%s' (aka '%s')"
 	err5      = "no member named '%s' in '%s'"
+	err6      = "%s; did you mean '%s'?"
+	err7      = "member reference base type '%v' is not a structure or union"
 	Reports   []*ast.Report
-	addReport = func(kd ast.ReportKind, tk lexer.Token, desc string) {
-		Reports = append(Reports, &ast.Report{kd, tk, desc})
+	addReport = func(kd ast.ReportKind, id string, tk lexer.Token, desc string) *ast.Report {
+		var r = ast.MakeReport(kd, tk, desc)
+		r.ID = id
+		Reports = append(Reports, r)
+		return r
 	}
 
 	isFuncCall = false
 )
 
+// the number of single byte edits turning a into b
+func editDistance(a, b string) int {
+	var prev, cur = make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur[j] = prev[j-1]
+			if a[i-1] != b[j-1] {
+				cur[j]++
+			}
+			if cur[j] > prev[j]+1 {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j] > cur[j-1]+1 {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// an object or function visible at tok and declared before it, whose name is
+// close enough to name to be a typo of it
+func suggestSymbol(scope *ast.SymbolScope, name string, tok lexer.Token) *ast.Symbol {
+	var best *ast.Symbol
+	var bestDist = len(name)/3 + 1
+	for ; scope != nil; scope = scope.Parent {
+		for _, sym := range scope.Symbols {
+			var at = sym.Name.Location
+			switch {
+			case sym.NS != ast.OrdinaryNS, sym.Storage == ast.Typedef, strings.HasPrefix(sym.Name.AsString(), "!"):
+			case at.Line > tok.Line, at.Line == tok.Line && at.Column >= tok.Column:
+			default:
+				if d := editDistance(name, sym.Name.AsString()); d < bestDist {
+					best, bestDist = sym, d
+				}
+			}
+		}
+	}
+	return best
+}
+
+// report e by msg, which suggests the symbol e may be a typo of
+func reportUndeclared(id, msg string, e *ast.DeclRefExpr, scope *ast.SymbolScope) {
+	if sym := suggestSymbol(scope, e.Name, e.Start); sym != nil {
+		var name = sym.Name.AsString()
+		addReport(ast.Error, id, e.Start, fmt.Sprintf(err6, msg, name)).
+			AddFixIt(e.Start, name, false).
+			AddNote(sym.Name, fmt.Sprintf("'%s' declared here", name))
+		return
+	}
+	addReport(ast.Error, id, e.Start, msg)
+}
+
 // 1. type loop
 func MakeCheckLoop() ast.AstWalker {
 	type Node struct {
@@ -60,7 +124,7 @@ func MakeCheckLoop() ast.AstWalker {
 		var dfs = func(u ast.SymbolType) {
 			for v := g.nodes[u].Next; v != nil; v = v.Next {
 				if visited[v.st] && v.Ref > 0 {
-					addReport(ast.Error, v.Start, fmt.Sprintf(err3, v.Start.AsString(), v.st))
+					addReport(ast.Error, "sema.incomplete-field", v.Start, fmt.Sprintf(err3, v.Start.AsString(), v.st))
 					v.Ref--
 				}
 			}
@@ -123,6 +187,7 @@ func MakeCheckLoop() ast.AstWalker {
 func MakeCheckTypes() ast.AstWalker {
 	var CheckTypes struct {
 		WalkIntLiteralExpr       func(ws ast.WalkStage, e *ast.IntLiteralExpr, ctx *ast.WalkContext)
+		WalkFloatLiteralExpr     func(ws ast.WalkStage, e *ast.FloatLiteralExpr, ctx *ast.WalkContext)
 		WalkCharLiteralExpr      func(ws ast.WalkStage, e *ast.CharLiteralExpr, ctx *ast.WalkContext)
 		WalkStringLiteralExpr    func(ws ast.WalkStage, e *ast.StringLiteralExpr, ctx *ast.WalkContext)
 		WalkBinaryOperation      func(ws ast.WalkStage, e *ast.BinaryOperation, ctx *ast.WalkContext)
@@ -133,6 +198,7 @@ func MakeCheckTypes() ast.AstWalker {
 		WalkArraySubscriptExpr   func(ws ast.WalkStage, e *ast.ArraySubscriptExpr, ctx *ast.WalkContext)
 		WalkMemberExpr           func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool
 		WalkFunctionCall         func(ws ast.WalkStage, e *ast.FunctionCall, ctx *ast.WalkContext)
+		WalkBuiltinCallExpr      func(ws ast.WalkStage, e *ast.BuiltinCallExpr, ctx *ast.WalkContext)
 		WalkCompoundAssignExpr   func(ws ast.WalkStage, e *ast.CompoundAssignExpr, ctx *ast.WalkContext)
 		WalkCastExpr             func(ws ast.WalkStage, e *ast.CastExpr, ctx *ast.WalkContext)
 		WalkCompoundLiteralExpr  func(ws ast.WalkStage, e *ast.CompoundLiteralExpr, ctx *ast.WalkContext)
@@ -141,10 +207,25 @@ func MakeCheckTypes() ast.AstWalker {
 		WalkVariableDecl         func(ws ast.WalkStage, e *ast.VariableDecl, ctx *ast.WalkContext)
 		WalkFunctionDecl         func(ws ast.WalkStage, e *ast.FunctionDecl, ctx *ast.WalkContext)
 		WalkReturnStmt           func(ws ast.WalkStage, e *ast.ReturnStmt, ctx *ast.WalkContext)
+		WalkSwitchStmt           func(ws ast.WalkStage, e *ast.SwitchStmt, ctx *ast.WalkContext)
+		WalkCaseStmt             func(ws ast.WalkStage, e *ast.CaseStmt, ctx *ast.WalkContext) bool
+		WalkDefaultStmt          func(ws ast.WalkStage, e *ast.DefaultStmt, ctx *ast.WalkContext)
+		WalkEnumDecl             func(ws ast.WalkStage, e *ast.EnumDecl, ctx *ast.WalkContext)
+		WalkEnumeratorDecl       func(ws ast.WalkStage, e *ast.EnumeratorDecl, ctx *ast.WalkContext)
+	}
+
+	// labels of a switch statement being checked
+	type switchLabels struct {
+		stmt       *ast.SwitchStmt
+		promoted   bool                  // if condition has been promoted
+		cases      map[int64]lexer.Token // labels by their values
+		hasDefault bool
 	}
 
 	var info struct {
-		LastFunction *ast.Symbol
+		LastFunction   *ast.Symbol
+		Switches       []*switchLabels // enclosing switch statements, innermost last
+		NextEnumerator int64           // value of next enumerator without initializer
 	}
 
 	// typedef's are handled elsewhere
@@ -166,35 +247,65 @@ func MakeCheckTypes() ast.AstWalker {
 		return expr
 	}
 
+	// an integer constant expression with the value 0 (C99 6.3.2.3)
+	var isNullPointerConstant = func(e ast.Expression) bool {
+	done:
+		for {
+			switch e.(type) {
+			case *ast.ImplicitCastExpr:
+				e = e.(*ast.ImplicitCastExpr).Expr
+			case *ast.CastExpr:
+				e = e.(*ast.CastExpr).Expr
+			default:
+				break done
+			}
+		}
+
+		if lit, yes := e.(*ast.IntLiteralExpr); yes {
+			var v, ok = lit.Tok.AsUint64()
+			return ok && v == 0
+		}
+		return false
+	}
+
 	var tryImplicitCast = func(expr ast.Expression, destType ast.SymbolType, nd *ast.Node) ast.Expression {
 		var ty = expr.GetType()
+		var kind ast.CastKind
 		// do conversion
 		switch ty.(type) {
-		case *ast.VoidType:
 		case *ast.IntegerType:
-			var e = &ast.ImplicitCastExpr{*nd, ast.IntegralCast, destType, expr}
-			e.InferedType = e.DestType
-			expr = e
+			kind = ast.IntegralCast
+			if ast.IsFloatingType(destType) {
+				kind = ast.IntegralToFloatingCast
+			} else if _, yes := destType.(*ast.Pointer); yes && isNullPointerConstant(expr) {
+				kind = ast.NullToPointerCast
+			}
 
 		case *ast.FloatType, *ast.DoubleType:
-			var e = &ast.ImplicitCastExpr{*nd, ast.FloatingToIntegralCast, destType, expr}
-			e.InferedType = e.DestType
-			expr = e
+			kind = ast.FloatingToIntegralCast
+			if ast.IsFloatingType(destType) {
+				kind = ast.FloatingCast
+			}
 
-		case *ast.Array:
-			var e = &ast.ImplicitCastExpr{*nd, ast.ArrayToPointerDecay, destType, expr}
-			e.InferedType = e.DestType
-			expr = e
+		case *ast.Pointer:
+			if _, yes := destType.(*ast.Pointer); !yes {
+				return expr
+			}
+			kind = ast.BitCast
+
+		case *ast.Array, *ast.StringType:
+			kind = ast.ArrayToPointerDecay
 
 		case *ast.Function:
-			var e = &ast.ImplicitCastExpr{*nd, ast.FunctionToPointerDecay, destType, expr}
-			e.InferedType = e.DestType
-			expr = e
+			kind = ast.FunctionToPointerDecay
 
 		default:
+			return expr
 		}
-		return expr
 
+		var e = &ast.ImplicitCastExpr{*nd, kind, destType, expr}
+		e.InferedType = e.DestType
+		return e
 	}
 
 	var unifyType = func(type1, type2 ast.SymbolType) (unified_ty ast.SymbolType, unified bool) {
@@ -228,31 +339,34 @@ func MakeCheckTypes() ast.AstWalker {
 		} else if t1.Name() == "IntegerType" && t2.Name() == "IntegerType" {
 			var i1, i2 = type1.(*ast.IntegerType), type2.(*ast.IntegerType)
 
-			// this is not the rank of C type, but more like privileges
-			var ranks = map[string]int{
-				"unsigned long long": 10,
-				"long long":          9,
-				"unsigned long":      8,
-				"long":               7,
-				"unsigned int":       6,
-				"int":                5,
-				// these below won't be used due to promotion
-				"unsigned short": 4,
-				"short":          3,
-				"unsigned char":  2,
-				"char":           1,
-			}
-
-			if i1.Kind == i2.Kind && i1.Unsigned == i2.Unsigned {
+			// integer conversion rank (C99 6.3.1.1), widths are of the target
+			var ranks = map[string]int{"char": 1, "short": 2, "int": 3, "long": 4, "long long": 5}
+
+			switch {
+			case i1.Kind == i2.Kind && i1.Unsigned == i2.Unsigned:
 				unified_ty = type1
 
-			} else {
-				var ts1, ts2 = i1.String(), i2.String()
-				if ranks[ts1] > ranks[ts2] {
+			case i1.Unsigned == i2.Unsigned:
+				if ranks[i1.Kind] > ranks[i2.Kind] {
 					unified_ty = type1
-				} else if ranks[ts1] < ranks[ts2] {
+				} else {
 					unified_ty = type2
 				}
+
+			default:
+				var u, s = i1, i2
+				if i2.Unsigned {
+					u, s = i2, i1
+				}
+
+				if ranks[u.Kind] >= ranks[s.Kind] {
+					unified_ty = u
+				} else if ast.Target.Width(s.Kind) > ast.Target.Width(u.Kind) {
+					// signed type can represent all values of the unsigned one
+					unified_ty = s
+				} else {
+					unified_ty = &ast.IntegerType{true, s.Kind}
+				}
 			}
 
 		} else {
@@ -290,7 +404,7 @@ func MakeCheckTypes() ast.AstWalker {
 		var t1, t2 = reflect.TypeOf(lty).Elem(), reflect.TypeOf(rty).Elem()
 		if t1.Name() == "Array" {
 			var aty = lty.(*ast.Array)
-			var dty = &ast.Pointer{aty.ElemType}
+			var dty = &ast.Pointer{aty.Elem()}
 			bop.LHS = tryImplicitCast(bop.LHS, dty, &bop.Node)
 			lty = dty
 			t1 = reflect.TypeOf(lty).Elem()
@@ -298,7 +412,7 @@ func MakeCheckTypes() ast.AstWalker {
 
 		if t2.Name() == "Array" {
 			var aty = rty.(*ast.Array)
-			var dty = &ast.Pointer{aty.ElemType}
+			var dty = &ast.Pointer{aty.Elem()}
 			bop.RHS = tryImplicitCast(bop.RHS, dty, &bop.Node)
 			rty = dty
 			t2 = reflect.TypeOf(rty).Elem()
@@ -310,8 +424,8 @@ func MakeCheckTypes() ast.AstWalker {
 					panic("invalid operands to pointer arithmetic")
 				}
 
-				//else, pointer subtraction results into a ptrdiff_t (signed int) type
-				bop.InferedType = &ast.IntegerType{false, "int"}
+				//else, pointer subtraction results into a ptrdiff_t (long on x86-64) type
+				bop.InferedType = &ast.IntegerType{false, ast.Target.IntPtr()}
 				if !ast.IsTypeEq(lty, rty) {
 					panic(fmt.Sprintf("'%s' and '%s' are not pointers to compatible types", lty, rty))
 				}
@@ -330,6 +444,46 @@ func MakeCheckTypes() ast.AstWalker {
 		return false
 	}
 
+	// relational and equality operators on pointers (C99 6.5.8, 6.5.9)
+	var checkPointerComparison = func(bop *ast.BinaryOperation) bool {
+		var lty, rty = bop.LHS.GetType(), bop.RHS.GetType()
+		var lpty, lptr = lty.(*ast.Pointer)
+		var rpty, rptr = rty.(*ast.Pointer)
+		if !lptr && !rptr {
+			return false
+		}
+
+		var equality = bop.Op == lexer.EQUAL || bop.Op == lexer.NE
+		switch {
+		case lptr && rptr:
+			if ast.IsTypeEq(lty, rty) {
+				break
+			}
+
+			_, lvoid := lpty.Source.(*ast.VoidType)
+			_, rvoid := rpty.Source.(*ast.VoidType)
+			if equality && lvoid {
+				bop.RHS = tryImplicitCast(bop.RHS, lty, &bop.Node)
+			} else if equality && rvoid {
+				bop.LHS = tryImplicitCast(bop.LHS, rty, &bop.Node)
+			} else {
+				panic(fmt.Sprintf("comparison of distinct pointer types ('%s' and '%s')", lty, rty))
+			}
+
+		case lptr && isNullPointerConstant(bop.RHS):
+			bop.RHS = tryImplicitCast(bop.RHS, lty, &bop.Node)
+
+		case rptr && isNullPointerConstant(bop.LHS):
+			bop.LHS = tryImplicitCast(bop.LHS, rty, &bop.Node)
+
+		default:
+			panic(fmt.Sprintf("comparison between pointer and integer ('%s' and '%s')", lty, rty))
+		}
+
+		bop.InferedType = &ast.IntegerType{false, "int"}
+		return true
+	}
+
 	var functionOrArrayConversion = func(e ast.Expression, node *ast.Node) ast.Expression {
 		var ty = e.GetType()
 		switch ty.(type) {
@@ -337,24 +491,525 @@ func MakeCheckTypes() ast.AstWalker {
 			ty = &ast.Pointer{ty.(*ast.Function)}
 			e = tryImplicitCast(e, ty, node)
 		case *ast.Array:
-			ty = &ast.Pointer{ty.(*ast.Array).ElemType}
+			ty = &ast.Pointer{ty.(*ast.Array).Elem()}
+			e = tryImplicitCast(e, ty, node)
+		case *ast.StringType:
+			ty = &ast.Pointer{&ast.IntegerType{false, "char"}}
 			e = tryImplicitCast(e, ty, node)
 		}
 
 		return e
 	}
 
+	// a parameter of array type is adjusted to pointer to its element type,
+	// and one of function type to pointer to function
+	var adjustParams = func(fty *ast.Function) {
+		for i, arg := range fty.Args {
+			switch arg.(type) {
+			case *ast.Array:
+				fty.Args[i] = &ast.Pointer{arg.(*ast.Array).Elem()}
+			case *ast.Function:
+				fty.Args[i] = &ast.Pointer{arg}
+			}
+		}
+	}
+
+	// a char array may be initialized by a string literal, optionally
+	// enclosed in braces. the literal is then typed as the array, which it
+	// fills up to the length of array
+	var stringInit = func(ty ast.SymbolType, init ast.Expression) *ast.StringLiteralExpr {
+		var aty, yes = ty.(*ast.Array)
+		if !yes || aty.Level != 1 {
+			return nil
+		}
+		var elemTy, _ = ast.Unqualify(aty.ElemType)
+		if ity, yes := elemTy.(*ast.IntegerType); !yes || ity.Kind != "char" {
+			return nil
+		}
+		if list, yes := init.(*ast.InitListExpr); yes && len(list.Inits) == 1 {
+			init = list.Inits[0]
+		}
+		if str, yes := init.(*ast.StringLiteralExpr); yes {
+			if n := aty.Len(); n >= 0 && len(str.Tok.AsString()) > n {
+				addReport(ast.Error, "sema.initializer-string-too-long", str.Start, "initializer-string for char array is too long")
+			}
+			str.InferedType = aty
+			return str
+		}
+		return nil
+	}
+
+	// complete an array of unknown size with the number of its initializers
+	var completeArray = func(aty *ast.Array, n int, node *ast.Node) *ast.Array {
+		if aty.Len() >= 0 {
+			return aty
+		}
+		var length = &ast.IntLiteralExpr{Node: *node,
+			Tok: lexer.MakeToken(lexer.INT_LITERAL, fmt.Sprintf("%d", n))}
+		var lens = append([]ast.Expression{length}, aty.LenExprs[1:]...)
+		return &ast.Array{aty.ElemType, aty.Level, lens}
+	}
+
+	// consume initializers from inits starting at *pos for an aggregate of
+	// type ty, and return them as a fully braced list. inner braces may be
+	// omitted, in which case the subaggregate takes as many as it needs.
+	// only the first member of a union is initialized.
+	var braceInit func(ty ast.SymbolType, inits []ast.Expression, pos *int, node *ast.Node) *ast.InitListExpr
+	var braceElement func(ety ast.SymbolType, inits []ast.Expression, pos *int, node *ast.Node) ast.Expression
+
+	braceInit = func(ty ast.SymbolType, inits []ast.Expression, pos *int, node *ast.Node) *ast.InitListExpr {
+		var list = &ast.InitListExpr{Node: *node}
+		var n int
+		var elemAt func(i int) ast.SymbolType
+
+		switch ty := ty.(type) {
+		case *ast.Array:
+			n = ty.Len()
+			elemAt = func(int) ast.SymbolType { return ty.Elem() }
+		case *ast.RecordType:
+			n = len(ty.Fields)
+			if ty.Union && n > 1 {
+				n = 1
+			}
+			elemAt = func(i int) ast.SymbolType { return ty.Fields[i].Base }
+		}
+
+		for *pos < len(inits) && (n < 0 || len(list.Inits) < n) {
+			list.Inits = append(list.Inits, braceElement(elemAt(len(list.Inits)), inits, pos, node))
+		}
+
+		list.InferedType = ty
+		return list
+	}
+
+	braceElement = func(ety ast.SymbolType, inits []ast.Expression, pos *int, node *ast.Node) ast.Expression {
+		var init = inits[*pos]
+		ety, _ = ast.Unqualify(ety)
+		switch ety.(type) {
+		case *ast.Array, *ast.RecordType:
+			if str := stringInit(ety, init); str != nil {
+				*pos++
+				return str
+			}
+			if sub, yes := init.(*ast.InitListExpr); yes {
+				var i = 0
+				var list = braceInit(ety, sub.Inits, &i, &sub.Node)
+				if i < len(sub.Inits) {
+					panic("excess elements in initializer")
+				}
+				*pos++
+				return list
+			}
+			// a struct may also be initialized by an expression of its type
+			if rty, yes := init.GetType().(*ast.RecordType); yes && rty == ety {
+				*pos++
+				return init
+			}
+			return braceInit(ety, inits, pos, node)
+
+		default:
+			if sub, yes := init.(*ast.InitListExpr); yes {
+				if len(sub.Inits) != 1 {
+					panic("excess elements in scalar initializer")
+				}
+				init = sub.Inits[0]
+			}
+			init = functionOrArrayConversion(init, node)
+			if !ast.IsTypeEq(ety, init.GetType()) {
+				init = tryImplicitCast(init, ety, node)
+			}
+			*pos++
+			return init
+		}
+	}
+
+	// objects declared at file scope or with `static` or `extern` live
+	// throughout the program, as do functions
+	var hasStaticStorage = func(sym *ast.Symbol, actx *ast.AstContext) bool {
+		if sym.Storage == ast.Static || sym.Storage == ast.External {
+			return true
+		}
+		if _, yes := sym.Type.(*ast.Function); yes {
+			return true
+		}
+		return sym == actx.Top.LookupSymbol(sym.Name.AsString(), ast.OrdinaryNS)
+	}
+
+	// objects and functions defined in this translation unit
+	var defined = make(map[*ast.Symbol]lexer.Token)
+	var checkRedefinition = func(sym *ast.Symbol, tok lexer.Token) {
+		if prev, yes := defined[sym]; yes {
+			addReport(ast.Error, "sema.redefinition", tok, fmt.Sprintf("redefinition of '%s'", sym.Name.AsString())).
+				AddNote(prev, "previous definition is here")
+			return
+		}
+		defined[sym] = tok
+	}
+
+	// whether e designates an object with static storage or a function, whose
+	// address is then an address constant
+	var isStaticLValue func(e ast.Expression, at lexer.Token, scope *ast.SymbolScope) (lexer.Token, bool)
+
+	// whether e can initialize an object with static storage, that is an
+	// arithmetic constant expression, or an address constant optionally plus
+	// or minus an integer constant expression (C99 6.6). if not, the position
+	// of the first offending subexpression is returned.
+	var isConstantInit func(e ast.Expression, at lexer.Token, scope *ast.SymbolScope) (lexer.Token, bool)
+
+	// evaluate integer constant expression e (C99 6.6), which has been typed.
+	// if e is not one, the position of the first offending subexpression is
+	// returned.
+	var evalIntConst func(e ast.Expression, at lexer.Token, scope *ast.SymbolScope) (int64, lexer.Token, bool)
+
+	isStaticLValue = func(e ast.Expression, at lexer.Token, scope *ast.SymbolScope) (lexer.Token, bool) {
+		switch e := e.(type) {
+		case *ast.DeclRefExpr:
+			var sym = scope.LookupSymbol(e.Name, ast.OrdinaryNS)
+			if sym == nil {
+				return e.Start, false
+			}
+			if _, yes := sym.Type.(*ast.EnumeratorType); yes {
+				return e.Start, false
+			}
+			return e.Start, hasStaticStorage(sym, e.Ctx)
+
+		case *ast.StringLiteralExpr:
+			return e.Start, true
+
+		case *ast.ArraySubscriptExpr:
+			var tok, ok = e.Start, false
+			if _, yes := e.Target.GetType().(*ast.Array); yes {
+				tok, ok = isStaticLValue(e.Target, e.Start, scope)
+			} else {
+				tok, ok = isConstantInit(e.Target, e.Start, scope)
+			}
+			if !ok {
+				return tok, ok
+			}
+			return isConstantInit(e.Sub, e.Start, scope)
+
+		case *ast.MemberExpr:
+			if e.PointerDeref {
+				return isConstantInit(e.Target, e.Start, scope)
+			}
+			return isStaticLValue(e.Target, e.Start, scope)
+
+		case *ast.UnaryOperation:
+			if e.Op == lexer.MUL {
+				return isConstantInit(e.Expr, e.Start, scope)
+			}
+			return e.Start, false
+		}
+
+		return at, false
+	}
+
+	isConstantInit = func(e ast.Expression, at lexer.Token, scope *ast.SymbolScope) (lexer.Token, bool) {
+		switch e := e.(type) {
+		case *ast.IntLiteralExpr, *ast.FloatLiteralExpr, *ast.CharLiteralExpr, *ast.SizeofExpr:
+			return at, true
+
+		case *ast.StringLiteralExpr:
+			return e.Start, true
+
+		case *ast.DeclRefExpr:
+			var _, _, yes = evalIntConst(e, e.Start, scope)
+			return e.Start, yes
+
+		case *ast.InitListExpr:
+			for _, init := range e.Inits {
+				if tok, ok := isConstantInit(init, e.Start, scope); !ok {
+					return tok, ok
+				}
+			}
+			return e.Start, true
+
+		case *ast.ImplicitCastExpr:
+			switch e.CastKind {
+			case ast.ArrayToPointerDecay, ast.FunctionToPointerDecay:
+				return isStaticLValue(e.Expr, e.Start, scope)
+			}
+			return isConstantInit(e.Expr, e.Start, scope)
+
+		case *ast.CastExpr:
+			return isConstantInit(e.Expr, e.Start, scope)
+
+		case *ast.UnaryOperation:
+			switch e.Op {
+			case lexer.AND:
+				return isStaticLValue(e.Expr, e.Start, scope)
+			case lexer.INC, lexer.DEC, lexer.MUL:
+				return e.Start, false
+			}
+			return isConstantInit(e.Expr, e.Start, scope)
+
+		case *ast.BinaryOperation:
+			if e.Op == lexer.COMMA || (e.Op >= lexer.ASSIGN && e.Op <= lexer.OR_ASSIGN) {
+				return e.Start, false
+			}
+			if tok, ok := isConstantInit(e.LHS, e.Start, scope); !ok {
+				return tok, ok
+			}
+			return isConstantInit(e.RHS, e.Start, scope)
+
+		case *ast.ConditionalOperation:
+			for _, sub := range []ast.Expression{e.Cond, e.True, e.False} {
+				if tok, ok := isConstantInit(sub, e.Start, scope); !ok {
+					return tok, ok
+				}
+			}
+			return e.Start, true
+
+		case *ast.FunctionCall:
+			return e.Start, false
+		case *ast.ArraySubscriptExpr:
+			return e.Start, false
+		case *ast.MemberExpr:
+			return e.Start, false
+		}
+
+		return at, false
+	}
+
+	// wrap v into the range of integer type ty
+	var truncInt = func(v int64, ty ast.SymbolType) int64 {
+		var it, yes = ty.(*ast.IntegerType)
+		if !yes {
+			return v
+		}
+
+		var bits = uint(ast.Target.Width(it.Kind))
+		if bits >= 64 {
+			return v
+		}
+
+		if it.Unsigned {
+			return int64(uint64(v) & (1<<bits - 1))
+		}
+		return v << (64 - bits) >> (64 - bits)
+	}
+
+	evalIntConst = func(e ast.Expression, at lexer.Token, scope *ast.SymbolScope) (int64, lexer.Token, bool) {
+		switch e := e.(type) {
+		case *ast.IntLiteralExpr:
+			// a literal too large for any type has been reported
+			var v, _ = e.Tok.AsUint64()
+			return truncInt(int64(v), e.GetType()), e.Start, true
+
+		case *ast.CharLiteralExpr:
+			return truncInt(int64(e.Tok.AsChar()), e.GetType()), e.Start, true
+
+		case *ast.DeclRefExpr:
+			var sym = scope.LookupSymbol(e.Name, ast.OrdinaryNS)
+			if sym != nil {
+				if et, yes := sym.Type.(*ast.EnumeratorType); yes {
+					return et.Value, e.Start, true
+				}
+			}
+			return 0, e.Start, false
+
+		case *ast.ImplicitCastExpr:
+			if e.CastKind != ast.IntegralCast {
+				return 0, e.Start, false
+			}
+			var v, tok, ok = evalIntConst(e.Expr, e.Start, scope)
+			return truncInt(v, e.DestType), tok, ok
+
+		case *ast.CastExpr:
+			// only casts of integers to integer types are allowed
+			if !ast.IsIntegralType(e.GetType()) || !ast.IsIntegralType(e.Expr.GetType()) {
+				return 0, e.Start, false
+			}
+			var v, tok, ok = evalIntConst(e.Expr, e.Start, scope)
+			return truncInt(v, e.GetType()), tok, ok
+
+		case *ast.UnaryOperation:
+			var v, tok, ok = evalIntConst(e.Expr, e.Start, scope)
+			if !ok {
+				return v, tok, ok
+			}
+			switch e.Op {
+			case lexer.PLUS:
+			case lexer.MINUS:
+				v = -v
+			case lexer.TILDE:
+				v = ^v
+			case lexer.NOT:
+				if v == 0 {
+					v = 1
+				} else {
+					v = 0
+				}
+			default:
+				return 0, e.Start, false
+			}
+			return truncInt(v, e.GetType()), e.Start, true
+
+		case *ast.BinaryOperation:
+			if e.Op == lexer.COMMA || (e.Op >= lexer.ASSIGN && e.Op <= lexer.OR_ASSIGN) {
+				return 0, e.Start, false
+			}
+			var l, tok, ok = evalIntConst(e.LHS, e.Start, scope)
+			if !ok {
+				return l, tok, ok
+			}
+
+			// the right operand is not evaluated if result is decided
+			if e.Op == lexer.LOG_AND && l == 0 {
+				return 0, e.Start, true
+			} else if e.Op == lexer.LOG_OR && l != 0 {
+				return 1, e.Start, true
+			}
+
+			var r int64
+			if r, tok, ok = evalIntConst(e.RHS, e.Start, scope); !ok {
+				return r, tok, ok
+			}
+
+			// operands have been converted to a common type except shifts
+			var unsigned = ast.IsUnsigned(e.LHS.GetType())
+			var ul, ur = uint64(l), uint64(r)
+			var b2i = func(b bool) int64 {
+				if b {
+					return 1
+				}
+				return 0
+			}
+
+			var v int64
+			switch e.Op {
+			case lexer.PLUS:
+				v = l + r
+			case lexer.MINUS:
+				v = l - r
+			case lexer.MUL:
+				v = l * r
+			case lexer.DIV, lexer.MOD:
+				if r == 0 {
+					return 0, e.Start, false
+				}
+				switch {
+				case unsigned && e.Op == lexer.DIV:
+					v = int64(ul / ur)
+				case unsigned:
+					v = int64(ul % ur)
+				case e.Op == lexer.DIV:
+					v = l / r
+				default:
+					v = l % r
+				}
+			case lexer.LSHIFT:
+				v = l << ur
+			case lexer.RSHIFT:
+				if unsigned {
+					v = int64(ul >> ur)
+				} else {
+					v = l >> ur
+				}
+			case lexer.AND:
+				v = l & r
+			case lexer.OR:
+				v = l | r
+			case lexer.XOR:
+				v = l ^ r
+			case lexer.LOG_AND, lexer.LOG_OR:
+				v = b2i(r != 0)
+			case lexer.EQUAL:
+				v = b2i(l == r)
+			case lexer.NE:
+				v = b2i(l != r)
+			case lexer.LESS:
+				v = b2i(unsigned && ul < ur || !unsigned && l < r)
+			case lexer.GREAT:
+				v = b2i(unsigned && ul > ur || !unsigned && l > r)
+			case lexer.LE:
+				v = b2i(unsigned && ul <= ur || !unsigned && l <= r)
+			case lexer.GE:
+				v = b2i(unsigned && ul >= ur || !unsigned && l >= r)
+			default:
+				return 0, e.Start, false
+			}
+			return truncInt(v, e.GetType()), e.Start, true
+
+		case *ast.ConditionalOperation:
+			var c, tok, ok = evalIntConst(e.Cond, e.Start, scope)
+			if !ok {
+				return c, tok, ok
+			}
+			if c != 0 {
+				return evalIntConst(e.True, e.Start, scope)
+			}
+			return evalIntConst(e.False, e.Start, scope)
+
+		case *ast.FunctionCall:
+			return 0, e.Start, false
+		case *ast.ArraySubscriptExpr:
+			return 0, e.Start, false
+		case *ast.MemberExpr:
+			return 0, e.Start, false
+		}
+
+		return 0, at, false
+	}
+
 	CheckTypes.WalkVariableDecl = func(ws ast.WalkStage, e *ast.VariableDecl, ctx *ast.WalkContext) {
 		if ws == ast.WalkerBubbleUp {
 			sym := ctx.Scope.LookupSymbol(e.Sym, ast.OrdinaryNS)
+			if fty, yes := sym.Type.(*ast.Function); yes {
+				adjustParams(fty)
+			}
 			e.InferedType = sym.Type
-			if e.Init != nil {
-				if _, yes := e.Init.(*ast.InitListExpr); !yes {
-					e.Init = functionOrArrayConversion(e.Init, &e.Node)
+			if e.Init == nil {
+				return
+			}
+
+			// qualifiers do not matter to the conversion of initializer
+			var list, isList = e.Init.(*ast.InitListExpr)
+			var uty, _ = ast.Unqualify(sym.Type)
+			switch ty := uty.(type) {
+			case *ast.Array, *ast.RecordType:
+				if str := stringInit(ty, e.Init); str != nil {
+					// the terminating null character is counted in the size
+					sym.Type = completeArray(ty.(*ast.Array), len(str.Tok.AsString())+1, &str.Node)
+					str.InferedType = sym.Type
+					e.Init = str
+				} else if isList {
+					var pos = 0
+					list = braceInit(ty, list.Inits, &pos, &list.Node)
+					if pos < len(e.Init.(*ast.InitListExpr).Inits) {
+						panic("excess elements in initializer")
+					}
 
-					if !ast.IsTypeEq(sym.Type, e.Init.GetType()) {
-						e.Init = tryImplicitCast(e.Init, sym.Type, &e.Node)
+					if aty, yes := ty.(*ast.Array); yes {
+						sym.Type = completeArray(aty, len(list.Inits), &list.Node)
+						list.InferedType = sym.Type
 					}
+					e.Init = list
+				}
+				e.InferedType = sym.Type
+
+			default:
+				if isList {
+					// a scalar may be enclosed in braces
+					if len(list.Inits) != 1 {
+						panic("excess elements in scalar initializer")
+					}
+					e.Init = list.Inits[0]
+				}
+				e.Init = functionOrArrayConversion(e.Init, &e.Node)
+
+				if !ast.IsTypeEq(uty, e.Init.GetType()) {
+					e.Init = tryImplicitCast(e.Init, uty, &e.Node)
+				}
+			}
+
+			if ctx.Scope == e.Ctx.Top {
+				checkRedefinition(sym, e.Start)
+			}
+			if hasStaticStorage(sym, e.Ctx) {
+				if tok, ok := isConstantInit(e.Init, e.Start, ctx.Scope); !ok {
+					addReport(ast.Error, "sema.non-constant-initializer", tok, "initializer element is not a compile-time constant").
+						AddRange(e.Init)
 				}
 			}
 		}
@@ -362,7 +1017,28 @@ func MakeCheckTypes() ast.AstWalker {
 
 	CheckTypes.WalkIntLiteralExpr = func(ws ast.WalkStage, e *ast.IntLiteralExpr, ctx *ast.WalkContext) {
 		if ws == ast.WalkerBubbleUp {
-			e.InferedType = &ast.IntegerType{false, "int"}
+			var ty, ok = intLiteralType(e.Tok)
+			switch {
+			case ty == nil:
+				addReport(ast.Error, "sema.integer-literal-too-large", e.Start,
+					"integer literal is too large to be represented in any integer type")
+				ty = &ast.IntegerType{true, "long long"}
+			case !ok:
+				addReport(ast.Warning, "sema.implicitly-unsigned-literal", e.Start,
+					"integer literal is too large to be represented in a signed integer type, interpreting as unsigned")
+			}
+			e.InferedType = ty
+		}
+	}
+	CheckTypes.WalkFloatLiteralExpr = func(ws ast.WalkStage, e *ast.FloatLiteralExpr, ctx *ast.WalkContext) {
+		if ws == ast.WalkerBubbleUp {
+			//NOTE: long double is taken as double
+			var s = e.Tok.AsString()
+			if s[len(s)-1] == 'f' || s[len(s)-1] == 'F' {
+				e.InferedType = &ast.FloatType{}
+			} else {
+				e.InferedType = &ast.DoubleType{}
+			}
 		}
 	}
 	CheckTypes.WalkCharLiteralExpr = func(ws ast.WalkStage, e *ast.CharLiteralExpr, ctx *ast.WalkContext) {
@@ -387,7 +1063,8 @@ func MakeCheckTypes() ast.AstWalker {
 				}
 
 			} else if e.Op == lexer.LOG_OR || e.Op == lexer.LOG_AND {
-				e.InferedType = e.LHS.GetType()
+				e.InferedType = &ast.IntegerType{false, "int"}
+				e.LHS = functionOrArrayConversion(e.LHS, &e.Node)
 				e.RHS = functionOrArrayConversion(e.RHS, &e.Node)
 
 			} else if e.Op == lexer.COMMA {
@@ -399,6 +1076,12 @@ func MakeCheckTypes() ast.AstWalker {
 				e.RHS = promoteNode(e.RHS, &e.Node)
 				e.InferedType = e.LHS.GetType()
 
+				// type of result is that of the promoted left operand, the right
+				// operand is converted only to make operands of same width
+				if !ast.IsTypeEq(e.LHS.GetType(), e.RHS.GetType()) {
+					e.RHS = tryImplicitCast(e.RHS, e.LHS.GetType(), &e.Node)
+				}
+
 			} else {
 				e.LHS = functionOrArrayConversion(e.LHS, &e.Node)
 				e.RHS = functionOrArrayConversion(e.RHS, &e.Node)
@@ -406,6 +1089,13 @@ func MakeCheckTypes() ast.AstWalker {
 				if (e.Op == lexer.PLUS || e.Op == lexer.MINUS) && checkPointerArithmetic(e) {
 					return
 				}
+
+				switch e.Op {
+				case lexer.GREAT, lexer.GE, lexer.LESS, lexer.LE, lexer.EQUAL, lexer.NE:
+					if checkPointerComparison(e) {
+						return
+					}
+				}
 				e.LHS = promoteNode(e.LHS, &e.Node)
 				e.RHS = promoteNode(e.RHS, &e.Node)
 
@@ -414,6 +1104,11 @@ func MakeCheckTypes() ast.AstWalker {
 				util.Printf(util.Sema, util.Debug, "unified ty %v", ty)
 				e.InferedType = ty
 
+				switch e.Op {
+				case lexer.GREAT, lexer.GE, lexer.LESS, lexer.LE, lexer.EQUAL, lexer.NE:
+					// operands are compared in unified type, but result is int
+					e.InferedType = &ast.IntegerType{false, "int"}
+				}
 			}
 		}
 	}
@@ -423,7 +1118,14 @@ func MakeCheckTypes() ast.AstWalker {
 			// elsewhere. e.g MemberExpr
 			util.Printf(util.Sema, util.Debug, "lookup %s", e.Name)
 			var sym = ctx.Scope.LookupSymbol(e.Name, ast.OrdinaryNS)
-			e.InferedType = sym.Type
+
+			// the value of an object is of the unqualified type, qualifiers
+			// are found from declarations for the object itself
+			e.InferedType, _ = ast.Unqualify(sym.Type)
+			if _, yes := sym.Type.(*ast.EnumeratorType); yes {
+				// an enumeration constant has type int
+				e.InferedType = &ast.IntegerType{false, "int"}
+			}
 		}
 	}
 	CheckTypes.WalkUnaryOperation = func(ws ast.WalkStage, e *ast.UnaryOperation, ctx *ast.WalkContext) {
@@ -432,13 +1134,18 @@ func MakeCheckTypes() ast.AstWalker {
 			case lexer.INC, lexer.DEC:
 				e.InferedType = e.Expr.GetType()
 
-			case lexer.MINUS, lexer.PLUS:
-				e.InferedType = e.Expr.GetType()
+			case lexer.MINUS, lexer.PLUS, lexer.TILDE:
 				ast.TypeAssertCompat(e.Expr.GetType(), &ast.IntegerType{}, "types are uncompatible")
+				e.Expr = promoteNode(e.Expr, &e.Node)
+				e.InferedType = e.Expr.GetType()
 
-			case lexer.NOT, lexer.TILDE:
+			case lexer.NOT:
 				e.InferedType = &ast.IntegerType{false, "int"}
-				ast.TypeAssertCompat(e.Expr.GetType(), &ast.IntegerType{}, "types are uncompatible")
+				e.Expr = functionOrArrayConversion(e.Expr, &e.Node)
+				// operand of ! only needs to be scalar
+				if _, ok := e.Expr.GetType().(*ast.Pointer); !ok {
+					ast.TypeAssertCompat(e.Expr.GetType(), &ast.IntegerType{}, "types are uncompatible")
+				}
 
 			case lexer.AND:
 				e.InferedType = &ast.Pointer{e.Expr.GetType()}
@@ -450,7 +1157,7 @@ func MakeCheckTypes() ast.AstWalker {
 				if _, ok := ty.(*ast.Pointer); !ok {
 					panic("indirection requires pointer operand")
 				} else {
-					e.InferedType = ty.(*ast.Pointer).Source
+					e.InferedType, _ = ast.Unqualify(ty.(*ast.Pointer).Source)
 				}
 
 			default:
@@ -470,18 +1177,66 @@ func MakeCheckTypes() ast.AstWalker {
 			e.True = functionOrArrayConversion(e.True, &e.Node)
 			e.False = functionOrArrayConversion(e.False, &e.Node)
 
-			e.InferedType = e.True.GetType()
-			ast.TypeAssertEq(e.False.GetType(), e.True.GetType(), "conditional type mismatch")
+			var lty, rty = e.True.GetType(), e.False.GetType()
+			var lpty, lptr = lty.(*ast.Pointer)
+			var rpty, rptr = rty.(*ast.Pointer)
+			var isArith = func(ty ast.SymbolType) bool {
+				return ast.IsIntegralType(ty) || ast.IsFloatingType(ty)
+			}
+
+			// result type by C99 6.5.15
+			var ty ast.SymbolType
+			switch {
+			case isArith(lty) && isArith(rty):
+				e.True = promoteNode(e.True, &e.Node)
+				e.False = promoteNode(e.False, &e.Node)
+				e.True, e.False, ty = usualArithmeticConversion(e.Node, lexer.QUEST, e.True, e.False)
+
+			case lptr && isNullPointerConstant(e.False):
+				ty = lty
+
+			case rptr && isNullPointerConstant(e.True):
+				ty = rty
+
+			case lptr && rptr:
+				if _, yes := lpty.Source.(*ast.VoidType); yes {
+					ty = lty
+				} else if _, yes := rpty.Source.(*ast.VoidType); yes {
+					ty = rty
+				} else if ast.IsTypeCompat(lpty.Source, rpty.Source) {
+					ty = lty
+				} else {
+					panic("pointer type mismatch in conditional expression")
+				}
+
+			case ast.IsTypeEq(lty, rty):
+				// void, records
+				ty = lty
+
+			default:
+				panic("conditional type mismatch")
+			}
+
+			if !ast.IsTypeEq(e.True.GetType(), ty) {
+				e.True = tryImplicitCast(e.True, ty, &e.Node)
+			}
+			if !ast.IsTypeEq(e.False.GetType(), ty) {
+				e.False = tryImplicitCast(e.False, ty, &e.Node)
+			}
+			e.InferedType = ty
 		}
 	}
 	CheckTypes.WalkArraySubscriptExpr = func(ws ast.WalkStage, e *ast.ArraySubscriptExpr, ctx *ast.WalkContext) {
 		if ws == ast.WalkerBubbleUp {
 			var ty = e.Target.GetType()
-			if aty, yes := ty.(*ast.Array); !yes {
-				panic("target should be array type")
-			} else {
-				e.InferedType = aty.ElemType
+			if aty, yes := ty.(*ast.Array); yes {
+				e.InferedType, _ = ast.Unqualify(aty.Elem())
 				util.Printf(util.Sema, util.Debug, "WalkArraySubscriptExpr %v", e.InferedType)
+			} else if pty, yes := ty.(*ast.Pointer); yes {
+				// E1[E2] is *(E1 + E2)
+				e.InferedType, _ = ast.Unqualify(pty.Source)
+			} else {
+				panic("target should be array or pointer type")
 			}
 
 			if _, yes := e.Sub.GetType().(*ast.IntegerType); !yes {
@@ -491,46 +1246,32 @@ func MakeCheckTypes() ast.AstWalker {
 	}
 	CheckTypes.WalkMemberExpr = func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			if decl, yes := e.Target.(*ast.DeclRefExpr); yes {
-				var sym = ctx.Scope.LookupRecordVar(decl.Name)
-				if sym == nil {
-					panic("invalid record reference")
-				} else {
-					var ty = sym.Type
-					if _, ok := ty.(*ast.Pointer); ok {
-						ty = ty.(*ast.Pointer).Source
-					}
-					var rdty = ty.(*ast.RecordType)
-					util.Printf(util.Sema, util.Debug, "MemberExpr: found rdty %v for %s", rdty, decl.Name)
-					decl.InferedType = rdty
-				}
-			} else {
-				ast.WalkAst(e.Target, CheckTypes, ctx)
-			}
-
-			if decl, yes := e.Member.(*ast.DeclRefExpr); yes {
-				var found = false
-				if rdty, yes := e.Target.GetType().(*ast.RecordType); yes {
-					for _, fty := range rdty.Fields {
-						if fty.Name == decl.Name {
-							decl.InferedType = fty.Base
-							e.InferedType = decl.InferedType
-							found = true
-							break
-						}
-					}
-
-					if !found {
-						panic("invalid member")
-					}
-				} else {
-					panic("invalid member reference")
-				}
+			// the target is any expression of a record, or of a pointer to
+			// record for ->, whose qualifiers are left to codegen
+			ast.WalkAst(e.Target, CheckTypes, ctx)
+			var ty = e.Target.GetType()
+			if pty, yes := ty.(*ast.Pointer); yes && e.PointerDeref {
+				ty = pty.Source
+			}
+			ty, _ = ast.Unqualify(ty)
+
+			var decl = e.Member.(*ast.DeclRefExpr)
+			var rdty, yes = ty.(*ast.RecordType)
+			if !yes {
+				addReport(ast.Error, "sema.invalid-member-reference", e.Start, fmt.Sprintf(err7, ty)).AddRange(e.Target)
+				decl.InferedType = &ast.IntegerType{false, "int"}
+				e.InferedType = decl.InferedType
+				return false
+			}
+			util.Printf(util.Sema, util.Debug, "MemberExpr: found rdty %v", rdty)
 
+			if _, fty := ctx.Scope.LookupField(rdty, decl.Name); fty != nil {
+				decl.InferedType, _ = ast.Unqualify(fty.Base)
 			} else {
-				panic("invalid member reference")
+				addReport(ast.Error, "sema.no-member", decl.Start, fmt.Sprintf(err5, decl.Name, recordName(rdty)))
+				decl.InferedType = &ast.IntegerType{false, "int"}
 			}
-
+			e.InferedType = decl.InferedType
 			return false
 		}
 		return true
@@ -539,6 +1280,27 @@ func MakeCheckTypes() ast.AstWalker {
 		if ws == ast.WalkerPropagate {
 			sym := ctx.Scope.LookupSymbol(e.Name, ast.OrdinaryNS)
 			info.LastFunction = sym
+			info.Switches = nil
+			if e.Body != nil {
+				checkRedefinition(sym, e.Start)
+			}
+
+			var fty = sym.Type.(*ast.Function)
+			adjustParams(fty)
+			for i, arg := range e.Args {
+				var psym = e.Scope.LookupSymbol(arg.Sym, ast.OrdinaryNS)
+				psym.Type = fty.Args[i]
+				if ty, _ := ast.Unqualify(psym.Type); ty != nil {
+					if _, yes := ty.(*ast.VoidType); yes {
+						// an unnamed param is reported at the function
+						var tok = psym.Name
+						if tok.Line == 0 {
+							tok = sym.Name
+						}
+						addReport(ast.Error, "sema.void-parameter", tok, "argument may not have 'void' type")
+					}
+				}
+			}
 		} else {
 			info.LastFunction = nil
 		}
@@ -547,64 +1309,243 @@ func MakeCheckTypes() ast.AstWalker {
 		if ws == ast.WalkerBubbleUp {
 			var funReturnType ast.SymbolType
 			if fty, yes := info.LastFunction.Type.(*ast.Function); yes {
-				funReturnType = fty.Return
+				funReturnType, _ = ast.Unqualify(fty.Return)
 			}
 			if e.Expr != nil && !ast.IsTypeEq(funReturnType, e.Expr.GetType()) {
 				e.Expr = tryImplicitCast(e.Expr, funReturnType, &e.Node)
 			}
 		}
 	}
+	// the condition is promoted before any label is checked, since case
+	// values are converted to the promoted type
+	var switchCondType = func(sw *switchLabels) ast.SymbolType {
+		var e = sw.stmt
+		if !sw.promoted {
+			sw.promoted = true
+			e.Cond = functionOrArrayConversion(e.Cond, &e.Node)
+			if !ast.IsIntegralType(e.Cond.GetType()) {
+				var first, _ = ast.ExprRange(e.Cond)
+				if first.Line == 0 {
+					first = e.Start
+				}
+				addReport(ast.Error, "sema.switch-condition-type", first, fmt.Sprintf(
+					"statement requires expression of integer type ('%s' invalid)", e.Cond.GetType())).AddRange(e.Cond)
+			}
+			e.Cond = promoteNode(e.Cond, &e.Node)
+		}
+		return e.Cond.GetType()
+	}
+	var innermostSwitch = func() *switchLabels {
+		if len(info.Switches) == 0 {
+			return nil
+		}
+		return info.Switches[len(info.Switches)-1]
+	}
+
+	var checkCase = func(e *ast.CaseStmt, ctx *ast.WalkContext) {
+		var sw = innermostSwitch()
+		if sw == nil {
+			addReport(ast.Error, "sema.case-not-in-switch", e.Start, "'case' statement not in switch statement")
+			return
+		}
+
+		var v, tok, ok = evalIntConst(e.ConstExpr, e.Start, ctx.Scope)
+		if !ok {
+			addReport(ast.Error, "sema.not-integer-constant", tok, "expression is not an integer constant expression").
+				AddRange(e.ConstExpr)
+			return
+		}
+
+		e.Value = truncInt(v, switchCondType(sw))
+		if prev, dup := sw.cases[e.Value]; dup {
+			addReport(ast.Error, "sema.duplicate-case", tok, fmt.Sprintf("duplicate case value '%d'", e.Value)).
+				AddRange(e.ConstExpr).
+				AddNote(prev, "previous case is here")
+			return
+		}
+		sw.cases[e.Value] = tok
+	}
+
+	CheckTypes.WalkSwitchStmt = func(ws ast.WalkStage, e *ast.SwitchStmt, ctx *ast.WalkContext) {
+		if ws == ast.WalkerPropagate {
+			var sw = &switchLabels{stmt: e, cases: make(map[int64]lexer.Token)}
+			info.Switches = append(info.Switches, sw)
+		} else {
+			switchCondType(innermostSwitch())
+			info.Switches = info.Switches[:len(info.Switches)-1]
+		}
+	}
+	// labels are checked in the order of source, before the statements
+	// labeled by them, which include the following labels
+	CheckTypes.WalkCaseStmt = func(ws ast.WalkStage, e *ast.CaseStmt, ctx *ast.WalkContext) bool {
+		if ws == ast.WalkerPropagate {
+			ast.WalkAst(e.ConstExpr, CheckTypes, ctx)
+			checkCase(e, ctx)
+			if e.Stmt != nil {
+				ast.WalkAst(e.Stmt, CheckTypes, ctx)
+			}
+			return false
+		}
+		return true
+	}
+	CheckTypes.WalkDefaultStmt = func(ws ast.WalkStage, e *ast.DefaultStmt, ctx *ast.WalkContext) {
+		if ws == ast.WalkerPropagate {
+			var sw = innermostSwitch()
+			if sw == nil {
+				addReport(ast.Error, "sema.default-not-in-switch", e.Start, "'default' statement not in switch statement")
+				return
+			}
+
+			if sw.hasDefault {
+				addReport(ast.Error, "sema.multiple-default", e.Start, "multiple default labels in one switch")
+			}
+			sw.hasDefault = true
+		}
+	}
+	CheckTypes.WalkEnumDecl = func(ws ast.WalkStage, e *ast.EnumDecl, ctx *ast.WalkContext) {
+		if ws == ast.WalkerPropagate {
+			info.NextEnumerator = 0
+		}
+	}
+	CheckTypes.WalkEnumeratorDecl = func(ws ast.WalkStage, e *ast.EnumeratorDecl, ctx *ast.WalkContext) {
+		if ws == ast.WalkerBubbleUp {
+			var sym = ctx.Scope.LookupSymbol(e.Sym, ast.OrdinaryNS)
+			if e.Value != nil {
+				if v, tok, ok := evalIntConst(e.Value, e.Start, ctx.Scope); ok {
+					info.NextEnumerator = v
+				} else {
+					addReport(ast.Error, "sema.not-integer-constant", tok, "expression is not an integer constant expression").
+						AddRange(e.Value)
+				}
+			}
+
+			sym.Type.(*ast.EnumeratorType).Value = info.NextEnumerator
+			info.NextEnumerator++
+		}
+	}
 	CheckTypes.WalkFunctionCall = func(ws ast.WalkStage, e *ast.FunctionCall, ctx *ast.WalkContext) {
 		if ws == ast.WalkerBubbleUp {
+			// the callee is always a pointer to function after the decay
 			e.Func = functionOrArrayConversion(e.Func, &e.Node)
 
-			var ty = e.Func.GetType()
-		done:
-			for {
-				switch ty.(type) {
-				case *ast.Pointer:
-					ty = ty.(*ast.Pointer).Source
-				default:
-					break done
-				}
+			var ty ast.SymbolType
+			if pty, yes := e.Func.GetType().(*ast.Pointer); yes {
+				ty = pty.Source
 			}
 			if ty, yes := ty.(*ast.Function); yes {
-				e.InferedType = ty.Return
-				if len(ty.Args) != len(e.Args) {
-					switch e.Func.(type) {
-					case *ast.ArraySubscriptExpr:
-						var howmany = "many"
-						if len(ty.Args) > len(e.Args) {
-							howmany = "few"
+				e.InferedType, _ = ast.Unqualify(ty.Return)
+				if len(ty.Args) != len(e.Args) && !(ty.IsVariadic && len(e.Args) > len(ty.Args)) {
+					if ice, yes := e.Func.(*ast.ImplicitCastExpr); yes && ice.CastKind == ast.FunctionToPointerDecay {
+						if ref, yes := ice.Expr.(*ast.DeclRefExpr); yes {
+							panic(fmt.Sprintf("no matching function for call to '%s'", ref.Name))
 						}
-						panic(fmt.Sprintf("too %s arguments to function call, expect %d, have %d",
-							howmany, len(ty.Args), len(e.Args)))
-					case *ast.DeclRefExpr:
-						panic(fmt.Sprintf("no matching function for call to '%s'", e.Func.(*ast.DeclRefExpr).Name))
 					}
+
+					var howmany = "many"
+					if len(ty.Args) > len(e.Args) {
+						howmany = "few"
+					}
+					panic(fmt.Sprintf("too %s arguments to function call, expect %d, have %d",
+						howmany, len(ty.Args), len(e.Args)))
 				} else {
 					for i := 0; i < len(ty.Args); i++ {
-						if !ast.IsTypeEq(ty.Args[i], e.Args[i].GetType()) {
-							if ast.IsTypeCompat(ty.Args[i], e.Args[i].GetType()) {
-								e.Args[i] = tryImplicitCast(e.Args[i], ty.Args[i], &e.Node)
+						// as if assigned to the unqualified parameter
+						var pty, _ = ast.Unqualify(ty.Args[i])
+						e.Args[i] = functionOrArrayConversion(e.Args[i], &e.Node)
+						if !ast.IsTypeEq(pty, e.Args[i].GetType()) {
+							if ast.IsTypeCompat(pty, e.Args[i].GetType()) {
+								e.Args[i] = tryImplicitCast(e.Args[i], pty, &e.Node)
 							}
 						}
 					}
+
+					// default argument promotions for the variable arguments
+					for i := len(ty.Args); i < len(e.Args); i++ {
+						e.Args[i] = functionOrArrayConversion(e.Args[i], &e.Node)
+						if _, yes := e.Args[i].GetType().(*ast.FloatType); yes {
+							e.Args[i] = tryImplicitCast(e.Args[i], &ast.DoubleType{}, &e.Node)
+						} else {
+							e.Args[i] = promoteNode(e.Args[i], &e.Node)
+						}
+					}
 				}
 			} else {
-				panic("invalid function type")
+				panic("called object is not a function or function pointer")
+			}
+		}
+	}
+	CheckTypes.WalkBuiltinCallExpr = func(ws ast.WalkStage, e *ast.BuiltinCallExpr, ctx *ast.WalkContext) {
+		if ws == ast.WalkerBubbleUp {
+			var nargs = map[string]int{
+				"__builtin_va_start": 2,
+				"__builtin_va_arg":   1, // plus the type name
+				"__builtin_va_end":   1,
+				"__builtin_va_copy":  2,
+			}
+			if len(e.Args) != nargs[e.Name] {
+				panic(fmt.Sprintf("wrong number of arguments to '%s'", e.Name))
+			}
+
+			// all operands except the last param of va_start are va_list's
+			for i := 0; i < len(e.Args); i++ {
+				if e.Name == "__builtin_va_start" && i == 1 {
+					break
+				}
+				e.Args[i] = functionOrArrayConversion(e.Args[i], &e.Node)
+				if !ast.IsVaList(e.Args[i].GetType()) {
+					panic(fmt.Sprintf("'%s' expects va_list, but '%s' is given", e.Name, e.Args[i].GetType()))
+				}
+			}
+
+			e.InferedType = &ast.VoidType{}
+			switch e.Name {
+			case "__builtin_va_start":
+				if info.LastFunction == nil || !info.LastFunction.Type.(*ast.Function).IsVariadic {
+					panic("'va_start' used in function with fixed args")
+				}
+			case "__builtin_va_arg":
+				e.InferedType = e.Type
 			}
 		}
 	}
 	CheckTypes.WalkCompoundAssignExpr = func(ws ast.WalkStage, e *ast.CompoundAssignExpr, ctx *ast.WalkContext) {
 		if ws == ast.WalkerBubbleUp {
 			e.InferedType = e.LHS.GetType()
-			ast.TypeAssertEq(e.LHS.GetType(), e.RHS.GetType(), "type mismatch")
+			e.RHS = functionOrArrayConversion(e.RHS, &e.Node)
+
+			if _, yes := e.LHS.GetType().(*ast.Pointer); yes {
+				if e.Op != lexer.PLUS_ASSIGN && e.Op != lexer.MINUS_ASSIGN {
+					panic("invalid operands to compound assignment")
+				}
+				ast.TypeAssertCompat(e.RHS.GetType(), &ast.IntegerType{}, "invalid operand to pointer arithmetic")
+				return
+			}
+
+			// `E1 op= E2` is computed as `E1 = E1 op E2`, the right operand is
+			// converted to the computation type, codegen converts E1 likewise
+			var lty = promoteNode(e.LHS, &e.Node).GetType()
+			var rty = promoteNode(e.RHS, &e.Node).GetType()
+			var ty = rty
+			switch e.Op {
+			case lexer.LSHIFT_ASSIGN, lexer.RSHIFT_ASSIGN:
+				ty = lty
+			default:
+				if !ast.IsTypeEq(lty, rty) {
+					var unified bool
+					if ty, unified = unifyType(lty, rty); !unified {
+						panic("invalid type for compound assignment")
+					}
+				}
+			}
+
+			if !ast.IsTypeEq(e.RHS.GetType(), ty) {
+				e.RHS = tryImplicitCast(e.RHS, ty, &e.Node)
+			}
 		}
 	}
 	CheckTypes.WalkCastExpr = func(ws ast.WalkStage, e *ast.CastExpr, ctx *ast.WalkContext) {
 		if ws == ast.WalkerBubbleUp {
-			e.InferedType = e.Type
+			e.InferedType, _ = ast.Unqualify(e.Type)
 		}
 	}
 	CheckTypes.WalkCompoundLiteralExpr = func(ws ast.WalkStage, e *ast.CompoundLiteralExpr, ctx *ast.WalkContext) {
@@ -614,10 +1555,8 @@ func MakeCheckTypes() ast.AstWalker {
 	}
 	CheckTypes.WalkInitListExpr = func(ws ast.WalkStage, e *ast.InitListExpr, ctx *ast.WalkContext) {
 		if ws == ast.WalkerBubbleUp {
-			for i := 0; i < len(e.Inits); i++ {
-				e.Inits[i] = functionOrArrayConversion(e.Inits[i], &e.Node)
-			}
-			// initList itself can not determine type
+			// initList itself can not determine type, elements are converted
+			// when the list is braced for the object it initializes
 			e.InferedType = nil
 		}
 	}
@@ -630,19 +1569,50 @@ func MakeCheckTypes() ast.AstWalker {
 }
 
 // Check all DeclRef is a ref of some of the defineds
+// type of an integer literal by C99 6.4.4.1, the first one of int, long
+// and long long which its value fits in. unsigned ones are candidates only
+// for suffix u, octals and hexadecimals. a decimal too large for any signed
+// type is taken as unsigned long long but not ok, and ty is nil if the value
+// does not fit in 64 bits
+func intLiteralType(tok lexer.Token) (ty *ast.IntegerType, ok bool) {
+	var v, fits = tok.AsUint64()
+	if !fits {
+		return nil, false
+	}
+	var unsigned, longs = tok.IntSuffix()
+	var decimal = tok.AsString()[0] != '0'
+
+	var fitsIn = func(bits int) bool {
+		return bits >= 64 || v < uint64(1)<<uint(bits)
+	}
+	for _, kind := range []string{"int", "long", "long long"}[longs:] {
+		var bits = ast.Target.Width(kind)
+		if !unsigned && fitsIn(bits-1) {
+			return &ast.IntegerType{false, kind}, true
+		}
+		if (unsigned || !decimal) && fitsIn(bits) {
+			return &ast.IntegerType{true, kind}, true
+		}
+	}
+	return &ast.IntegerType{true, "long long"}, false
+}
+
+// e.g. struct P, as records are referred in reports
+func recordName(rdty *ast.RecordType) string {
+	if rdty.Union {
+		return "union " + rdty.Name
+	}
+	return "struct " + rdty.Name
+}
+
 func MakeReferenceResolve() ast.AstWalker {
 
 	const (
 		CNormal int = iota
 		CIsFuncCall
-		CSearchRecord
-		CSearchRecordMember
 	)
 
-	var (
-		state  = CNormal
-		rdName string
-	)
+	var state = CNormal
 
 	var referenceResolve struct {
 		WalkDeclRefExpr  func(ws ast.WalkStage, e *ast.DeclRefExpr, ctx *ast.WalkContext) bool
@@ -650,14 +1620,28 @@ func MakeReferenceResolve() ast.AstWalker {
 		WalkMemberExpr   func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool
 	}
 
+	// members of a record variable are checked here, and those of other
+	// targets with their types by CheckTypes
 	referenceResolve.WalkMemberExpr = func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			state = CSearchRecord
-			ast.WalkAst(e.Target, referenceResolve, ctx)
-			state = CSearchRecordMember
-			ast.WalkAst(e.Member, referenceResolve, ctx)
 			state = CNormal
-			rdName = ""
+			ast.WalkAst(e.Target, referenceResolve, ctx)
+
+			var decl, yes = e.Target.(*ast.DeclRefExpr)
+			if !yes {
+				return false
+			}
+			if sym := ctx.Scope.LookupRecordVar(decl.Name); sym != nil {
+				var ty, _ = ast.Unqualify(sym.Type)
+				if pty, ok := ty.(*ast.Pointer); ok {
+					ty, _ = ast.Unqualify(pty.Source)
+				}
+				var rdty = ty.(*ast.RecordType)
+				var member = e.Member.(*ast.DeclRefExpr)
+				if _, fld := ctx.Scope.LookupField(rdty, member.Name); fld == nil {
+					addReport(ast.Error, "sema.no-member", member.Start, fmt.Sprintf(err5, member.Name, recordName(rdty)))
+				}
+			}
 			return false
 		}
 		return true
@@ -665,46 +1649,11 @@ func MakeReferenceResolve() ast.AstWalker {
 
 	referenceResolve.WalkDeclRefExpr = func(ws ast.WalkStage, e *ast.DeclRefExpr, ctx *ast.WalkContext) bool {
 		if ws == ast.WalkerPropagate {
-			switch state {
-			case CSearchRecord, CSearchRecordMember:
-				if state == CSearchRecord {
-					var sym = ctx.Scope.LookupRecordVar(e.Name)
-					if sym == nil {
-						addReport(ast.Error, e.Start, fmt.Sprintf(err2, e.Name))
-					} else {
-						var ty = sym.Type
-						if _, ok := ty.(*ast.Pointer); ok {
-							ty = ty.(*ast.Pointer).Source
-						}
-						var rdty = ty.(*ast.RecordType)
-						rdName = rdty.Name
-					}
-
+			if sym := ctx.Scope.LookupSymbol(e.Name, ast.AnyNS); sym == nil {
+				if state == CIsFuncCall {
+					reportUndeclared("sema.implicit-function-declaration", fmt.Sprintf(err1, e.Name), e, ctx.Scope)
 				} else {
-					if len(rdName) > 0 {
-						util.Printf("check member %s for %s\n", e.Name, rdName)
-						var rdty = ctx.Scope.LookupNamedTypeRecursive(rdName, ast.TagNS).(*ast.RecordType)
-						var meetMember = false
-						for _, fld := range rdty.Fields {
-							if fld.Name == e.Name {
-								meetMember = true
-								break
-							}
-						}
-
-						if !meetMember {
-							addReport(ast.Error, e.Start, fmt.Sprintf(err5, e.Name, "record "+rdName))
-						}
-					}
-				}
-
-			default:
-				if sym := ctx.Scope.LookupSymbol(e.Name, ast.AnyNS); sym == nil {
-					if state == CIsFuncCall {
-						addReport(ast.Error, e.Start, fmt.Sprintf(err1, e.Name))
-					} else {
-						addReport(ast.Error, e.Start, fmt.Sprintf(err2, e.Name))
-					}
+					reportUndeclared("sema.undeclared-identifier", fmt.Sprintf(err2, e.Name), e, ctx.Scope)
 				}
 			}
 		} else {
@@ -725,13 +1674,196 @@ func MakeReferenceResolve() ast.AstWalker {
 	return referenceResolve
 }
 
+// warnings of variables and parameters never referred to, and of local
+// variables shadowing others
+func MakeCheckUnused() ast.AstWalker {
+	var checkUnused struct {
+		WalkFunctionDecl func(ws ast.WalkStage, e *ast.FunctionDecl, ctx *ast.WalkContext)
+		WalkParamDecl    func(ws ast.WalkStage, e *ast.ParamDecl, ctx *ast.WalkContext)
+		WalkVariableDecl func(ws ast.WalkStage, e *ast.VariableDecl, ctx *ast.WalkContext)
+		WalkDeclRefExpr  func(ws ast.WalkStage, e *ast.DeclRefExpr, ctx *ast.WalkContext)
+		WalkMemberExpr   func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool
+	}
+
+	var (
+		body   *ast.FunctionDecl // function whose body is being checked
+		protos int               // depth of prototypes declared in body
+		params []*ast.Symbol
+		locals []*ast.Symbol
+		used   map[*ast.Symbol]bool
+	)
+
+	// the scope declaring sym, which is visible in scope
+	var scopeOf = func(sym *ast.Symbol, scope *ast.SymbolScope) *ast.SymbolScope {
+		for ; scope != nil; scope = scope.Parent {
+			for _, s := range scope.Symbols {
+				if s == sym {
+					return scope
+				}
+			}
+		}
+		return nil
+	}
+
+	checkUnused.WalkFunctionDecl = func(ws ast.WalkStage, e *ast.FunctionDecl, ctx *ast.WalkContext) {
+		switch {
+		case e.Body == nil && ws == ast.WalkerPropagate:
+			protos++
+		case e.Body == nil:
+			protos--
+		case ws == ast.WalkerPropagate:
+			body, params, locals, used = e, nil, nil, make(map[*ast.Symbol]bool)
+		default:
+			for _, sym := range params {
+				if !used[sym] {
+					addReport(ast.Warning, "sema.unused-parameter", sym.Name,
+						fmt.Sprintf("unused parameter '%s'", sym.Name.AsString()))
+				}
+			}
+			for _, sym := range locals {
+				if !used[sym] {
+					addReport(ast.Warning, "sema.unused-variable", sym.Name,
+						fmt.Sprintf("unused variable '%s'", sym.Name.AsString()))
+				}
+			}
+			body = nil
+		}
+	}
+
+	checkUnused.WalkParamDecl = func(ws ast.WalkStage, e *ast.ParamDecl, ctx *ast.WalkContext) {
+		if ws == ast.WalkerPropagate && body != nil && protos == 0 && !strings.HasPrefix(e.Sym, "!") {
+			params = append(params, ctx.Scope.LookupSymbol(e.Sym, ast.OrdinaryNS))
+		}
+	}
+
+	checkUnused.WalkVariableDecl = func(ws ast.WalkStage, e *ast.VariableDecl, ctx *ast.WalkContext) {
+		if ws != ast.WalkerPropagate || body == nil || protos > 0 {
+			return
+		}
+		var sym = ctx.Scope.LookupSymbol(e.Sym, ast.OrdinaryNS)
+		if _, yes := sym.Type.(*ast.Function); yes || sym.Storage == ast.External || sym.Storage == ast.Typedef {
+			return
+		}
+		locals = append(locals, sym)
+
+		var outer = scopeOf(sym, ctx.Scope).Parent
+		if prev := outer.LookupSymbol(e.Sym, ast.OrdinaryNS); prev != nil && prev.Storage != ast.Typedef {
+			if _, yes := prev.Type.(*ast.Function); yes {
+				return
+			}
+			var desc = "declaration shadows a local variable"
+			if scopeOf(prev, outer).Parent == nil {
+				desc = "declaration shadows a variable in the global scope"
+			}
+			addReport(ast.Warning, "sema.shadow", sym.Name, desc).AddNote(prev.Name, "previous declaration is here")
+		}
+	}
+
+	checkUnused.WalkDeclRefExpr = func(ws ast.WalkStage, e *ast.DeclRefExpr, ctx *ast.WalkContext) {
+		if ws == ast.WalkerPropagate && body != nil {
+			if sym := ctx.Scope.LookupSymbol(e.Name, ast.OrdinaryNS); sym != nil {
+				used[sym] = true
+			}
+		}
+	}
+
+	// members are not variables
+	checkUnused.WalkMemberExpr = func(ws ast.WalkStage, e *ast.MemberExpr, ctx *ast.WalkContext) bool {
+		if ws == ast.WalkerPropagate {
+			ast.WalkAst(e.Target, checkUnused, ctx)
+			return false
+		}
+		return true
+	}
+
+	return checkUnused
+}
+
 var walkers []ast.AstWalker
 
+// later walkers rely on references resolved by earlier ones, so walking
+// stops at the first walker reporting errors
 func RunWalkers(top ast.Ast) {
 	for _, w := range walkers {
+		var n = len(Reports)
 		util.Printf("run walker: %v\n", reflect.TypeOf(w))
 		ast.WalkAst(top, w)
 		util.Printf("done walker: %v\n", reflect.TypeOf(w))
+		if tu, ok := top.(*ast.TranslationUnit); ok {
+			ast.SetReportsFile(Reports[n:], tu.Filename)
+		}
+		Reports = append(Reports[:n], ast.FilterReports(Reports[n:])...)
+		if ast.HasErrors(Reports[n:]) {
+			return
+		}
+	}
+}
+
+// external declarations of translation units linked into one program must
+// have compatible types, and each external object or function is defined
+// in at most one of them
+func CheckLinkage(units []*ast.TranslationUnit) {
+	type external struct {
+		Unit    *ast.TranslationUnit
+		Sym     *ast.Symbol
+		Type    ast.SymbolType
+		Defined bool
+	}
+	var externals = make(map[string]*external)
+
+	for _, tu := range units {
+		// declarations of a name in one unit are merged into one symbol,
+		// which is defined by a function body or an object declared without
+		// extern, tentatively or not
+		var defined = make(map[string]bool)
+		var names []string
+		for _, decl := range tu.Decls {
+			var name string
+			var isDefinition bool
+			switch e := decl.(type) {
+			case *ast.FunctionDecl:
+				name, isDefinition = e.Name, e.Body != nil
+			case *ast.VariableDecl:
+				var sym = tu.Ctx.Top.LookupSymbol(e.Sym, ast.OrdinaryNS)
+				name, isDefinition = e.Sym, e.Init != nil || sym.Storage != ast.External
+			default:
+				continue
+			}
+			if _, seen := defined[name]; !seen {
+				names = append(names, name)
+			}
+			defined[name] = defined[name] || isDefinition
+		}
+
+		for _, name := range names {
+			var sym = tu.Ctx.Top.LookupSymbol(name, ast.OrdinaryNS)
+			if sym == nil || sym.Storage == ast.Static || sym.Storage == ast.Typedef {
+				continue
+			}
+
+			var prev, seen = externals[name]
+			if !seen {
+				externals[name] = &external{tu, sym, sym.Type, defined[name]}
+				continue
+			}
+
+			var report = func(id, desc, note string) {
+				var r = addReport(ast.Error, id, sym.Name, desc).AddNote(prev.Sym.Name, note)
+				r.File, r.Notes[0].File = tu.Filename, prev.Unit.Filename
+			}
+			if ty, ok := ast.CompositeType(prev.Type, sym.Type); !ok {
+				report("link.conflicting-types", fmt.Sprintf("conflicting types for '%s'", name),
+					fmt.Sprintf("previous declaration as '%v' is here", prev.Type))
+			} else {
+				prev.Type = ty
+			}
+
+			if prev.Defined && defined[name] {
+				report("link.duplicate-definition", fmt.Sprintf("duplicate definition of '%s'", name), "previous definition is here")
+			} else if defined[name] {
+				prev.Unit, prev.Sym, prev.Defined = tu, sym, true
+			}
+		}
 	}
 }
 
@@ -745,11 +1877,9 @@ func (a byPos) Less(i, j int) bool {
 
 func DumpReports() {
 	sort.Stable(byPos(Reports))
-	for _, r := range Reports {
-		fmt.Printf("error: %v\n", fmt.Sprintf("%d:%d, %s", r.Line, r.Column, r.Desc))
-	}
+	ast.DumpReports(Reports)
 }
 
 func init() {
-	walkers = []ast.AstWalker{MakeReferenceResolve(), MakeCheckLoop(), MakeCheckTypes()}
+	walkers = []ast.AstWalker{MakeReferenceResolve(), MakeCheckLoop(), MakeCheckTypes(), MakeCheckUnused()}
 }
@@ -2,11 +2,13 @@ package sema
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/yanhao/sc/ast"
+	"github.com/yanhao/sc/lexer"
 	"github.com/yanhao/sc/parser"
 )
 
@@ -364,8 +366,8 @@ int main()
 		if len(Reports) != 0 {
 			t.Errorf("should have 0 reports")
 		}
-		if casts := collectCasts(top); len(casts) != 8 {
-			t.Errorf("should have 8 casts, but %d", len(casts))
+		if casts := collectCasts(top); len(casts) != 9 {
+			t.Errorf("should have 9 casts, but %d", len(casts))
 		}
 	}
 }
@@ -399,8 +401,8 @@ int main()
 		if len(Reports) != 0 {
 			t.Errorf("should have 0 reports")
 		}
-		if casts := collectCasts(top); len(casts) != 8 {
-			t.Errorf("should have 8 casts, but %d", len(casts))
+		if casts := collectCasts(top); len(casts) != 9 {
+			t.Errorf("should have 9 casts, but %d", len(casts))
 		}
 	}
 }
@@ -474,6 +476,591 @@ int main()
 	}
 }
 
+func TestCheckTypes10(t *testing.T) {
+	var text = `
+int printf(char *fmt, ...);
+
+int main()
+{
+	float f = 1.5f;
+	char c = 'a';
+	double d = f;
+	int i = d * 2;
+	d = i + 0.5;
+	printf("%f %d %d", f, c, 2);
+}
+`
+	top, p := testTemplate(t, text)
+	if top == nil {
+		t.Errorf("parse failed")
+	} else {
+		ast.WalkAst(top, MakeCheckTypes())
+		p.DumpAst()
+		DumpReports()
+		if len(Reports) != 0 {
+			t.Errorf("should have 0 reports")
+		}
+
+		var expect = []ast.CastKind{
+			ast.FloatingCast,           // double d = f
+			ast.FloatingToIntegralCast, // int i = d * 2
+			ast.IntegralToFloatingCast, // 2 in d * 2
+			ast.IntegralToFloatingCast, // i + 0.5
+			ast.FunctionToPointerDecay, // printf
+			ast.ArrayToPointerDecay,    // string literal decays to char*
+			ast.FloatingCast,           // f promoted to double for printf
+			ast.IntegralCast,           // c promoted to int for printf
+		}
+		var casts = collectCasts(top)
+		if len(casts) != len(expect) {
+			t.Fatalf("should have %d casts, but %d", len(expect), len(casts))
+		}
+		for i, c := range casts {
+			if c.(*ast.ImplicitCastExpr).CastKind != expect[i] {
+				t.Errorf("cast #%d should be %v, but %v", i, expect[i], c.(*ast.ImplicitCastExpr).CastKind)
+			}
+		}
+	}
+}
+
+func TestCheckTypes11(t *testing.T) {
+	var text = `
+void foo(int i, char c, unsigned int u, double d, int *p, void *vp)
+{
+	i ? c : d;
+	i ? c : u;
+	i ? p : 0;
+	i ? 0 : p;
+	i ? p : vp;
+	i ? foo(i, c, u, d, p, vp) : foo(i, c, u, d, p, vp);
+	i && d;
+}
+`
+	top, p := testTemplate(t, text)
+	if top == nil {
+		t.Errorf("parse failed")
+	} else {
+		ast.WalkAst(top, MakeCheckTypes())
+		p.DumpAst()
+		DumpReports()
+		if len(Reports) != 0 {
+			t.Errorf("should have 0 reports")
+		}
+
+		var expect = []string{"double", "unsigned int", "int*", "int*", "void*", "void", "int"}
+		var stmts = top.(*ast.TranslationUnit).Decls[0].(*ast.FunctionDecl).Body.Stmts
+		for i, ty := range expect {
+			var e = stmts[i].(*ast.ExprStmt).Expr
+			if e.GetType().String() != ty {
+				t.Errorf("expr #%d should have type %s, but %s", i, ty, e.GetType())
+			}
+		}
+	}
+}
+
+func TestCheckTypes12(t *testing.T) {
+	var text = `
+int sum(int m[][4], int n)
+{
+	m[1][2];
+	m[1];
+	m;
+	int a[3][4];
+	sum(a, 3);
+	a[1];
+	int b[][2] = {1, 2, 3};
+	b[1];
+}
+`
+	top, p := testTemplate(t, text)
+	if top == nil {
+		t.Errorf("parse failed")
+	} else {
+		ast.WalkAst(top, MakeCheckTypes())
+		p.DumpAst()
+		DumpReports()
+		if len(Reports) != 0 {
+			t.Errorf("should have 0 reports")
+		}
+
+		var fn = top.(*ast.TranslationUnit).Decls[0].(*ast.FunctionDecl)
+		var fty = fn.Scope.LookupSymbol("sum", ast.OrdinaryNS).Type.(*ast.Function)
+		if fty.Args[0].String() != "(int[]*)" {
+			t.Errorf("param should be adjusted to pointer, but %s", fty.Args[0])
+		}
+
+		var expect = map[int]string{0: "int", 1: "int[]", 2: "(int[]*)", 5: "int[]", 7: "int[]"}
+		var stmts = fn.Body.Stmts
+		for i, ty := range expect {
+			var e = stmts[i].(*ast.ExprStmt).Expr
+			if e.GetType().String() != ty {
+				t.Errorf("expr #%d should have type %s, but %s", i, ty, e.GetType())
+			}
+		}
+
+		var call = stmts[4].(*ast.ExprStmt).Expr.(*ast.FunctionCall)
+		if cast, yes := call.Args[0].(*ast.ImplicitCastExpr); !yes || cast.CastKind != ast.ArrayToPointerDecay {
+			t.Errorf("array argument should decay to pointer")
+		}
+
+		var b = fn.Body.Scope.LookupSymbol("b", ast.OrdinaryNS).Type.(*ast.Array)
+		if b.Len() != 2 {
+			t.Errorf("size of b should be 2, but %d", b.Len())
+		}
+	}
+}
+
+func TestCheckTypes13(t *testing.T) {
+	var text = `
+int f(int x) { return x; }
+
+int x = 1;
+int a[4] = {1, 2};
+int *p = &a[1] + 1;
+int (*fp)(int) = f;
+char s[] = "abc";
+char too[2] = "abc";
+int y = x + 1;
+int z = f(2);
+int w = sizeof(a) * 2;
+`
+	top, p := testTemplate(t, text)
+	if top == nil {
+		t.Errorf("parse failed")
+	} else {
+		ast.WalkAst(top, MakeCheckTypes())
+		p.DumpAst()
+		DumpReports()
+		var expect = []string{
+			"initializer-string for char array is too long",
+			"initializer element is not a compile-time constant",
+			"initializer element is not a compile-time constant",
+		}
+		if len(Reports) != len(expect) {
+			t.Errorf("should have %d reports", len(expect))
+		} else {
+			for i, r := range Reports {
+				if r.Desc != expect[i] {
+					t.Errorf("report #%d should be '%s', but '%s'", i, expect[i], r.Desc)
+				}
+			}
+			if Reports[1].Line != 10 || Reports[2].Line != 11 {
+				t.Errorf("reports are at wrong place")
+			}
+		}
+
+		var s = top.(*ast.TranslationUnit).Ctx.Top.LookupSymbol("s", ast.OrdinaryNS).Type.(*ast.Array)
+		if s.Len() != 4 {
+			t.Errorf("size of s should be 4, but %d", s.Len())
+		}
+	}
+}
+
+func TestCheckTypes14(t *testing.T) {
+	var text = `
+int x;
+int x = 1;
+int x = 2;
+int f(int v);
+int f(int v) { return v; }
+int f(int v) { return -v; }
+
+int main()
+{
+	int y = 1;
+	static int *p = &x;
+	static int z = y;
+	return 0;
+}
+`
+	top, p := testTemplate(t, text)
+	if top == nil {
+		t.Errorf("parse failed")
+	} else {
+		ast.WalkAst(top, MakeCheckTypes())
+		p.DumpAst()
+		DumpReports()
+		var expect = []string{
+			"redefinition of 'x'",
+			"redefinition of 'f'",
+			"initializer element is not a compile-time constant",
+		}
+		if len(Reports) != len(expect) {
+			t.Fatalf("should have %d reports", len(expect))
+		}
+		for i, r := range Reports {
+			if r.Desc != expect[i] {
+				t.Errorf("report #%d should be '%s', but '%s'", i, expect[i], r.Desc)
+			}
+		}
+	}
+}
+
+func TestCheckTypes15(t *testing.T) {
+	var text = `
+enum { A = 1, B, C = A + 4 };
+
+int f(int n, int v)
+{
+	switch (n) {
+	case 2:
+		break;
+	case B:
+		break;
+	case (unsigned char)261:
+		break;
+	case C:
+	default:
+		switch (v) {
+		case 2:
+		default:
+			break;
+		}
+		break;
+	case v:
+		break;
+	default:
+		break;
+	}
+	return 0;
+}
+`
+	top, p := testTemplate(t, text)
+	if top == nil {
+		t.Errorf("parse failed")
+	} else {
+		ast.WalkAst(top, MakeCheckTypes())
+		p.DumpAst()
+		DumpReports()
+		var expect = []string{
+			"duplicate case value '2'",
+			"duplicate case value '5'",
+			"expression is not an integer constant expression",
+			"multiple default labels in one switch",
+		}
+		if len(Reports) != len(expect) {
+			t.Fatalf("should have %d reports", len(expect))
+		}
+		for i, r := range Reports {
+			if r.Desc != expect[i] {
+				t.Errorf("report #%d should be '%s', but '%s'", i, expect[i], r.Desc)
+			}
+		}
+	}
+}
+
+func TestCheckTypes16(t *testing.T) {
+	var text = `
+int f(long n)
+{
+	switch (n) {
+	case 1:
+	case 2 - 1:
+		break;
+	case 4294967296:
+	case 0x100000000L:
+		break;
+	}
+	return 0;
+}
+`
+	top, _ := testTemplate(t, text)
+	if top == nil {
+		t.Fatalf("parse failed")
+	}
+	ast.WalkAst(top, MakeCheckTypes())
+
+	// labels are taken in source order, so the later one is the duplicate
+	var expect = []struct {
+		desc           string
+		line, noteLine int
+	}{
+		{"duplicate case value '1'", 6, 5},
+		{"duplicate case value '4294967296'", 9, 8},
+	}
+	if len(Reports) != len(expect) {
+		t.Fatalf("should have %d reports, but %v", len(expect), Reports)
+	}
+	for i, r := range Reports {
+		var e = expect[i]
+		if r.Desc != e.desc || r.Line != e.line {
+			t.Errorf("report #%d should be '%s' at line %d, but '%s' at line %d", i, e.desc, e.line, r.Desc, r.Line)
+		}
+		if len(r.Notes) != 1 || r.Notes[0].Desc != "previous case is here" || r.Notes[0].Line != e.noteLine {
+			t.Errorf("report #%d should have a note of the previous case at line %d, but %v", i, e.noteLine, r.Notes)
+		}
+	}
+}
+
+func TestLinkage(t *testing.T) {
+	var texts = []string{`
+extern int shared;
+int counter;
+int scale(int v);
+static int helper(int v) { return v; }
+extern int table[];
+int main() { return scale(shared) + helper(counter); }
+`, `
+int shared = 4;
+static int helper(double v) { return 1; }
+int table[3];
+int scale(int v) { return v * 2; }
+`, `
+double shared;
+int counter;
+long scale(int v);
+int table[4];
+int main() { return 0; }
+`}
+
+	var units []*ast.TranslationUnit
+	for i, text := range texts {
+		opts := parser.ParseOption{
+			Filename: fmt.Sprintf("unit%d.c", i),
+		}
+		opts.Reader = strings.NewReader(text)
+		var p = parser.NewParser()
+		units = append(units, p.Parse(&opts).(*ast.TranslationUnit))
+		if len(p.Reports) > 0 {
+			t.Fatalf("unit%d.c parse failed", i)
+		}
+	}
+
+	Reports = nil
+	CheckLinkage(units)
+	DumpReports()
+	// reports are made at unit2.c, with notes at the previous declarations
+	var expect = [][3]string{
+		{"conflicting types for 'shared'", "previous declaration as 'int' is here", "unit1.c:2"},
+		{"duplicate definition of 'shared'", "previous definition is here", "unit1.c:2"},
+		{"duplicate definition of 'counter'", "previous definition is here", "unit0.c:3"},
+		{"conflicting types for 'scale'", "previous declaration as 'int (int)' is here", "unit1.c:5"},
+		{"conflicting types for 'table'", "previous declaration as 'int[]' is here", "unit1.c:4"},
+		{"duplicate definition of 'table'", "previous definition is here", "unit1.c:4"},
+		{"duplicate definition of 'main'", "previous definition is here", "unit0.c:7"},
+	}
+	if len(Reports) != len(expect) {
+		t.Fatalf("should have %d reports", len(expect))
+	}
+	for i, r := range Reports {
+		if r.Desc != expect[i][0] || r.File != "unit2.c" {
+			t.Errorf("report #%d should be '%s' of unit2.c, but '%s' of %s", i, expect[i][0], r.Desc, r.File)
+		}
+		if len(r.Notes) != 1 {
+			t.Fatalf("report #%d should have a note", i)
+		}
+		var n = r.Notes[0]
+		if where := fmt.Sprintf("%s:%d", n.File, n.Line); n.Desc != expect[i][1] || where != expect[i][2] {
+			t.Errorf("note of report #%d should be '%s' at %s, but '%s' at %s", i, expect[i][1], expect[i][2], n.Desc, where)
+		}
+	}
+}
+
+func TestRenderReports(t *testing.T) {
+	var texts = []string{`
+int count;
+int main() {
+	return 2 * cont;
+}
+`, `
+int twice(int v) { return v * 2; }
+int twice(int v) { return v + v; }
+`, `
+struct P { int x; } p;
+int n = 1;
+int m = 2 + n * 3;
+int f(int a) {
+	switch (p) {
+	case a + 1:
+		break;
+	}
+	return (a + 1).x;
+}
+`}
+	var expect = []string{`./test.txt:4:13: error: use of undeclared identifier 'cont'; did you mean 'count'?
+	return 2 * cont;
+	           ^~~~
+	           count
+./test.txt:2:5: note: 'count' declared here
+int count;
+    ^~~~~
+`, `./test.txt:3:5: error: redefinition of 'twice'
+int twice(int v) { return v + v; }
+    ^~~~~
+./test.txt:2:5: note: previous definition is here
+int twice(int v) { return v * 2; }
+    ^~~~~
+`, `./test.txt:4:13: error: initializer element is not a compile-time constant
+int m = 2 + n * 3;
+        ~~~~^~~~~
+./test.txt:7:7: error: expression is not an integer constant expression
+	case a + 1:
+	     ^~~~~
+./test.txt:6:10: error: statement requires expression of integer type ('struct P{x}' invalid)
+	switch (p) {
+	        ^
+./test.txt:10:16: error: member reference base type 'int' is not a structure or union
+	return (a + 1).x;
+	       ~~~~~~~^
+`}
+
+	ast.ColorReports = false
+	for i, text := range texts {
+		var top, _ = testTemplate(t, text)
+		ast.AddSource("./test.txt", []byte(text))
+		RunWalkers(top)
+		var sb strings.Builder
+		for _, r := range Reports {
+			ast.RenderReport(&sb, r)
+		}
+		if sb.String() != expect[i] {
+			t.Errorf("text #%d should be reported as\n%s\nbut\n%s", i, expect[i], sb.String())
+		}
+	}
+}
+
+func TestIntLiteralTypes(t *testing.T) {
+	var cases = []struct {
+		lit, lp64, ilp32 string
+	}{
+		{"42", "int", "int"},
+		{"2147483648", "long", "long long"},
+		{"4294967295", "long", "long long"},
+		{"0x7FFFFFFF", "int", "int"},
+		{"0xFFFFFFFF", "unsigned int", "unsigned int"},
+		{"0x100000000", "long", "long long"},
+		{"0xFFFFFFFFFFFFFFFF", "unsigned long", "unsigned long long"},
+		{"42u", "unsigned int", "unsigned int"},
+		{"4294967296u", "unsigned long", "unsigned long long"},
+		{"42l", "long", "long"},
+		{"42ul", "unsigned long", "unsigned long"},
+		{"42LL", "long long", "long long"},
+		{"42uLL", "unsigned long long", "unsigned long long"},
+	}
+
+	var saved = ast.Target
+	defer func() { ast.Target = saved }()
+	for _, c := range cases {
+		var tok = lexer.MakeToken(lexer.INT_LITERAL, c.lit)
+		for _, target := range []string{"x86_64-pc-linux-gnu", "i686-pc-linux-gnu"} {
+			ast.Target = ast.TargetFor(target, 0)
+			var expect = c.lp64
+			if target[0] == 'i' {
+				expect = c.ilp32
+			}
+			if ty, ok := intLiteralType(tok); !ok || ty.String() != expect {
+				t.Errorf("%s should be %s on %s, but %v", c.lit, expect, target, ty)
+			}
+		}
+	}
+
+	if ty, ok := intLiteralType(lexer.MakeToken(lexer.INT_LITERAL, "18446744073709551615")); ok || ty.String() != "unsigned long long" {
+		t.Errorf("a decimal too large for long long should be unsigned long long, but not ok")
+	}
+	if ty, _ := intLiteralType(lexer.MakeToken(lexer.INT_LITERAL, "18446744073709551616")); ty != nil {
+		t.Errorf("a literal too large for 64 bits should have no type")
+	}
+}
+
+func TestMemberReports(t *testing.T) {
+	var text = `
+struct P { int x; int y; };
+struct Q { const struct P *p; };
+int f(volatile struct Q *q, const struct P ps[2], int n)
+{
+	return q->p->x + ps[1].y + q->p->z + n.x;
+}
+`
+	var expect = []struct {
+		id, desc string
+	}{
+		{"sema.no-member", "no member named 'z' in 'struct P'"},
+		{"sema.invalid-member-reference", "member reference base type 'int' is not a structure or union"},
+	}
+
+	var top, _ = testTemplate(t, text)
+	RunWalkers(top)
+	if len(Reports) != len(expect) {
+		t.Fatalf("should have %d reports, but %v", len(expect), Reports)
+	}
+	for i, r := range Reports {
+		if r.ID != expect[i].id || r.Desc != expect[i].desc {
+			t.Errorf("report #%d should be '%s' of %s, but '%s' of %s", i, expect[i].desc, expect[i].id, r.Desc, r.ID)
+		}
+	}
+}
+
+func TestVoidParams(t *testing.T) {
+	var text = `
+int f(void);
+int g(int a, void b) { return a; }
+int h(int, void);
+int main(void) { return f(); }
+`
+	var top, _ = testTemplate(t, text)
+	RunWalkers(top)
+	if len(Reports) != 2 {
+		t.Fatalf("should have 2 reports, but %v", Reports)
+	}
+	for i, line := range []int{3, 4} {
+		if r := Reports[i]; r.ID != "sema.void-parameter" || r.Line != line {
+			t.Errorf("void param should be reported at line %d, but %s at %d", line, r.ID, r.Line)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	var text = `
+int n;
+int f(int a, int b) {
+	int x;
+	int n = b;
+#pragma sc diagnostic push
+#pragma sc diagnostic ignored "unused-variable"
+	int y;
+#pragma sc diagnostic pop
+	int z;
+	return n;
+}
+`
+	var expect = []struct {
+		kind ast.ReportKind
+		id   string
+		line int
+	}{
+		{ast.Warning, "sema.shadow", 5},
+		{ast.Error, "sema.unused-variable", 4},
+		{ast.Error, "sema.unused-variable", 10},
+	}
+
+	// unused parameters are left to -Wextra, and unused variables are
+	// reported when their scopes end
+	for _, opt := range []string{"shadow", "error=unused-variable"} {
+		if err := ast.SetWarningOption(opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer ast.SetWarningOption("no-shadow")
+	defer ast.SetWarningOption("no-error=unused-variable")
+
+	var top, _ = testTemplate(t, text)
+	RunWalkers(top)
+
+	if len(Reports) != len(expect) {
+		t.Fatalf("should have %d reports, but %v", len(expect), Reports)
+	}
+	for i, r := range Reports {
+		var e = expect[i]
+		if r.Kind != e.kind || r.ID != e.id || r.Line != e.line {
+			t.Errorf("report #%d should be %v of %s at line %d, but %v of %s at line %d", i, e.kind, e.id, e.line,
+				r.Kind, r.ID, r.Line)
+		}
+	}
+	if err := ast.SetWarningOption("no-undeclared-identifier"); err == nil {
+		t.Errorf("errors should not be disabled")
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	os.Exit(m.Run())